@@ -90,6 +90,7 @@ update_lock_file(
     excludes = [{excludes}],
     repofile = "{repofile}",
     nobest = {nobest},
+    weak_deps = {weak_deps},
     cache_dir = {cache_dir},
     architectures = {architectures},
     visibility = ["//visibility:public"],
@@ -120,6 +121,7 @@ def _alias_repository_impl(repository_ctx):
             excludes = ", ".join(["'{}'".format(x) for x in repository_ctx.attr.excludes]),
             repofile = repofile,
             nobest = "True" if repository_ctx.attr.nobest else "False",
+            weak_deps = "True" if repository_ctx.attr.weak_deps else "False",
             architectures = repr(repository_ctx.attr.architectures),
         ),
     )
@@ -152,6 +154,7 @@ _alias_repository = repository_rule(
         "repofile": attr.label(),
         "repository_prefix": attr.string(),
         "nobest": attr.bool(default = False),
+        "weak_deps": attr.bool(default = False),
         "cache_dir": attr.string(),
         "architectures": attr.string_list(),
     },
@@ -179,6 +182,7 @@ def _handle_lock_file(config, module_ctx, registered_rpms = {}):
         "repofile": config.repofile,
         "repository_prefix": config.rpm_repository_prefix,
         "nobest": config.nobest,
+        "weak_deps": config.weak_deps,
         "architectures": _get_architectures(config.architecture, config.architectures),
     }
 
@@ -242,6 +246,9 @@ def _add_rpm_repository(config, rpm, lock_file_json, registered_rpms):
     if name in registered_rpms:
         return registered_rpms[name]
 
+    # informational only, weak dependencies are regular dependencies of the rpms
+    rpm.pop("weak_only", None)
+
     repository = rpm.pop("repository")
     mirrors = lock_file_json.get("repositories", {}).get(repository, None)
     if mirrors == None:
@@ -400,6 +407,10 @@ The lock file content is as:
             doc = "Allow picking versions which are not the newest",
             default = False,
         ),
+        "weak_deps": attr.bool(
+            doc = "Install weak dependencies (Recommends and Supplements) where possible",
+            default = False,
+        ),
         "ignore_deps": attr.bool(
             doc = "Don't include dependencies in resulting repositories",
             default = False,
@@ -17,6 +17,8 @@ def _collect_lockfile_args(ctx):
 
     if ctx.attr.nobest:
         lockfile_args.append("--nobest")
+    if ctx.attr.weak_deps:
+        lockfile_args.append("--with-weak-deps")
 
     if ctx.attr.cache_dir:
         lockfile_args.extend(["--cache-dir", ctx.attr.cache_dir])
@@ -69,6 +71,7 @@ update_lock_file = rule(
         "excludes": attr.string_list(),
         "repofile": attr.string(),
         "nobest": attr.bool(default = False),
+        "weak_deps": attr.bool(default = False),
         "cache_dir": attr.string(),
         "architectures": attr.string_list(),
         "_runner": attr.label(allow_single_file = True, default = Label("//bazeldnf/private:update-lock-file.sh")),
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rmohr/bazeldnf/pkg/repo"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type cacheOpts struct {
+	all bool
+}
+
+var cacheopts = cacheOpts{}
+
+func NewCacheCmd() *cobra.Command {
+	cacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Inspect and clean up the repository metadata cache",
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List cached repositories with their size and the age of their metadata",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cached, err := repo.NewCacheHelper().CachedRepositories()
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "KEY\tREPOSITORY\tARCH\tREVISION\tAGE\tSIZE\tUNREFERENCED")
+			for _, c := range cached {
+				name := orDash(c.Name)
+				if c.Orphaned {
+					name = "(orphaned)"
+				}
+				age := "never fetched"
+				if !c.Fetched.IsZero() {
+					age = time.Since(c.Fetched).Round(time.Minute).String()
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", c.Key, name, orDash(c.Arch), orDash(c.Revision), age, toReadableSize(c.Size), toReadableSize(c.UnreferencedSize))
+			}
+			return w.Flush()
+		},
+	}
+
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove cached files which are not referenced by the current repomd.xml of their repository, and orphaned cache directories",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			helper := repo.NewCacheHelper()
+			cached, err := helper.CachedRepositories()
+			if err != nil {
+				return err
+			}
+			var freed int64
+			for _, c := range cached {
+				info, err := helper.GarbageCollect(c.Key)
+				if err != nil {
+					return err
+				}
+				for _, name := range info.Unreferenced {
+					logrus.Debugf("Removed %s from %s", name, info.Dir)
+				}
+				if info.Orphaned {
+					logrus.Infof("Removed orphaned cache directory %s", info.Key)
+				} else if len(info.Unreferenced) > 0 {
+					logrus.Infof("Removed %d files of %s (%s)", len(info.Unreferenced), orDash(info.Name), info.Key)
+				}
+				freed += info.UnreferencedSize
+			}
+			logrus.Infof("Freed %s", toReadableSize(freed))
+			return nil
+		},
+	}
+
+	cleanCmd := &cobra.Command{
+		Use:   "clean [repository or key...]",
+		Short: "Remove the cached metadata of the given repositories",
+		Long:  "Remove the cached metadata of the given repositories. Repositories are selected by name, which removes the metadata of all repositories with that name, or by the key of their cache directory. Cache directories written by older bazeldnf versions are named after their repository, so that their key is the name of the repository.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 && !cacheopts.all {
+				return fmt.Errorf("either specify the repositories to remove or pass --all")
+			}
+			helper := repo.NewCacheHelper()
+			cached, err := helper.CachedRepositories()
+			if err != nil {
+				return err
+			}
+			selected := map[string]bool{}
+			for _, arg := range args {
+				selected[arg] = true
+			}
+			removed := map[string]bool{}
+			for _, c := range cached {
+				if !cacheopts.all && !selected[c.Key] && !selected[c.Name] {
+					continue
+				}
+				if err := helper.RemoveCachedRepo(c.Key); err != nil {
+					return err
+				}
+				removed[c.Key], removed[c.Name] = true, true
+				logrus.Infof("Removed cached metadata of %s (%s)", orDash(c.Name), c.Key)
+			}
+			for _, arg := range args {
+				if !removed[arg] {
+					return fmt.Errorf("no cached metadata found for %s", arg)
+				}
+			}
+			return nil
+		},
+	}
+	cleanCmd.Flags().BoolVarP(&cacheopts.all, "all", "a", false, "Remove the cached metadata of all repositories")
+
+	for _, c := range []*cobra.Command{listCmd, gcCmd, cleanCmd} {
+		repo.AddCacheHelperFlags(c)
+		cacheCmd.AddCommand(c)
+	}
+	return cacheCmd
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func toReadableSize(bytes int64) string {
+	if bytes > 1000*1000*1000 {
+		return fmt.Sprintf("%.2f G", float64(bytes)/1000/1000/1000)
+	} else if bytes > 1000*1000 {
+		return fmt.Sprintf("%.2f M", float64(bytes)/1000/1000)
+	} else if bytes > 1000 {
+		return fmt.Sprintf("%.2f K", float64(bytes)/1000)
+	}
+	return fmt.Sprintf("%d", bytes)
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/rmohr/bazeldnf/pkg/api"
 	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+	"github.com/rmohr/bazeldnf/pkg/rpm"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/exp/maps"
 )
@@ -30,25 +31,41 @@ func sortedPackages(pkgs []*api.Package) []*api.Package {
 	})
 }
 
-func toConfig(install, forceIgnored []*api.Package, targets []string, cmdline []string) (*bazeldnf.Config, error) {
+// toConfig creates the lockfile for the installed packages. With weakDeps the
+// weak dependencies between the installed packages become dependencies as
+// well, and the packages in weakOnly are marked as only being pulled in by
+// them.
+func toConfig(install, forceIgnored, weakOnly []*api.Package, weakDeps bool, targets []string, cmdline []string) (*bazeldnf.Config, error) {
 	ignored := make(map[*api.Package]bool)
 	ignoredNames := make(map[string]bool)
 	for _, forceIgnoredPackage := range forceIgnored {
 		ignored[forceIgnoredPackage] = true
 		ignoredNames[forceIgnoredPackage.Name] = true
 	}
+	weak := make(map[*api.Package]bool)
+	for _, weakOnlyPackage := range weakOnly {
+		weak[weakOnlyPackage] = true
+	}
 
+	providers := collectProviders(forceIgnored, install)
+	var supplementedBy map[*api.Package][]string
+	if weakDeps {
+		supplementedBy = collectSupplements(install, providers)
+	}
 	allPackages := make(map[*api.Package]*bazeldnf.RPM)
 	repositories := make(map[string][]string)
 	for _, installPackage := range install {
 		repositories[installPackage.Repository.Name] = installPackage.Repository.Mirrors
 
-		deps := make([]string, 0, len(installPackage.Format.Requires.Entries))
-		for _, entry := range installPackage.Format.Requires.Entries {
-			deps = append(deps, entry.Name)
+		deps := requiredCapabilities(installPackage, providers)
+		if weakDeps {
+			for _, entry := range installPackage.Format.Recommends.Entries {
+				deps = append(deps, installedCapabilities(entry, providers)...)
+			}
+			deps = append(deps, supplementedBy[installPackage]...)
 		}
-
 		slices.Sort(deps)
+		deps = slices.Compact(deps)
 
 		integrity, err := installPackage.Checksum.Integrity()
 		if err != nil {
@@ -62,10 +79,10 @@ func toConfig(install, forceIgnored []*api.Package, targets []string, cmdline []
 			URLs:         []string{installPackage.Location.Href},
 			Repository:   installPackage.Repository.Name,
 			Dependencies: deps,
+			WeakOnly:     weak[installPackage],
 		}
 	}
 
-	providers := collectProviders(forceIgnored, install)
 	packageNames := sortedPackages(maps.Keys(allPackages))
 	sortedPackages := make([]*bazeldnf.RPM, 0, len(packageNames))
 	for _, name := range packageNames {
@@ -94,6 +111,93 @@ func toConfig(install, forceIgnored []*api.Package, targets []string, cmdline []
 	return &lockFile, nil
 }
 
+// requiredCapabilities returns the names of the requirements of a package. Of
+// rich dependencies only the capabilities which are provided by the selected
+// packages are returned, like the alternative which the solver picked.
+func requiredCapabilities(pkg *api.Package, providers map[string][]*api.Package) []string {
+	deps := make([]string, 0, len(pkg.Format.Requires.Entries))
+	for _, entry := range pkg.Format.Requires.Entries {
+		if rpm.IsRichDependency(entry.Name) {
+			deps = append(deps, installedCapabilities(entry, providers)...)
+		} else {
+			deps = append(deps, entry.Name)
+		}
+	}
+	return deps
+}
+
+// installedCapabilities returns the capabilities which may satisfy a
+// dependency and which are provided by the selected packages
+func installedCapabilities(entry api.Entry, providers map[string][]*api.Package) []string {
+	dep, err := rpm.ParseDependency(entry)
+	if err != nil {
+		// the solver ignores dependencies which can't be parsed too
+		return nil
+	}
+	capabilities := []string{}
+	for _, installable := range dep.Installable() {
+		if _, exists := providers[installable.Name]; exists {
+			capabilities = append(capabilities, installable.Name)
+		}
+	}
+	return capabilities
+}
+
+// collectSupplements maps the installed packages to the names of the
+// installed packages which supplement them
+func collectSupplements(install []*api.Package, providers map[string][]*api.Package) map[*api.Package][]string {
+	supplementedBy := map[*api.Package][]string{}
+	for _, pkg := range install {
+		for _, entry := range pkg.Format.Supplements.Entries {
+			dep, err := rpm.ParseDependency(entry)
+			if err != nil {
+				continue
+			}
+			for _, supplemented := range dep.Entries() {
+				for _, provider := range providers[supplemented.Name] {
+					supplementedBy[provider] = append(supplementedBy[provider], pkg.Name)
+				}
+			}
+		}
+	}
+	return supplementedBy
+}
+
+// weakOnlyPackages returns the installed packages which the requested
+// packages don't require, directly or transitively, so that they are only
+// installed because of weak dependencies
+func weakOnlyPackages(requested, install []*api.Package) []*api.Package {
+	providers := collectProviders(install)
+	required := map[*api.Package]bool{}
+	queue := []*api.Package{}
+	for _, pkg := range requested {
+		if !required[pkg] {
+			required[pkg] = true
+			queue = append(queue, pkg)
+		}
+	}
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		for _, capability := range requiredCapabilities(pkg, providers) {
+			for _, provider := range providers[capability] {
+				if !required[provider] {
+					required[provider] = true
+					queue = append(queue, provider)
+				}
+			}
+		}
+	}
+
+	weakOnly := []*api.Package{}
+	for _, pkg := range install {
+		if !required[pkg] {
+			weakOnly = append(weakOnly, pkg)
+		}
+	}
+	return weakOnly
+}
+
 func collectProviders(pkgSets ...[]*api.Package) map[string][]*api.Package {
 	providers := map[string][]*api.Package{}
 	for _, pkgSet := range pkgSets {
@@ -21,7 +21,7 @@ func TestBaseCase(t *testing.T) {
 		Targets:              []string{},
 		ForceIgnored:         []string{},
 	}
-	cfg, err := toConfig([]*api.Package{}, []*api.Package{}, []string{}, []string{})
+	cfg, err := toConfig([]*api.Package{}, []*api.Package{}, nil, false, []string{}, []string{})
 
 	g.Expect(err).Should(BeNil())
 	g.Expect(cfg).Should(Equal(expected))
@@ -45,7 +45,7 @@ func TestSimpleInputs(t *testing.T) {
 		Targets:              targets,
 		ForceIgnored:         []string{"package0", "package1"},
 	}
-	cfg, err := toConfig([]*api.Package{}, ignored, targets, commandline)
+	cfg, err := toConfig([]*api.Package{}, ignored, nil, false, targets, commandline)
 
 	g.Expect(err).Should(BeNil())
 	g.Expect(cfg).Should(Equal(expected))
@@ -59,6 +59,8 @@ func TestMissingProvider(t *testing.T) {
 			newPackageWithDeps("parent", "somedep"),
 		},
 		[]*api.Package{},
+		nil,
+		false,
 		[]string{},
 		[]string{},
 	)
@@ -115,6 +117,16 @@ func newPackageWithProvides(name string, provides ...string) *api.Package {
 	return p
 }
 
+func withWeakDeps(p *api.Package, recommends []string, supplements []string) *api.Package {
+	for _, rec := range recommends {
+		p.Format.Recommends.Entries = append(p.Format.Recommends.Entries, api.Entry{Name: rec})
+	}
+	for _, sup := range supplements {
+		p.Format.Supplements.Entries = append(p.Format.Supplements.Entries, api.Entry{Name: sup})
+	}
+	return p
+}
+
 func newSimpleRPM(name string, deps ...string) *bazeldnf.RPM {
 	d := []string{}
 	if len(deps) > 0 {
@@ -131,10 +143,34 @@ func newSimpleRPM(name string, deps ...string) *bazeldnf.RPM {
 	}
 }
 
+func TestWeakOnlyPackages(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	parent := withWeakDeps(newPackageWithDeps("parent", "lib"), []string{"docs"}, nil)
+	lib := newPackageWithProvides("lib")
+	docs := newPackageWithDeps("docs", "docs-common")
+	docsCommon := newPackageWithProvides("docs-common")
+
+	install := []*api.Package{parent, lib, docs, docsCommon}
+	g.Expect(weakOnlyPackages([]*api.Package{parent}, install)).To(ConsistOf(docs, docsCommon))
+
+	cfg, err := toConfig(install, []*api.Package{}, []*api.Package{docs, docsCommon}, true, []string{}, []string{})
+	g.Expect(err).Should(BeNil())
+	weak := []string{}
+	for _, rpm := range cfg.RPMs {
+		if rpm.WeakOnly {
+			weak = append(weak, rpm.Name)
+		}
+	}
+	g.Expect(weak).To(ConsistOf("docs", "docs-common"))
+}
+
 func TestConfigTransform(t *testing.T) {
 	tests := []struct {
 		name               string
 		installed, ignored []*api.Package
+		weakOnly           []*api.Package
+		weakDeps           bool
 
 		expectedRepositories map[string][]string
 		expectedRPMs         []*bazeldnf.RPM
@@ -430,6 +466,38 @@ func TestConfigTransform(t *testing.T) {
 				newSimpleRPM("package1", "apache", "nginx"),
 			},
 		},
+		{
+			name: "rich dependencies",
+			installed: []*api.Package{
+				newPackageWithDeps("package1", "(webserver or proxy)", "(apache if selinux)"),
+				newPackageWithProvides("nginx", "webserver"),
+			},
+			expectedRepositories: map[string][]string{
+				"repository": []string{},
+			},
+			expectedRPMs: []*bazeldnf.RPM{
+				newSimpleRPM("nginx"),
+				newSimpleRPM("package1", "nginx"),
+			},
+		},
+		{
+			name: "weak dependencies",
+			installed: []*api.Package{
+				withWeakDeps(newPackageWithDeps("package1"), []string{"docs", "missing"}, nil),
+				newPackageWithProvides("package1-docs", "docs"),
+				withWeakDeps(newPackageWithProvides("langpack"), nil, []string{"(package1 and lang)"}),
+			},
+			weakOnly: []*api.Package{},
+			weakDeps: true,
+			expectedRepositories: map[string][]string{
+				"repository": []string{},
+			},
+			expectedRPMs: []*bazeldnf.RPM{
+				newSimpleRPM("langpack"),
+				newSimpleRPM("package1", "langpack", "package1-docs"),
+				newSimpleRPM("package1-docs"),
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -454,6 +522,8 @@ func TestConfigTransform(t *testing.T) {
 			cfg, err := toConfig(
 				tt.installed,
 				tt.ignored,
+				tt.weakOnly,
+				tt.weakDeps,
 				[]string{},
 				[]string{},
 			)
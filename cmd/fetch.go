@@ -6,7 +6,9 @@ import (
 )
 
 type FetchOpts struct {
-	repofiles []string
+	repofiles   []string
+	concurrency int
+	filelists   bool
 }
 
 var fetchopts = &FetchOpts{}
@@ -22,11 +24,16 @@ func NewFetchCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			return repo.NewRemoteRepoFetcher(repos.Repositories).Fetch()
+			err = repo.NewRemoteRepoFetcher(repos.Repositories, fetchopts.concurrency, fetchopts.filelists).Fetch()
+			return withFetchHint(err, fetchopts.repofiles, fetchopts.filelists)
 		},
 	}
 
-	fetchCmd.Flags().StringArrayVarP(&fetchopts.repofiles, "repofile", "r", []string{"repo.yaml"}, "repository information file. Can be specified multiple times")
+	fetchCmd.Flags().StringArrayVarP(&fetchopts.repofiles, "repofile", "r", []string{"repo.yaml"}, "repository information file, either repo.yaml or a yum/dnf .repo file. Can be specified multiple times")
+	repo.AddRepoVarsFlags(fetchCmd)
+	fetchCmd.Flags().IntVarP(&fetchopts.concurrency, "concurrency", "j", 4, "maximum number of repositories to fetch in parallel")
+	fetchCmd.Flags().BoolVar(&fetchopts.filelists, "filelists", false, "also fetch filelists.xml, which allows resolving requirements on files not listed in primary.xml")
 	repo.AddCacheHelperFlags(fetchCmd)
+	repo.AddCredentialFlags(fetchCmd)
 	return fetchCmd
 }
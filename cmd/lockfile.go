@@ -30,15 +30,16 @@ func NewLockFileCmd() *cobra.Command {
 				return err
 			}
 
-			install, forceIgnored, err := resolve(repos, required)
+			result, err := resolve(repos, required)
 			if err != nil {
-				return err
+				return withFetchHint(err, lockfileopts.repofiles, false)
 			}
 
-			logrus.Debugf("install: %v", install)
-			logrus.Debugf("forceIgnored: %v", forceIgnored)
+			logrus.Debugf("install: %v", result.install)
+			logrus.Debugf("forceIgnored: %v", result.forceIgnored)
+			logrus.Debugf("weakOnly: %v", result.weakOnly)
 
-			config, err := toConfig(install, forceIgnored, required, os.Args[2:])
+			config, err := toConfig(result.install, result.forceIgnored, result.weakOnly, resolvehelperopts.weakDeps, required, os.Args[2:])
 
 			if err != nil {
 				return err
@@ -51,7 +52,8 @@ func NewLockFileCmd() *cobra.Command {
 
 	addResolveHelperFlags(lockfileCmd)
 	repo.AddCacheHelperFlags(lockfileCmd)
-	lockfileCmd.Flags().StringArrayVarP(&lockfileopts.repofiles, "repofile", "r", []string{"repo.yaml"}, "repository information file. Can be specified multiple times. Will be used by default if no explicit inputs are provided.")
+	lockfileCmd.Flags().StringArrayVarP(&lockfileopts.repofiles, "repofile", "r", []string{"repo.yaml"}, "repository information file, either repo.yaml or a yum/dnf .repo file. Can be specified multiple times. Will be used by default if no explicit inputs are provided.")
+	repo.AddRepoVarsFlags(lockfileCmd)
 	lockfileCmd.Flags().StringVar(&lockfileopts.configname, "configname", "rpms", "config name to use in lockfile")
 	lockfileCmd.Flags().StringVar(&lockfileopts.lockfile, "lockfile", "bazeldnf-lock.json", "lockfile to write to")
 	return lockfileCmd
@@ -21,6 +21,7 @@ type reduceOpts struct {
 	ignoreMissing bool
 	architectures []string
 	baseSystem    string
+	weakDeps      bool
 }
 
 var reduceopts = reduceOpts{}
@@ -43,9 +44,9 @@ which allow reducing huge rpm repos to a smaller problem set for debugging, remo
 					return err
 				}
 			}
-			_, involved, err := reducer.Resolve(repos, reduceopts.in, reduceopts.baseSystem, EffectiveArchitectures(reduceopts.architectures), required, reduceopts.ignoreMissing)
+			_, involved, err := reducer.Resolve(repos, reduceopts.in, reduceopts.baseSystem, EffectiveArchitectures(reduceopts.architectures), required, reduceopts.ignoreMissing, reduceopts.weakDeps)
 			if err != nil {
-				return err
+				return withFetchHint(err, reduceopts.repofiles, false)
 			}
 			logrus.Info("Writing involved packages as a repo.")
 			testrepo := &api.Repository{}
@@ -70,7 +71,9 @@ which allow reducing huge rpm repos to a smaller problem set for debugging, remo
 	reduceCmd.Flags().StringSliceVarP(&reduceopts.architectures, "arch", "a", []string{"x86_64"}, "target architectures; `noarch` will be automatically added")
 	reduceCmd.Flags().BoolVarP(&reduceopts.nobest, "nobest", "n", false, "allow picking versions which are not the newest")
 	reduceCmd.Flags().BoolVar(&reduceopts.ignoreMissing, "ignore-missing", false, "ignore missing packages")
-	reduceCmd.Flags().StringArrayVarP(&reduceopts.repofiles, "repofile", "r", []string{"repo.yaml"}, "repository information file. Can be specified multiple times. Will be used by default if no explicit inputs are provided.")
+	reduceCmd.Flags().BoolVar(&reduceopts.weakDeps, "with-weak-deps", false, "keep the candidates for weak dependencies (Recommends and Supplements)")
+	reduceCmd.Flags().StringArrayVarP(&reduceopts.repofiles, "repofile", "r", []string{"repo.yaml"}, "repository information file, either repo.yaml or a yum/dnf .repo file. Can be specified multiple times. Will be used by default if no explicit inputs are provided.")
+	repo.AddRepoVarsFlags(reduceCmd)
 	// deprecated options
 	reduceCmd.Flags().StringVarP(&reduceopts.baseSystem, "fedora-base-system", "f", "fedora-release-container", "base system to use (e.g. fedora-release-server, centos-stream-release, ...)")
 	reduceCmd.Flags().MarkDeprecated("fedora-base-system", "use --basesystem instead")
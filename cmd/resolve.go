@@ -36,19 +36,20 @@ func NewResolveCmd() *cobra.Command {
 				resolvehelperopts.baseSystem = ""
 			}
 
-			install, forceIgnored, err := resolve(repos, required)
+			result, err := resolve(repos, required)
 			if err != nil {
-				return err
+				return withFetchHint(err, resolveopts.repofiles, false)
 			}
 
-			if err := template.Render(os.Stdout, install, forceIgnored); err != nil {
+			if err := template.Render(os.Stdout, result.install, result.forceIgnored); err != nil {
 				return err
 			}
 			return nil
 		},
 	}
 
-	resolveCmd.Flags().StringArrayVarP(&resolveopts.repofiles, "repofile", "r", []string{"repo.yaml"}, "repository information file. Can be specified multiple times. Will be used by default if no explicit inputs are provided.")
+	resolveCmd.Flags().StringArrayVarP(&resolveopts.repofiles, "repofile", "r", []string{"repo.yaml"}, "repository information file, either repo.yaml or a yum/dnf .repo file. Can be specified multiple times. Will be used by default if no explicit inputs are provided.")
+	repo.AddRepoVarsFlags(resolveCmd)
 
 	repo.AddCacheHelperFlags(resolveCmd)
 	addResolveHelperFlags(resolveCmd)
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"errors"
 	"slices"
 
 	"github.com/rmohr/bazeldnf/pkg/api"
 	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
 	"github.com/rmohr/bazeldnf/pkg/reducer"
+	"github.com/rmohr/bazeldnf/pkg/repo"
 	"github.com/rmohr/bazeldnf/pkg/sat"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -19,6 +21,7 @@ type resolveHelperOpts struct {
 	ignoreMissing    bool
 	forceIgnoreRegex []string
 	onlyAllowRegex   []string
+	weakDeps         bool
 }
 
 var resolvehelperopts = resolveHelperOpts{}
@@ -30,27 +33,64 @@ func EffectiveArchitectures(architectures []string) []string {
 	return architectures
 }
 
-func resolve(repos *bazeldnf.Repositories, required []string) ([]*api.Package, []*api.Package, error) {
-	matched, involved, err := reducer.Resolve(repos, resolvehelperopts.in, resolvehelperopts.baseSystem, EffectiveArchitectures(resolvehelperopts.arch), required, resolvehelperopts.ignoreMissing)
+// resolution is the result of resolving the required packages
+type resolution struct {
+	install      []*api.Package
+	forceIgnored []*api.Package
+	// requested contains the packages selected for the required packages
+	// and the base system
+	requested []*api.Package
+	// weakOnly contains the installed packages which are only pulled in by
+	// weak dependencies, if they are enabled
+	weakOnly []*api.Package
+}
+
+// resolve returns the packages to install and the force ignored packages,
+// together with the packages selected for the required packages. With weak
+// dependencies enabled it also returns the installed packages which are only
+// pulled in by weak dependencies.
+func resolve(repos *bazeldnf.Repositories, required []string) (*resolution, error) {
+	matched, involved, err := reducer.Resolve(repos, resolvehelperopts.in, resolvehelperopts.baseSystem, EffectiveArchitectures(resolvehelperopts.arch), required, resolvehelperopts.ignoreMissing, resolvehelperopts.weakDeps)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	if len(matched) == 0 {
-		return nil, nil, nil
+		return &resolution{}, nil
 	}
 
 	loader := sat.NewLoader()
+	if resolvehelperopts.weakDeps {
+		loader.EnableWeakDeps()
+	}
 
 	logrus.Info("Loading involved packages into the resolver.")
 	model, err := loader.Load(involved, matched, resolvehelperopts.forceIgnoreRegex, resolvehelperopts.onlyAllowRegex, resolvehelperopts.nobest, EffectiveArchitectures(resolvehelperopts.arch))
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	logrus.Info("Solving.")
 	install, _, forceIgnored, err := sat.Resolve(model)
-	return install, forceIgnored, err
+	if err != nil {
+		return nil, err
+	}
+	result := &resolution{install: install, forceIgnored: forceIgnored, requested: model.Requested()}
+	if resolvehelperopts.weakDeps {
+		result.weakOnly = weakOnlyPackages(result.requested, install)
+	}
+	return result, nil
+}
+
+// withFetchHint completes reports about missing cached metadata with the
+// fetch invocation which adds it to the cache, using the same $basearch as
+// the failed command
+func withFetchHint(err error, repofiles []string, filelists bool) error {
+	var missing *repo.MissingMetadataError
+	if errors.As(err, &missing) && missing.FetchCommand == "" {
+		missing.FetchCommand = repo.FetchCommand(repofiles, filelists, repo.ConfiguredBasearch())
+	}
+	return err
 }
 
 func addResolveHelperFlags(cmd *cobra.Command) {
@@ -60,6 +100,7 @@ func addResolveHelperFlags(cmd *cobra.Command) {
 	cmd.Flags().BoolVarP(&resolvehelperopts.nobest, "nobest", "n", false, "allow picking versions which are not the newest")
 	cmd.Flags().BoolVar(&resolvehelperopts.ignoreMissing, "ignore-missing", false, "ignore missing packages")
 	cmd.Flags().StringArrayVar(&resolvehelperopts.forceIgnoreRegex, "force-ignore-with-dependencies", []string{}, "Packages matching these regex patterns will not be installed. Allows force-removing unwanted dependencies. Be careful, this can lead to hidden missing dependencies.")
+	cmd.Flags().BoolVar(&resolvehelperopts.weakDeps, "with-weak-deps", false, "install weak dependencies (Recommends and Supplements) where possible, like dnf with install_weak_deps=True")
 	cmd.Flags().StringArrayVar(&resolvehelperopts.onlyAllowRegex, "only-allow", []string{}, "Packages matching these regex patterns may be installed. Allows scoping dependencies. Be careful, this can lead to hidden missing dependencies.")
 	// deprecated options
 	cmd.Flags().StringVarP(&resolvehelperopts.baseSystem, "fedora-base-system", "f", "fedora-release-container", "base system to use (e.g. fedora-release-server, centos-stream-release, ...)")
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/rmohr/bazeldnf/pkg/repo"
+)
+
+const testPrimary = `<?xml version="1.0" encoding="UTF-8"?>
+<metadata xmlns="http://linux.duke.edu/metadata/common" xmlns:rpm="http://linux.duke.edu/metadata/rpm" packages="1">
+<package type="rpm">
+  <name>foo</name>
+  <arch>x86_64</arch>
+  <version epoch="0" ver="1.0" rel="1"/>
+  <checksum type="sha256" pkgid="YES">abcd</checksum>
+  <location href="Packages/foo-1.0-1.x86_64.rpm"/>
+  <format>
+    <rpm:provides>
+      <rpm:entry name="foo"/>
+    </rpm:provides>
+  </format>
+</package>
+</metadata>
+`
+
+// writeTestRepository writes the metadata of a repository with the package
+// foo to dir/x86_64
+func writeTestRepository(t *testing.T, dir string) {
+	t.Helper()
+	repodata := filepath.Join(dir, "x86_64", "repodata")
+	if err := os.MkdirAll(repodata, 0755); err != nil {
+		t.Fatalf("creating repodata: %v", err)
+	}
+	primary := &bytes.Buffer{}
+	w := gzip.NewWriter(primary)
+	w.Write([]byte(testPrimary))
+	w.Close()
+	sum := sha256.Sum256(primary.Bytes())
+	repomd := fmt.Sprintf(`<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <revision>1</revision>
+  <data type="primary">
+    <checksum type="sha256">%s</checksum>
+    <location href="repodata/primary.xml.gz"/>
+  </data>
+</repomd>`, hex.EncodeToString(sum[:]))
+	for name, content := range map[string][]byte{"primary.xml.gz": primary.Bytes(), "repomd.xml": []byte(repomd)} {
+		if err := os.WriteFile(filepath.Join(repodata, name), content, 0644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+}
+
+func TestFetchHintWithBasearch(t *testing.T) {
+	g := NewGomegaWithT(t)
+	dir := t.TempDir()
+	writeTestRepository(t, dir)
+	repofile := filepath.Join(dir, "local.repo")
+	g.Expect(os.WriteFile(repofile, []byte("[local]\nname=local\nbaseurl=file://"+dir+"/$basearch/\ngpgcheck=0\n"), 0644)).To(Succeed())
+	cacheDir := filepath.Join(dir, "cache")
+
+	resolveArgs := []string{"--repofile", repofile, "--basearch", "x86_64", "--cache-dir", cacheDir, "--basesystem", "scratch", "foo"}
+	resolveCmd := NewResolveCmd()
+	resolveCmd.SetArgs(resolveArgs)
+	resolveCmd.SilenceUsage = true
+	err := resolveCmd.Execute()
+	var missing *repo.MissingMetadataError
+	g.Expect(errors.As(err, &missing)).To(BeTrue(), "expected missing metadata, got %v", err)
+	g.Expect(missing.FetchCommand).To(ContainSubstring("--basearch x86_64"))
+
+	// run the suggested command, which fills the cache directory which the
+	// next resolution reads
+	fetchArgs := strings.Fields(missing.FetchCommand)
+	g.Expect(fetchArgs[:2]).To(Equal([]string{"bazeldnf", "fetch"}))
+	fetchCmd := NewFetchCmd()
+	fetchCmd.SetArgs(fetchArgs[2:])
+	fetchCmd.SilenceUsage = true
+	g.Expect(fetchCmd.Execute()).To(Succeed())
+
+	resolveCmd = NewResolveCmd()
+	resolveCmd.SetArgs(resolveArgs)
+	resolveCmd.SilenceUsage = true
+	g.Expect(resolveCmd.Execute()).To(Succeed())
+}
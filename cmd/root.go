@@ -6,6 +6,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/rmohr/bazeldnf/pkg/repo"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -69,6 +70,7 @@ func Execute() {
 	args := expandParamFileArgs(os.Args[1:])
 	rootCmd.SetArgs(args)
 	rootCmd.PersistentFlags().StringVarP(&rootopts.logLevel, "log-level", "l", "", "log level")
+	repo.AddOfflineFlags(rootCmd)
 
 	cobra.OnInitialize(initRootCmd)
 
@@ -79,12 +81,14 @@ func Execute() {
 	rootCmd.AddCommand(NewLockFileCmd())
 	rootCmd.AddCommand(NewRpmTreeCmd())
 	rootCmd.AddCommand(NewResolveCmd())
+	rootCmd.AddCommand(NewWhyCmd())
 	rootCmd.AddCommand(NewReduceCmd())
 	rootCmd.AddCommand(NewRpm2TarCmd())
 	rootCmd.AddCommand(NewPruneCmd())
 	rootCmd.AddCommand(NewTar2FilesCmd())
 	rootCmd.AddCommand(NewLddCmd())
 	rootCmd.AddCommand(NewVerifyCmd())
+	rootCmd.AddCommand(NewCacheCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -148,9 +148,9 @@ func NewRpmTreeCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			install, forceIgnored, err := resolve(repos, required)
+			result, err := resolve(repos, required)
 			if err != nil {
-				return err
+				return withFetchHint(err, rpmtreeopts.repofiles, false)
 			}
 
 			handler, configname, err := newHandler()
@@ -162,9 +162,9 @@ func NewRpmTreeCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			bazel.AddTree(rpmtreeopts.name, configname, build, install, rpmtreeopts.public)
+			bazel.AddTree(rpmtreeopts.name, configname, build, result.install, rpmtreeopts.public)
 
-			if err := handler.Process(install, build); err != nil {
+			if err := handler.Process(result.install, build); err != nil {
 				return err
 			}
 
@@ -178,7 +178,7 @@ func NewRpmTreeCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			if err := template.Render(os.Stdout, install, forceIgnored); err != nil {
+			if err := template.Render(os.Stdout, result.install, result.forceIgnored); err != nil {
 				return err
 			}
 
@@ -187,7 +187,8 @@ func NewRpmTreeCmd() *cobra.Command {
 	}
 
 	rpmtreeCmd.Flags().BoolVarP(&rpmtreeopts.public, "public", "p", true, "if the rpmtree rule should be public")
-	rpmtreeCmd.Flags().StringArrayVarP(&rpmtreeopts.repofiles, "repofile", "r", []string{"repo.yaml"}, "repository information file. Can be specified multiple times. Will be used by default if no explicit inputs are provided.")
+	rpmtreeCmd.Flags().StringArrayVarP(&rpmtreeopts.repofiles, "repofile", "r", []string{"repo.yaml"}, "repository information file, either repo.yaml or a yum/dnf .repo file. Can be specified multiple times. Will be used by default if no explicit inputs are provided.")
+	repo.AddRepoVarsFlags(rpmtreeCmd)
 	rpmtreeCmd.Flags().StringVarP(&rpmtreeopts.workspace, "workspace", "w", "WORKSPACE", "Bazel workspace file")
 	rpmtreeCmd.Flags().StringVarP(&rpmtreeopts.toMacro, "to-macro", "", "", "Tells bazeldnf to write the RPMs to a macro in the given bzl file instead of the WORKSPACE file. The expected format is: macroFile%defName")
 	rpmtreeCmd.Flags().StringVarP(&rpmtreeopts.buildfile, "buildfile", "b", "rpm/BUILD.bazel", "Build file for RPMs")
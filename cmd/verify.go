@@ -3,11 +3,13 @@ package main
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
-	"net/http"
+	"strings"
 
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
 	"github.com/rmohr/bazeldnf/pkg/bazel"
 	"github.com/rmohr/bazeldnf/pkg/repo"
 	"github.com/sassoftware/go-rpmutils"
@@ -35,17 +37,33 @@ func NewVerifyCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
+			getters := &repositoryGetters{fallback: repo.NewGetter()}
+			cacheHelper := repo.NewCacheHelper()
 			keyring := openpgp.EntityList{}
-			for _, repo := range repos.Repositories {
-				if !repo.Disabled && repo.GPGKey != "" {
-					resp, err := http.Get(repo.GPGKey)
+			for i := range repos.Repositories {
+				rpmrepo := &repos.Repositories[i]
+				if rpmrepo.Disabled {
+					continue
+				}
+				getter, err := repo.NewRepositoryGetter(rpmrepo)
+				if err != nil {
+					return err
+				}
+				if err := getters.add(rpmrepo, getter, cacheHelper); err != nil {
+					return err
+				}
+				if rpmrepo.GPGKey != "" {
+					resp, err := getter.Get(rpmrepo.GPGKey)
 					if err != nil {
-						return fmt.Errorf("could not fetch gpgkey %s: %w", repo.GPGKey, err)
+						return fmt.Errorf("could not fetch gpgkey %s: %w", rpmrepo.GPGKey, err)
 					}
 					defer resp.Body.Close()
+					if resp.StatusCode < 200 || resp.StatusCode > 299 {
+						return fmt.Errorf("could not fetch gpgkey %s: status : %v", rpmrepo.GPGKey, resp.StatusCode)
+					}
 					keys, err := openpgp.ReadArmoredKeyRing(resp.Body)
 					if err != nil {
-						return fmt.Errorf("could not load gpgkey %s: %w", repo.GPGKey, err)
+						return fmt.Errorf("could not load gpgkey %s: %w", rpmrepo.GPGKey, err)
 					}
 					for _, k := range keys {
 						keyring = append(keyring, k)
@@ -59,7 +77,7 @@ func NewVerifyCmd() *cobra.Command {
 					return fmt.Errorf("failed to open workspace %s: %w", verifyopts.workspace, err)
 				}
 				for _, rpm := range bazel.GetWorkspaceRPMs(workspace) {
-					err := verify(rpm, keyring)
+					err := verify(getters, rpm, keyring)
 					if err != nil {
 						return fmt.Errorf("Could not verify %s: %w", rpm.Name(), err)
 					}
@@ -74,7 +92,7 @@ func NewVerifyCmd() *cobra.Command {
 					return err
 				}
 				for _, rpm := range bazel.GetBzlfileRPMs(bzlfile, defname) {
-					err := verify(rpm, keyring)
+					err := verify(getters, rpm, keyring)
 					if err != nil {
 						return fmt.Errorf("Could not verify %s: %w", rpm.Name(), err)
 					}
@@ -84,23 +102,69 @@ func NewVerifyCmd() *cobra.Command {
 		},
 	}
 
-	verifyCmd.Flags().StringArrayVarP(&verifyopts.repofiles, "repofile", "r", []string{"repo.yaml"}, "repository information file (can be specified multiple times)")
+	verifyCmd.Flags().StringArrayVarP(&verifyopts.repofiles, "repofile", "r", []string{"repo.yaml"}, "repository information file, either repo.yaml or a yum/dnf .repo file (can be specified multiple times)")
+	repo.AddRepoVarsFlags(verifyCmd)
+	repo.AddCredentialFlags(verifyCmd)
+	repo.AddCacheHelperFlags(verifyCmd)
 	verifyCmd.Flags().StringVarP(&verifyopts.workspace, "workspace", "w", "WORKSPACE", "Bazel workspace file")
 	verifyCmd.Flags().StringVarP(&verifyopts.fromMacro, "from-macro", "", "", "Tells bazeldnf to read the RPMs from a macro in the given bzl file instead of the WORKSPACE file. The expected format is: macroFile%defName")
 	return verifyCmd
 }
 
-func verify(rpm *bazel.RPMRule, keyring openpgp.EntityList) (err error) {
+// repositoryGetters picks the getter with the tls and proxy settings of the
+// repository which an URL belongs to, based on the baseurl and the mirrors of
+// the repositories. The mirrors of metalink and mirrorlist repositories are
+// taken from the cache.
+type repositoryGetters struct {
+	prefixes []string
+	getters  []repo.Getter
+	fallback repo.Getter
+}
+
+func (r *repositoryGetters) add(rpmrepo *bazeldnf.Repository, getter repo.Getter, cacheHelper *repo.CacheHelper) error {
+	mirrors, err := cacheHelper.MirrorURLs(rpmrepo)
+	if err != nil {
+		return fmt.Errorf("failed to load the mirrors of %s: %w", rpmrepo.Name, err)
+	}
+	if len(mirrors) == 0 && (rpmrepo.Metalink != "" || rpmrepo.Mirrorlist != "") {
+		log.Warnf("The mirrors of %s are not cached, its tls and proxy settings only apply to its RPMs after running fetch", rpmrepo.Name)
+	}
+	for _, prefix := range mirrors {
+		if prefix != "" {
+			r.prefixes = append(r.prefixes, strings.TrimSuffix(prefix, "/")+"/")
+			r.getters = append(r.getters, getter)
+		}
+	}
+	return nil
+}
+
+// forURL returns the getter of the repository with the longest matching
+// prefix, or the fallback if the URL doesn't belong to any repository
+func (r *repositoryGetters) forURL(url string) repo.Getter {
+	getter, longest := r.fallback, 0
+	for i, prefix := range r.prefixes {
+		if strings.HasPrefix(url, prefix) && len(prefix) > longest {
+			getter, longest = r.getters[i], len(prefix)
+		}
+	}
+	return getter
+}
+
+func verify(getters *repositoryGetters, rpm *bazel.RPMRule, keyring openpgp.EntityList) (err error) {
 	// Force a test. If `nil` the verification library just does no GPG check
 	if keyring == nil {
 		keyring = openpgp.EntityList{}
 	}
 
 	log.Infof("Verifying %s", rpm.Name())
+	offline := false
 	for _, url := range rpm.URLs() {
 		sha := sha256.New()
-		resp, err := http.Get(url)
-		if err != nil {
+		resp, err := getters.forURL(url).Get(url)
+		if errors.Is(err, repo.ErrOffline) {
+			offline = true
+			continue
+		} else if err != nil {
 			log.Warningf("Failed to download %s: %v", rpm.Name(), err)
 			continue
 		}
@@ -126,6 +190,9 @@ func verify(rpm *bazel.RPMRule, keyring openpgp.EntityList) (err error) {
 		}
 		return nil
 	}
+	if offline {
+		return fmt.Errorf("Could not verify %s: none of its URLs is local: %w", rpm.Name(), repo.ErrOffline)
+	}
 	return fmt.Errorf("Could not verify %s", rpm.Name())
 }
 
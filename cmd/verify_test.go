@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+	"github.com/rmohr/bazeldnf/pkg/repo"
+)
+
+const testMetalink = `<?xml version="1.0" encoding="utf-8"?>
+<metalink version="3.0" xmlns="http://www.metalinker.org/" type="dynamic">
+ <files>
+  <file name="repomd.xml">
+   <resources maxconnections="1">
+    <url protocol="https" type="https" location="US" preference="100">https://mirror.example.com/fedora/41/x86_64/os/repodata/repomd.xml</url>
+    <url protocol="http" type="http" location="DE" preference="99">http://mirror.example.de/fedora/41/x86_64/os/repodata/repomd.xml</url>
+   </resources>
+  </file>
+ </files>
+</metalink>
+`
+
+func TestRepositoryGettersWithMetalink(t *testing.T) {
+	g := NewGomegaWithT(t)
+	cacheHelper := repo.NewCacheHelper(t.TempDir())
+	metalinkRepo := &bazeldnf.Repository{Name: "fedora", Metalink: "https://mirrors.example.com/metalink?repo=fedora-41&arch=x86_64", Arch: "x86_64"}
+	g.Expect(cacheHelper.WriteToRepoDir(metalinkRepo, strings.NewReader(testMetalink), "metalink")).To(Succeed())
+	uncachedRepo := &bazeldnf.Repository{Name: "updates", Metalink: "https://mirrors.example.com/metalink?repo=updates-41&arch=x86_64", Arch: "x86_64"}
+	baseurlRepo := &bazeldnf.Repository{Name: "internal", Baseurl: "https://mirror.example.com/fedora/41/x86_64/os/internal", Arch: "x86_64"}
+
+	fallback, metalinkGetter, uncachedGetter, baseurlGetter := repo.NewGetter(), repo.NewGetter(), repo.NewGetter(), repo.NewGetter()
+	getters := &repositoryGetters{fallback: fallback}
+	g.Expect(getters.add(metalinkRepo, metalinkGetter, cacheHelper)).To(Succeed())
+	g.Expect(getters.add(uncachedRepo, uncachedGetter, cacheHelper)).To(Succeed())
+	g.Expect(getters.add(baseurlRepo, baseurlGetter, cacheHelper)).To(Succeed())
+
+	g.Expect(getters.forURL("https://mirror.example.com/fedora/41/x86_64/os/Packages/b/bash-5.2.rpm")).To(BeIdenticalTo(metalinkGetter))
+	g.Expect(getters.forURL("http://mirror.example.de/fedora/41/x86_64/os/Packages/b/bash-5.2.rpm")).To(BeIdenticalTo(metalinkGetter))
+	g.Expect(getters.forURL("https://mirror.example.com/fedora/41/x86_64/os/internal/Packages/foo.rpm")).To(BeIdenticalTo(baseurlGetter))
+	g.Expect(getters.forURL("https://other.example.com/Packages/b/bash-5.2.rpm")).To(BeIdenticalTo(fallback))
+}
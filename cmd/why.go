@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/repo"
+	"github.com/rmohr/bazeldnf/pkg/rpm"
+	"github.com/spf13/cobra"
+)
+
+type whyOpts struct {
+	repofiles []string
+	maxChains int
+}
+
+var whyopts = whyOpts{}
+
+func NewWhyCmd() *cobra.Command {
+
+	whyCmd := &cobra.Command{
+		Use:   "why <package> <target>...",
+		Short: "Show why a package is part of the dependencies of the given targets",
+		Long:  `Resolves the targets like rpmtree and lockfile and prints every shortest requirement chain from the targets and the base system to the package`,
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repos, err := repo.LoadRepoFiles(whyopts.repofiles)
+			if err != nil {
+				return err
+			}
+
+			result, err := resolve(repos, args[1:])
+			if err != nil {
+				return withFetchHint(err, whyopts.repofiles, false)
+			}
+
+			chains, total, err := dependencyChains(result.requested, result.install, args[0], resolvehelperopts.weakDeps, whyopts.maxChains)
+			if err != nil {
+				return err
+			}
+			for i, chain := range chains {
+				if i > 0 {
+					fmt.Fprintln(os.Stdout)
+				}
+				fmt.Fprint(os.Stdout, chain)
+			}
+			if total > len(chains) {
+				fmt.Fprintf(os.Stdout, "\n%d more chains omitted, raise --max-chains to show them\n", total-len(chains))
+			}
+			return nil
+		},
+	}
+
+	addResolveHelperFlags(whyCmd)
+	repo.AddCacheHelperFlags(whyCmd)
+	whyCmd.Flags().StringArrayVarP(&whyopts.repofiles, "repofile", "r", []string{"repo.yaml"}, "repository information file, either repo.yaml or a yum/dnf .repo file. Can be specified multiple times. Will be used by default if no explicit inputs are provided.")
+	whyCmd.Flags().IntVar(&whyopts.maxChains, "max-chains", 10, "maximum number of chains to print, 0 prints all of them")
+	repo.AddRepoVarsFlags(whyCmd)
+	return whyCmd
+}
+
+// dependencyEdge points from an installed package to an installed package
+// which it pulls in because of the listed capabilities
+type dependencyEdge struct {
+	from         *api.Package
+	to           *api.Package
+	kind         string
+	capabilities []string
+}
+
+func (e *dependencyEdge) String() string {
+	if e.kind == "supplements" {
+		return fmt.Sprintf("supplemented by %s, which supplements %s", e.to, strings.Join(e.capabilities, ", "))
+	}
+	return fmt.Sprintf("%s %s, provided by %s", e.kind, strings.Join(e.capabilities, ", "), e.to)
+}
+
+// dependencyChain is a chain of edges from a requested package to the
+// package in question. A requested package itself has an empty chain.
+type dependencyChain struct {
+	start *api.Package
+	edges []*dependencyEdge
+}
+
+func (c *dependencyChain) String() string {
+	if len(c.edges) == 0 {
+		return fmt.Sprintf("%s is requested\n", c.start)
+	}
+	s := fmt.Sprintf("%s\n", c.start)
+	for _, edge := range c.edges {
+		s += fmt.Sprintf("  %s\n", edge)
+	}
+	return s
+}
+
+// dependencyChains returns the shortest chains of dependencies from the
+// requested packages to the installed packages with the given name, together
+// with the total number of shortest chains. Since the number of chains grows
+// exponentially with every level of alternative providers, at most maxChains
+// chains are collected, unless maxChains is 0.
+func dependencyChains(requested, install []*api.Package, name string, weakDeps bool, maxChains int) ([]*dependencyChain, int, error) {
+	var targets []*api.Package
+	for _, pkg := range sortedPackages(install) {
+		if pkg.Name == name {
+			targets = append(targets, pkg)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, 0, fmt.Errorf("package %s is not part of the resolved packages", name)
+	}
+
+	// breadth-first search from all requested packages, which remembers all
+	// edges on shortest paths and counts the shortest paths to every package
+	graph := dependencyGraph(install, weakDeps)
+	distance := map[*api.Package]int{}
+	paths := map[*api.Package]int{}
+	predecessors := map[*api.Package][]*dependencyEdge{}
+	queue := []*api.Package{}
+	for _, pkg := range sortedPackages(requested) {
+		if _, exists := distance[pkg]; !exists {
+			distance[pkg] = 0
+			paths[pkg] = 1
+			queue = append(queue, pkg)
+		}
+	}
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		for _, edge := range graph[pkg] {
+			if d, exists := distance[edge.to]; !exists {
+				distance[edge.to] = distance[pkg] + 1
+				queue = append(queue, edge.to)
+			} else if d != distance[pkg]+1 {
+				continue
+			}
+			predecessors[edge.to] = append(predecessors[edge.to], edge)
+			paths[edge.to] = saturatingAdd(paths[edge.to], paths[pkg])
+		}
+	}
+
+	var chains []*dependencyChain
+	limitReached := func() bool {
+		return maxChains > 0 && len(chains) >= maxChains
+	}
+	var walk func(pkg *api.Package, edges []*dependencyEdge)
+	walk = func(pkg *api.Package, edges []*dependencyEdge) {
+		if distance[pkg] == 0 {
+			chains = append(chains, &dependencyChain{start: pkg, edges: edges})
+			return
+		}
+		for _, edge := range predecessors[pkg] {
+			if limitReached() {
+				return
+			}
+			walk(edge.from, append([]*dependencyEdge{edge}, edges...))
+		}
+	}
+	total := 0
+	for _, target := range targets {
+		if _, exists := distance[target]; !exists {
+			return nil, 0, fmt.Errorf("no chain of dependencies leads from the requested packages to %s", target)
+		}
+		total = saturatingAdd(total, paths[target])
+		if !limitReached() {
+			walk(target, nil)
+		}
+	}
+	return chains, total, nil
+}
+
+// saturatingAdd adds two path counts without overflowing
+func saturatingAdd(a, b int) int {
+	if a > math.MaxInt-b {
+		return math.MaxInt
+	}
+	return a + b
+}
+
+// dependencyGraph maps every installed package to the installed packages it
+// requires, based on the provider index of the installed packages. With weak
+// dependencies the recommended and supplementing packages are included too.
+func dependencyGraph(install []*api.Package, weakDeps bool) map[*api.Package][]*dependencyEdge {
+	providers := collectProviders(install)
+	graph := map[*api.Package][]*dependencyEdge{}
+	// a package which depends on several capabilities of the same package
+	// gets a single edge listing all of them, so that paths aren't counted twice
+	type edgeKey struct {
+		from, to *api.Package
+		kind     string
+	}
+	edges := map[edgeKey]*dependencyEdge{}
+	addEdge := func(from, to *api.Package, kind string, capability string) {
+		key := edgeKey{from: from, to: to, kind: kind}
+		edge, exists := edges[key]
+		if !exists {
+			edge = &dependencyEdge{from: from, to: to, kind: kind}
+			edges[key] = edge
+			graph[from] = append(graph[from], edge)
+		}
+		if !slices.Contains(edge.capabilities, capability) {
+			edge.capabilities = append(edge.capabilities, capability)
+		}
+	}
+	addEdges := func(pkg *api.Package, kind string, capabilities []string) {
+		var candidates []*api.Package
+		for _, capability := range capabilities {
+			for _, provider := range providers[capability] {
+				if provider != pkg && !slices.Contains(candidates, provider) {
+					candidates = append(candidates, provider)
+				}
+			}
+		}
+		for _, provider := range sortedPackages(candidates) {
+			for _, capability := range capabilities {
+				if slices.Contains(providers[capability], provider) {
+					addEdge(pkg, provider, kind, capability)
+				}
+			}
+		}
+	}
+
+	for _, pkg := range sortedPackages(install) {
+		capabilities := requiredCapabilities(pkg, providers)
+		slices.Sort(capabilities)
+		addEdges(pkg, "requires", capabilities)
+		if !weakDeps {
+			continue
+		}
+		var recommends []string
+		for _, entry := range pkg.Format.Recommends.Entries {
+			recommends = append(recommends, installedCapabilities(entry, providers)...)
+		}
+		slices.Sort(recommends)
+		addEdges(pkg, "recommends", recommends)
+	}
+
+	if weakDeps {
+		for _, pkg := range sortedPackages(install) {
+			for _, entry := range pkg.Format.Supplements.Entries {
+				dep, err := rpm.ParseDependency(entry)
+				if err != nil {
+					continue
+				}
+				for _, supplemented := range dep.Entries() {
+					for _, provider := range providers[supplemented.Name] {
+						if provider != pkg {
+							addEdge(provider, pkg, "supplements", dep.String())
+						}
+					}
+				}
+			}
+		}
+	}
+	return graph
+}
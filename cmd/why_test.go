@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/rmohr/bazeldnf/pkg/api"
+)
+
+func TestDependencyChains(t *testing.T) {
+	app := newPackageWithDeps("app", "libfoo", "/usr/bin/perl")
+	tool := newPackageWithDeps("tool", "/usr/bin/perl")
+	libfoo := newPackageWithDeps("libfoo", "perl-libs")
+	perl := newPackageWithDeps("perl", "perl-libs")
+	perl.Format.Files = []api.ProvidedFile{{Text: "/usr/bin/perl"}}
+	perlLibs := newPackageWithProvides("perl-libs")
+	docs := newPackageWithDeps("docs", "perl")
+	app = withWeakDeps(app, []string{"docs"}, nil)
+	perlDoc := withWeakDeps(newPackageWithProvides("perl-doc"), nil, []string{"perl"})
+
+	install := []*api.Package{app, tool, libfoo, perl, perlLibs, docs, perlDoc}
+
+	tests := []struct {
+		name      string
+		requested []*api.Package
+		pkg       string
+		weakDeps  bool
+		chains    []string
+	}{
+		{name: "all shortest chains", requested: []*api.Package{app, tool}, pkg: "perl-libs", chains: []string{
+			"app-0: (repository)\n  requires libfoo, provided by libfoo-0: (repository)\n  requires perl-libs, provided by perl-libs-0: (repository)\n",
+			"app-0: (repository)\n  requires /usr/bin/perl, provided by perl-0: (repository)\n  requires perl-libs, provided by perl-libs-0: (repository)\n",
+			"tool-0: (repository)\n  requires /usr/bin/perl, provided by perl-0: (repository)\n  requires perl-libs, provided by perl-libs-0: (repository)\n",
+		}},
+		{name: "requested package", requested: []*api.Package{app, tool}, pkg: "tool", chains: []string{
+			"tool-0: (repository) is requested\n",
+		}},
+		{name: "recommended package", requested: []*api.Package{app}, pkg: "docs", weakDeps: true, chains: []string{
+			"app-0: (repository)\n  recommends docs, provided by docs-0: (repository)\n",
+		}},
+		{name: "supplementing package", requested: []*api.Package{tool}, pkg: "perl-doc", weakDeps: true, chains: []string{
+			"tool-0: (repository)\n  requires /usr/bin/perl, provided by perl-0: (repository)\n  supplemented by perl-doc-0: (repository), which supplements perl\n",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			chains, total, err := dependencyChains(tt.requested, install, tt.pkg, tt.weakDeps, 0)
+			g.Expect(err).ToNot(HaveOccurred())
+			var printed []string
+			for _, chain := range chains {
+				printed = append(printed, chain.String())
+			}
+			g.Expect(printed).To(Equal(tt.chains))
+			g.Expect(total).To(Equal(len(tt.chains)))
+		})
+	}
+}
+
+func TestDependencyChainsErrors(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	app := withWeakDeps(newPackageWithDeps("app"), []string{"docs"}, nil)
+	docs := newPackageWithProvides("docs")
+	install := []*api.Package{app, docs}
+
+	_, _, err := dependencyChains([]*api.Package{app}, install, "perl", false, 0)
+	g.Expect(err).To(MatchError("package perl is not part of the resolved packages"))
+
+	_, _, err = dependencyChains([]*api.Package{app}, install, "docs", false, 0)
+	g.Expect(err).To(MatchError(ContainSubstring("no chain of dependencies leads from the requested packages to docs")))
+}
+
+func TestDependencyChainsWideDiamond(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	// every level of 20 alternative providers multiplies the number of
+	// shortest chains, so that 20^5 chains lead from app to base
+	var install []*api.Package
+	var previous []string
+	for level := 0; level < 5; level++ {
+		var names []string
+		for i := 0; i < 20; i++ {
+			name := fmt.Sprintf("level%d-%d", level, i)
+			install = append(install, newPackageWithProvides(name, fmt.Sprintf("level%d", level)))
+			names = append(names, name)
+		}
+		for _, pkg := range install[len(install)-20-len(previous) : len(install)-20] {
+			pkg.Format.Requires.Entries = []api.Entry{{Name: fmt.Sprintf("level%d", level)}}
+		}
+		previous = names
+	}
+	for _, pkg := range install[len(install)-20:] {
+		pkg.Format.Requires.Entries = []api.Entry{{Name: "base"}}
+	}
+	app := newPackageWithDeps("app", "level0")
+	base := newPackageWithProvides("base")
+	install = append(install, app, base)
+
+	chains, total, err := dependencyChains([]*api.Package{app}, install, "base", false, 10)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(chains).To(HaveLen(10))
+	g.Expect(total).To(Equal(20 * 20 * 20 * 20 * 20))
+	for _, chain := range chains {
+		g.Expect(chain.start).To(BeIdenticalTo(app))
+		g.Expect(chain.edges).To(HaveLen(6))
+	}
+}
+
+func TestDependencyChainsSupplementsSeveralCapabilities(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	app := newPackageWithDeps("app", "perl")
+	perl := newPackageWithProvides("perl", "perl(strict)", "perl(warnings)")
+	langpack := withWeakDeps(newPackageWithProvides("perl-langpack"), nil, []string{"perl", "perl(strict)", "perl(warnings)"})
+
+	chains, total, err := dependencyChains([]*api.Package{app}, []*api.Package{app, perl, langpack}, "perl-langpack", true, 0)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(total).To(Equal(1))
+	g.Expect(chains).To(HaveLen(1))
+	g.Expect(chains[0].String()).To(Equal("app-0: (repository)\n  requires perl, provided by perl-0: (repository)\n  supplemented by perl-langpack-0: (repository), which supplements perl, perl(strict), perl(warnings)\n"))
+}
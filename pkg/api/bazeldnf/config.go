@@ -7,6 +7,9 @@ type RPM struct {
 	URLs         []string `json:"urls"`
 	Repository   string   `json:"repository"`
 	Dependencies []string `json:"dependencies"`
+	// WeakOnly marks packages which are only installed because of weak
+	// dependencies
+	WeakOnly bool `json:"weak_only,omitempty"`
 }
 
 type Config struct {
@@ -1,16 +1,49 @@
 package bazeldnf
 
+// DefaultPriority is the priority of repositories which don't set one, like in dnf
+const DefaultPriority = 99
+
 type Repositories struct {
 	Repositories []Repository `json:"repositories"`
 }
 
 type Repository struct {
-	Name     string   `json:"name"`
-	Disabled bool     `json:"disabled,omitempty"`
-	Metalink string   `json:"metalink,omitempty"`
-	Baseurl  string   `json:"baseurl,omitempty"`
-	Arch     string   `json:"arch"`
-	Mirrors  []string `json:"mirrors,omitempty"`
-	GPGKey   string   `json:"gpgkey,omitempty"`
-	Priority int      `json:"priority,omitempty"`
+	Name         string   `json:"name"`
+	Disabled     bool     `json:"disabled,omitempty"`
+	Metalink     string   `json:"metalink,omitempty"`
+	Mirrorlist   string   `json:"mirrorlist,omitempty"`
+	Baseurl      string   `json:"baseurl,omitempty"`
+	Arch         string   `json:"arch"`
+	Mirrors      []string `json:"mirrors,omitempty"`
+	GPGKey       string   `json:"gpgkey,omitempty"`
+	Priority     int      `json:"priority,omitempty"`
+	RepoGPGCheck bool     `json:"repo_gpgcheck,omitempty"`
+	IncludePkgs  []string `json:"includepkgs,omitempty"`
+	ExcludePkgs  []string `json:"excludepkgs,omitempty"`
+	// SSLCACert is a file with the certificate authorities which are used
+	// instead of the system ones to verify the servers of the repository
+	SSLCACert     string `json:"sslcacert,omitempty"`
+	SSLClientCert string `json:"sslclientcert,omitempty"`
+	SSLClientKey  string `json:"sslclientkey,omitempty"`
+	SSLVerify     *bool  `json:"sslverify,omitempty"`
+	// Proxy is the URL of the proxy for the repository, `_none_` disables
+	// the proxies from the environment
+	Proxy         string `json:"proxy,omitempty"`
+	ProxyUsername string `json:"proxy_username,omitempty"`
+	ProxyPassword string `json:"proxy_password,omitempty"`
+}
+
+// EffectivePriority returns the priority of the repository, falling back to
+// DefaultPriority if none is set. Lower values take precedence.
+func (r *Repository) EffectivePriority() int {
+	if r.Priority == 0 {
+		return DefaultPriority
+	}
+	return r.Priority
+}
+
+// EffectiveSSLVerify returns if server certificates of the repository are
+// verified, which is the default.
+func (r *Repository) EffectiveSSLVerify() bool {
+	return r.SSLVerify == nil || *r.SSLVerify
 }
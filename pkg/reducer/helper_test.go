@@ -24,13 +24,10 @@ func newPackageList(names ...string) []api.Package {
 }
 
 func newPackage(name string) api.Package {
-	r := api.Package{
+	return api.Package{
 		Name: name,
 		Arch: "x86_64",
 	}
-	r.Format.Requires = api.Dependencies{Entries: []api.Entry{}}
-
-	return r
 }
 
 func toDeps(deps ...string) api.Dependencies {
@@ -1,16 +1,19 @@
 package reducer
 
 import (
-	"encoding/xml"
+	"fmt"
 	"os"
+	"path"
 	"strings"
 
 	"github.com/rmohr/bazeldnf/pkg/api"
 	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+	"github.com/rmohr/bazeldnf/pkg/repo"
+	"github.com/sirupsen/logrus"
 )
 
 type RepoCache interface {
-	CurrentPrimaries(repos *bazeldnf.Repositories, architectures []string) (primaries []*api.Repository, err error)
+	WalkPrimaries(repos *bazeldnf.Repositories, architectures []string, fn func(pkg *api.Package) error) error
 }
 
 type ReducerPackageLoader interface {
@@ -33,66 +36,121 @@ type RepoLoader struct {
 	cacheHelper   RepoCache
 }
 
+// Load streams the packages of all repositories and only keeps the ones which
+// are candidates for the reducer, so that the full repository metadata never
+// has to be in memory at once.
 func (r RepoLoader) Load() (*packageInfo, error) {
-	packageInfo := &packageInfo{
-		packages: []api.Package{},
-		provides: map[string][]*api.Package{},
+	collector := &packageCollector{
+		architectures: r.architectures,
+		provides:      map[string][]int{},
 	}
 
+	warnAboutDefaultPriorities(r.repos)
+
 	for _, rpmrepo := range r.repoFiles {
-		repoFile := &api.Repository{}
-		f, err := os.Open(rpmrepo)
-		if err != nil {
-			return packageInfo, err
-		}
-		defer f.Close()
-		err = xml.NewDecoder(f).Decode(repoFile)
-		if err != nil {
-			return packageInfo, err
-		}
-		for i, p := range repoFile.Packages {
-			if skip(p.Arch, r.architectures) {
-				continue
-			}
-			packageInfo.packages = append(packageInfo.packages, repoFile.Packages[i])
+		if err := collector.addRepoFile(rpmrepo); err != nil {
+			return &packageInfo{}, err
 		}
 	}
 
-	cachedRepos, err := r.cacheHelper.CurrentPrimaries(r.repos, r.architectures)
-	if err != nil {
-		return packageInfo, err
+	if err := r.cacheHelper.WalkPrimaries(r.repos, r.architectures, collector.add); err != nil {
+		return &packageInfo{}, err
 	}
-	for _, rpmrepo := range cachedRepos {
-		for i, p := range rpmrepo.Packages {
-			if skip(p.Arch, r.architectures) {
-				continue
-			}
-			packageInfo.packages = append(packageInfo.packages, rpmrepo.Packages[i])
-		}
+
+	return collector.packageInfo(), nil
+}
+
+// packageCollector gathers the candidate packages and indexes their provides
+// while the packages are read
+type packageCollector struct {
+	architectures []string
+	packages      []api.Package
+	// provides maps provisions to positions in packages, since the packages
+	// move in memory while the slice grows
+	provides map[string][]int
+}
+
+func (c *packageCollector) addRepoFile(rpmrepo string) error {
+	f, err := os.Open(rpmrepo)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
+	return repo.WalkPrimaryXML(f, c.add)
+}
 
-	for i, _ := range packageInfo.packages {
-		FixPackages(&packageInfo.packages[i])
+func (c *packageCollector) add(p *api.Package) error {
+	if skip(p.Arch, c.architectures) {
+		return nil
+	}
+	if filtered, err := filteredByRepository(p); err != nil {
+		return err
+	} else if filtered {
+		logrus.Debugf("Package %s is filtered by the includepkgs/excludepkgs of its repository", p.String())
+		return nil
 	}
 
-	for i, p := range packageInfo.packages {
-		requires := []api.Entry{}
-		for _, requirement := range p.Format.Requires.Entries {
-			if !strings.HasPrefix(requirement.Name, "(") {
-				requires = append(requires, requirement)
-			}
-		}
-		packageInfo.packages[i].Format.Requires.Entries = requires
+	trimPackage(p)
+	FixPackages(p)
 
-		for _, p := range p.Format.Provides.Entries {
-			packageInfo.provides[p.Name] = append(packageInfo.provides[p.Name], &packageInfo.packages[i])
+	i := len(c.packages)
+	c.packages = append(c.packages, *p)
+	for _, provide := range p.Format.Provides.Entries {
+		c.provides[provide.Name] = append(c.provides[provide.Name], i)
+	}
+	for _, file := range p.Format.Files {
+		c.provides[file.Text] = append(c.provides[file.Text], i)
+	}
+	return nil
+}
+
+// packageInfo drops the packages shadowed by repositories with a higher
+// priority and resolves the provides index to the remaining packages. The
+// remaining packages are compacted in place, so that the collected packages
+// are never held twice in memory.
+func (c *packageCollector) packageInfo() *packageInfo {
+	shadowed := shadowedByPriority(c.packages)
+	positions := make([]int, len(c.packages))
+	kept := 0
+	for i := range c.packages {
+		if shadowed[i] {
+			positions[i] = -1
+			continue
 		}
-		for _, file := range p.Format.Files {
-			packageInfo.provides[file.Text] = append(packageInfo.provides[file.Text], &packageInfo.packages[i])
+		positions[i] = kept
+		c.packages[kept] = c.packages[i]
+		kept++
+	}
+	// release the shadowed packages
+	clear(c.packages[kept:])
+	packageInfo := &packageInfo{
+		packages: c.packages[:kept],
+		provides: make(map[string][]*api.Package, len(c.provides)),
+	}
+	c.packages = nil
+	for name, indexes := range c.provides {
+		for _, i := range indexes {
+			if positions[i] >= 0 {
+				packageInfo.provides[name] = append(packageInfo.provides[name], &packageInfo.packages[positions[i]])
+			}
 		}
+		delete(c.provides, name)
 	}
+	return packageInfo
+}
 
-	return packageInfo, nil
+// trimPackage drops the descriptive fields of a package which play no role in
+// dependency resolution, to save memory
+func trimPackage(p *api.Package) {
+	p.Summary = ""
+	p.Description = ""
+	p.Packager = ""
+	p.URL = ""
+	p.Time.File, p.Time.Build, p.Time.Text = "", "", ""
+	p.Format.License = ""
+	p.Format.Vendor = ""
+	p.Format.Group = ""
+	p.Format.Buildhost = ""
 }
 
 // FixPackages contains hacks which should probably not have to exist
@@ -113,6 +171,98 @@ func FixPackages(p *api.Package) {
 	}
 }
 
+// shadowedByPriority marks all packages of which a package with the same name
+// and arch is available in a repository with a higher priority, like dnf
+// does. Builds for other architectures, like noarch or i686 builds of the same
+// name, are not affected. Packages which don't come from a configured
+// repository are never shadowed.
+func shadowedByPriority(packages []api.Package) []bool {
+	type nameArch struct{ name, arch string }
+	best := map[nameArch]int{}
+	for _, p := range packages {
+		if p.Repository == nil {
+			continue
+		}
+		key := nameArch{p.Name, p.Arch}
+		if priority, exists := best[key]; !exists || p.Repository.EffectivePriority() < priority {
+			best[key] = p.Repository.EffectivePriority()
+		}
+	}
+
+	shadowed := make([]bool, len(packages))
+	for i, p := range packages {
+		if p.Repository != nil && p.Repository.EffectivePriority() > best[nameArch{p.Name, p.Arch}] {
+			logrus.Debugf("Package %s is shadowed by a repository with a higher priority", p.String())
+			shadowed[i] = true
+		}
+	}
+	return shadowed
+}
+
+// warnAboutDefaultPriorities points out repositories which rely on the
+// default priority while others set a higher one. Older bazeldnf versions
+// preferred repositories without a priority over all others, dnf and bazeldnf
+// now prefer the ones with the higher priority.
+func warnAboutDefaultPriorities(repos *bazeldnf.Repositories) {
+	if repos == nil {
+		return
+	}
+	var unset, preferred []string
+	for _, repo := range repos.Repositories {
+		if repo.Disabled {
+			continue
+		}
+		if repo.Priority == 0 {
+			unset = append(unset, repo.Name)
+		} else if repo.Priority < bazeldnf.DefaultPriority {
+			preferred = append(preferred, repo.Name)
+		}
+	}
+	if len(unset) > 0 && len(preferred) > 0 {
+		logrus.Warnf("Repositories %s have no priority and default to %d like in dnf, so %s take precedence over them. Older bazeldnf versions preferred repositories without a priority, set their priority explicitly to keep this.",
+			strings.Join(unset, ", "), bazeldnf.DefaultPriority, strings.Join(preferred, ", "))
+	}
+}
+
+// filteredByRepository checks whether the includepkgs and excludepkgs globs of
+// the repository of a package prevent it from being a candidate. Like in dnf,
+// globs are matched against the name of the package as well as against its
+// full name including version and arch.
+func filteredByRepository(p *api.Package) (bool, error) {
+	if p.Repository == nil {
+		return false, nil
+	}
+	if len(p.Repository.IncludePkgs) > 0 {
+		included, err := matchesAnyGlob(p, p.Repository.IncludePkgs)
+		if err != nil {
+			return false, fmt.Errorf("invalid includepkgs of repository %s: %v", p.Repository.Name, err)
+		}
+		if !included {
+			return true, nil
+		}
+	}
+	excluded, err := matchesAnyGlob(p, p.Repository.ExcludePkgs)
+	if err != nil {
+		return false, fmt.Errorf("invalid excludepkgs of repository %s: %v", p.Repository.Name, err)
+	}
+	return excluded, nil
+}
+
+func matchesAnyGlob(p *api.Package, globs []string) (bool, error) {
+	for _, glob := range globs {
+		for _, name := range []string{p.Name, p.MatchableString()} {
+			matched, err := path.Match(glob, name)
+			if err != nil {
+				return false, err
+			}
+			if matched {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
 func skip(arch string, arches []string) bool {
 	skip := true
 	for _, a := range arches {
@@ -16,16 +16,24 @@ type ErrorCacheHelper struct {
 	err error
 }
 
-func (h ErrorCacheHelper) CurrentPrimaries(_ *bazeldnf.Repositories, _ []string) (primaries []*api.Repository, err error) {
-	return nil, h.err
+func (h ErrorCacheHelper) WalkPrimaries(_ *bazeldnf.Repositories, _ []string, _ func(pkg *api.Package) error) error {
+	return h.err
 }
 
 type MockCacheHelper struct {
 	repos []*api.Repository
 }
 
-func (h MockCacheHelper) CurrentPrimaries(_ *bazeldnf.Repositories, _ []string) (primaries []*api.Repository, err error) {
-	return h.repos, nil
+func (h MockCacheHelper) WalkPrimaries(_ *bazeldnf.Repositories, _ []string, fn func(pkg *api.Package) error) error {
+	for _, repo := range h.repos {
+		for i := range repo.Packages {
+			pkg := repo.Packages[i]
+			if err := fn(&pkg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func load(t *testing.T, repos []api.Repository, architectures []string, cacheHelper RepoCache) (*packageInfo, error) {
@@ -168,7 +176,7 @@ func TestLoaderFixPackages(t *testing.T) {
 		MockCacheHelper{},
 	)
 
-	expectedPackages := newPackageWithDeps("platform-python", []string{}, []string{dep})
+	expectedPackages := newPackageWithDeps("platform-python", nil, []string{dep})
 	g.Expect(err).Should(BeNil())
 	g.Expect(packageInfo.packages).Should(ConsistOf(expectedPackages))
 	g.Expect(packageInfo.provides).Should(BeEquivalentTo(
@@ -233,11 +241,11 @@ func TestLoaderCachedVsRealRepo(t *testing.T) {
 	g.Expect(len(packageInfo.provides)).Should(BeZero())
 }
 
-func TestLoaderPruneRequires(t *testing.T) {
+func TestLoaderKeepRichRequires(t *testing.T) {
 	g := NewGomegaWithT(t)
 
 	repoPackages := []api.Package{
-		newPackageWithDeps("baf", []string{"burgle", "(burgle)"}, nil),
+		newPackageWithDeps("baf", []string{"burgle", "(burgle if bazzle)"}, nil),
 	}
 
 	packageInfo, err := load(
@@ -249,7 +257,7 @@ func TestLoaderPruneRequires(t *testing.T) {
 		MockCacheHelper{},
 	)
 
-	expectedPackages := newPackageWithDeps("baf", []string{"burgle"}, nil)
+	expectedPackages := newPackageWithDeps("baf", []string{"burgle", "(burgle if bazzle)"}, nil)
 
 	g.Expect(err).Should(BeNil())
 	g.Expect(packageInfo.packages).Should(ConsistOf(expectedPackages))
@@ -260,7 +268,7 @@ func TestLoaderCaptureProvides(t *testing.T) {
 	g := NewGomegaWithT(t)
 
 	repoPackages := []api.Package{
-		newPackageWithDeps("baf", []string{}, []string{"burgle"}),
+		newPackageWithDeps("baf", nil, []string{"burgle"}),
 	}
 	repoPackages[0].Format.Files = []api.ProvidedFile{
 		api.ProvidedFile{Text: "bazzle"},
@@ -283,3 +291,186 @@ func TestLoaderCaptureProvides(t *testing.T) {
 	g.Expect(packageInfo.packages).Should(ConsistOf(repoPackages[0]))
 	g.Expect(packageInfo.provides).Should(BeComparableTo(expectedProvides))
 }
+
+func TestLoaderRepositoryPackageFilters(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	internal := &bazeldnf.Repository{Name: "internal", ExcludePkgs: []string{"kernel*", "foo-0:2.0*"}}
+	tools := &bazeldnf.Repository{Name: "tools", IncludePkgs: []string{"tool-*"}}
+	internalPackages := newPackageList("kernel", "kernel-core", "foo", "foo", "bar")
+	internalPackages[2].Version = api.Version{Ver: "1.0"}
+	internalPackages[3].Version = api.Version{Ver: "2.0"}
+	for i := range internalPackages {
+		internalPackages[i].Repository = internal
+	}
+	toolsPackages := newPackageList("tool-a", "tool-b", "kernel")
+	for i := range toolsPackages {
+		toolsPackages[i].Repository = tools
+	}
+
+	packageInfo, err := load(
+		t,
+		[]api.Repository{},
+		[]string{"x86_64"},
+		MockCacheHelper{
+			repos: []*api.Repository{
+				&api.Repository{Packages: internalPackages},
+				&api.Repository{Packages: toolsPackages},
+			},
+		},
+	)
+
+	g.Expect(err).Should(BeNil())
+	g.Expect(packageInfo.packages).Should(ConsistOf(internalPackages[2], internalPackages[4], toolsPackages[0], toolsPackages[1]))
+}
+
+func TestLoaderRepositoryPackageFiltersInvalidGlob(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	packages := newPackageList("foo")
+	packages[0].Repository = &bazeldnf.Repository{Name: "broken", ExcludePkgs: []string{"foo["}}
+
+	_, err := load(
+		t,
+		[]api.Repository{},
+		[]string{"x86_64"},
+		MockCacheHelper{
+			repos: []*api.Repository{
+				&api.Repository{Packages: packages},
+			},
+		},
+	)
+
+	g.Expect(err).Should(MatchError(ContainSubstring("invalid excludepkgs of repository broken")))
+}
+
+func TestLoaderShadowByRepositoryPriority(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	fedora := &bazeldnf.Repository{Name: "fedora"}
+	internal := &bazeldnf.Repository{Name: "internal", Priority: 10}
+	fedoraPackages := newPackageList("foo", "bar")
+	fedoraPackages[0].Version = api.Version{Ver: "2.0"}
+	for i := range fedoraPackages {
+		fedoraPackages[i].Repository = fedora
+	}
+	internalPackages := newPackageList("foo", "baz")
+	internalPackages[0].Version = api.Version{Ver: "1.0"}
+	for i := range internalPackages {
+		internalPackages[i].Repository = internal
+	}
+	localPackages := newPackageList("foo")
+
+	packageInfo, err := load(
+		t,
+		[]api.Repository{
+			api.Repository{Packages: localPackages},
+		},
+		[]string{"x86_64"},
+		MockCacheHelper{
+			repos: []*api.Repository{
+				&api.Repository{Packages: fedoraPackages},
+				&api.Repository{Packages: internalPackages},
+			},
+		},
+	)
+
+	g.Expect(err).Should(BeNil())
+	g.Expect(packageInfo.packages).Should(ConsistOf(localPackages[0], fedoraPackages[1], internalPackages[0], internalPackages[1]))
+}
+
+func TestLoaderShadowByRepositoryPriorityPerArch(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	fedora := &bazeldnf.Repository{Name: "fedora"}
+	internal := &bazeldnf.Repository{Name: "internal", Priority: 10}
+	fedoraPackages := newPackageList("foo", "foo", "foo")
+	fedoraPackages[1].Arch = "i686"
+	fedoraPackages[2].Arch = "noarch"
+	for i := range fedoraPackages {
+		fedoraPackages[i].Repository = fedora
+	}
+	internalPackages := newPackageList("foo")
+	internalPackages[0].Repository = internal
+
+	packageInfo, err := load(
+		t,
+		[]api.Repository{},
+		[]string{"x86_64", "i686", "noarch"},
+		MockCacheHelper{
+			repos: []*api.Repository{
+				&api.Repository{Packages: fedoraPackages},
+				&api.Repository{Packages: internalPackages},
+			},
+		},
+	)
+
+	g.Expect(err).Should(BeNil())
+	g.Expect(packageInfo.packages).Should(ConsistOf(fedoraPackages[1], fedoraPackages[2], internalPackages[0]))
+}
+
+func TestLoaderShadowedPackagesProvideNothing(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	fedora := &bazeldnf.Repository{Name: "fedora"}
+	internal := &bazeldnf.Repository{Name: "internal", Priority: 10}
+	fedoraPackages := []api.Package{
+		newPackageWithDeps("foo", []string{}, []string{"libfoo"}),
+		newPackageWithDeps("bar", []string{}, []string{"libbar"}),
+	}
+	for i := range fedoraPackages {
+		fedoraPackages[i].Repository = fedora
+	}
+	internalPackages := []api.Package{
+		newPackageWithDeps("foo", []string{}, []string{"libfoo"}),
+	}
+	internalPackages[0].Repository = internal
+	internalPackages[0].Description = "patched build"
+
+	packageInfo, err := load(
+		t,
+		[]api.Repository{},
+		[]string{"x86_64"},
+		MockCacheHelper{
+			repos: []*api.Repository{
+				&api.Repository{Packages: fedoraPackages},
+				&api.Repository{Packages: internalPackages},
+			},
+		},
+	)
+
+	internalPackages[0].Description = ""
+	g.Expect(err).Should(BeNil())
+	g.Expect(packageInfo.packages).Should(ConsistOf(fedoraPackages[1], internalPackages[0]))
+	g.Expect(packageInfo.provides).Should(HaveLen(2))
+	g.Expect(packageInfo.provides["libfoo"]).Should(ConsistOf(&internalPackages[0]))
+	g.Expect(packageInfo.provides["libbar"]).Should(ConsistOf(&fedoraPackages[1]))
+	g.Expect(packageInfo.provides["libfoo"][0]).Should(BeIdenticalTo(&packageInfo.packages[1]))
+}
+
+func TestPackageCollectorCompactsInPlace(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	fedora := &bazeldnf.Repository{Name: "fedora"}
+	internal := &bazeldnf.Repository{Name: "internal", Priority: 10}
+	collector := &packageCollector{architectures: []string{"x86_64"}, provides: map[string][]int{}}
+	for _, p := range []api.Package{
+		newPackageWithDeps("foo", []string{}, []string{"libfoo"}),
+		newPackageWithDeps("bar", []string{}, []string{"libbar"}),
+		newPackageWithDeps("foo", []string{}, []string{"libfoo"}),
+	} {
+		p.Repository = fedora
+		if len(collector.packages) == 2 {
+			p.Repository = internal
+		}
+		g.Expect(collector.add(&p)).To(Succeed())
+	}
+	collected := &collector.packages[0]
+
+	packageInfo := collector.packageInfo()
+	g.Expect(packageInfo.packages).Should(HaveLen(2))
+	g.Expect(&packageInfo.packages[0]).Should(BeIdenticalTo(collected))
+	g.Expect(packageInfo.packages[0].Name).Should(Equal("bar"))
+	g.Expect(packageInfo.packages[1].Repository).Should(BeIdenticalTo(internal))
+	g.Expect(packageInfo.provides["libfoo"]).Should(ConsistOf(&packageInfo.packages[1]))
+}
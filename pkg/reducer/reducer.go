@@ -1,19 +1,36 @@
 package reducer
 
 import (
+	"cmp"
 	"fmt"
 	"strings"
 
 	"github.com/rmohr/bazeldnf/pkg/api"
 	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
 	"github.com/rmohr/bazeldnf/pkg/repo"
+	"github.com/rmohr/bazeldnf/pkg/rpm"
 	"github.com/sirupsen/logrus"
 )
 
+// FilelistsCache provides the content of the filelists of the repositories
+type FilelistsCache interface {
+	WalkFilelists(repos *bazeldnf.Repositories, architectures []string, fn func(pkg *api.FileListPackage) error) error
+}
+
 type RepoReducer struct {
 	packageInfo      *packageInfo
 	implicitRequires []string
 	loader           ReducerPackageLoader
+	// filelists are consulted for file requirements which no package
+	// provides according to primary.xml
+	filelists     FilelistsCache
+	repos         *bazeldnf.Repositories
+	architectures []string
+	// searchedFiles remembers which files were already looked up in the
+	// filelists, to scan them at most once per file
+	searchedFiles map[string]struct{}
+	// weakDeps adds the candidates for Recommends and reverse Supplements
+	weakDeps bool
 }
 
 func (r *RepoReducer) Load() error {
@@ -46,6 +63,7 @@ func (r *RepoReducer) Resolve(packages []string, ignoreMissing bool) (matched []
 	packages = append(packages, r.implicitRequires...)
 	discovered := map[api.PackageKey]*api.Package{}
 	pinned := map[string]*api.Package{}
+	var requested []*api.Package
 	for _, req := range packages {
 		found := false
 		name := ""
@@ -69,7 +87,7 @@ func (r *RepoReducer) Resolve(packages []string, ignoreMissing bool) (matched []
 			if selected, ok := discovered[p.Key()]; !ok {
 				discovered[p.Key()] = candidates[i]
 			} else {
-				if selected.Repository.Priority > p.Repository.Priority {
+				if selected.Repository.EffectivePriority() > p.Repository.EffectivePriority() {
 					discovered[p.Key()] = candidates[i]
 				}
 			}
@@ -78,29 +96,76 @@ func (r *RepoReducer) Resolve(packages []string, ignoreMissing bool) (matched []
 		if len(candidates) > 0 {
 			matched = append(matched, candidates[0].Name)
 		}
+		requested = append(requested, candidates...)
 	}
 
 	for _, v := range discovered {
 		pinned[v.Name] = v
 	}
 
-	for {
-		current := []api.PackageKey{}
-		for k := range discovered {
-			current = append(current, k)
+	// the solver prefers the packages obsoleting a requested package, like
+	// dnf does for renamed packages. Unlike the requested packages they are
+	// not pinned, and of every obsoleting package only the newest one from
+	// the most preferred repository is added.
+	obsoletes := r.obsoletesIndex()
+	obsoleters := map[string]*api.Package{}
+	for _, p := range requested {
+		for _, obsoleting := range obsoletes[p.Name] {
+			if _, exists := pinned[obsoleting.Name]; exists {
+				continue
+			}
+			if best, exists := obsoleters[obsoleting.Name]; !exists || preferObsoleting(obsoleting, best) {
+				obsoleters[obsoleting.Name] = obsoleting
+			}
 		}
-		for _, p := range current {
-			for _, newFound := range r.requires(discovered[p]) {
-				if _, exists := discovered[newFound.Key()]; !exists {
+	}
+	for _, obsoleting := range obsoleters {
+		if _, exists := discovered[obsoleting.Key()]; !exists {
+			discovered[obsoleting.Key()] = obsoleting
+		}
+	}
+
+	var supplementers map[string][]*api.Package
+	if r.weakDeps {
+		supplementers = r.supplementsIndex()
+	}
+	for {
+		for {
+			current := []api.PackageKey{}
+			for k := range discovered {
+				current = append(current, k)
+			}
+			for _, p := range current {
+				wants := r.requires(discovered[p])
+				if r.weakDeps {
+					wants = append(wants, r.recommends(discovered[p])...)
+				}
+				for _, newFound := range wants {
+					if _, exists := discovered[newFound.Key()]; !exists {
+						if _, exists := pinned[newFound.Name]; !exists {
+							discovered[newFound.Key()] = newFound
+						} else {
+							logrus.Debugf("excluding %s because of pinned dependency %s", newFound.String(), pinned[newFound.Name].String())
+						}
+					}
+				}
+			}
+			if r.weakDeps {
+				for _, newFound := range r.supplementing(discovered, supplementers) {
 					if _, exists := pinned[newFound.Name]; !exists {
 						discovered[newFound.Key()] = newFound
-					} else {
-						logrus.Debugf("excluding %s because of pinned dependency %s", newFound.String(), pinned[newFound.Name].String())
 					}
 				}
 			}
+			if len(current) == len(discovered) {
+				break
+			}
+		}
+		found, err := r.provideFilesFromFilelists(discovered)
+		if err != nil {
+			return nil, nil, err
 		}
-		if len(current) == len(discovered) {
+		if !found {
 			break
 		}
 	}
@@ -109,6 +174,22 @@ func (r *RepoReducer) Resolve(packages []string, ignoreMissing bool) (matched []
 	for i, pkg := range discovered {
 		for _, req := range pkg.Format.Requires.Entries {
 			required[req.Name] = struct{}{}
+			// the capabilities in rich dependencies, including conditions,
+			// have to stay resolvable
+			if rpm.IsRichDependency(req.Name) {
+				for _, entry := range richEntries(req, (*rpm.Dependency).Entries) {
+					required[entry.Name] = struct{}{}
+				}
+			}
+		}
+		if r.weakDeps {
+			for _, weak := range [][]api.Entry{pkg.Format.Recommends.Entries, pkg.Format.Supplements.Entries} {
+				for _, dep := range weak {
+					for _, entry := range richEntries(dep, (*rpm.Dependency).Entries) {
+						required[entry.Name] = struct{}{}
+					}
+				}
+			}
 		}
 		involved = append(involved, discovered[i])
 	}
@@ -127,7 +208,7 @@ func (r *RepoReducer) Resolve(packages []string, ignoreMissing bool) (matched []
 }
 
 func (r *RepoReducer) requires(p *api.Package) (wants []*api.Package) {
-	for _, requires := range p.Format.Requires.Entries {
+	for _, requires := range simpleRequires(p) {
 		if val, exists := r.packageInfo.provides[requires.Name]; exists {
 			var packages []string
 			for _, p := range val {
@@ -143,7 +224,171 @@ func (r *RepoReducer) requires(p *api.Package) (wants []*api.Package) {
 	return wants
 }
 
-func NewRepoReducer(repos *bazeldnf.Repositories, repoFiles []string, baseSystem string, architectures []string, cacheHelper *repo.CacheHelper) *RepoReducer {
+// recommends returns the providers of the recommendations of a package
+func (r *RepoReducer) recommends(p *api.Package) (wants []*api.Package) {
+	for _, rec := range p.Format.Recommends.Entries {
+		for _, entry := range richEntries(rec, (*rpm.Dependency).Installable) {
+			if val, exists := r.packageInfo.provides[entry.Name]; exists {
+				logrus.Debugf("%s recommends %v\n", p.Name, rec)
+				wants = append(wants, val...)
+			}
+		}
+	}
+	return wants
+}
+
+// supplementsIndex maps the capabilities named in the Supplements of all
+// packages to the packages supplementing them
+func (r *RepoReducer) supplementsIndex() map[string][]*api.Package {
+	index := map[string][]*api.Package{}
+	for i := range r.packageInfo.packages {
+		p := &r.packageInfo.packages[i]
+		for _, sup := range p.Format.Supplements.Entries {
+			for _, entry := range richEntries(sup, (*rpm.Dependency).Entries) {
+				index[entry.Name] = append(index[entry.Name], p)
+			}
+		}
+	}
+	return index
+}
+
+// supplementing returns the packages which are not yet discovered and which
+// supplement a capability provided by a discovered package. Capabilities
+// which are provided by a discovered package are removed from the index,
+// since their supplementing packages only have to be returned once.
+func (r *RepoReducer) supplementing(discovered map[api.PackageKey]*api.Package, index map[string][]*api.Package) (wants []*api.Package) {
+	for capability, supplementers := range index {
+		for _, provider := range r.packageInfo.provides[capability] {
+			if _, exists := discovered[provider.Key()]; !exists {
+				continue
+			}
+			for _, p := range supplementers {
+				if _, exists := discovered[p.Key()]; !exists {
+					logrus.Debugf("%s supplements %v\n", p.Name, capability)
+					wants = append(wants, p)
+				}
+			}
+			delete(index, capability)
+			break
+		}
+	}
+	return wants
+}
+
+// obsoletesIndex maps package names to the packages which obsolete them by
+// name. The versions of the obsoletes are left to the solver.
+func (r *RepoReducer) obsoletesIndex() map[string][]*api.Package {
+	index := map[string][]*api.Package{}
+	for i := range r.packageInfo.packages {
+		p := &r.packageInfo.packages[i]
+		for _, obs := range p.Format.Obsoletes.Entries {
+			if obs.Name == p.Name {
+				continue
+			}
+			logrus.Debugf("%s obsoletes %v\n", p.Name, obs)
+			index[obs.Name] = append(index[obs.Name], p)
+		}
+	}
+	return index
+}
+
+// preferObsoleting returns true if the obsoleting package a is preferred over
+// b, which has the same name: first by the highest version, then by the
+// priority of the repository and finally by arch, to stay deterministic.
+func preferObsoleting(a, b *api.Package) bool {
+	return cmp.Or(
+		rpm.Compare(a.Version, b.Version),
+		b.Repository.EffectivePriority()-a.Repository.EffectivePriority(),
+		cmp.Compare(a.Arch, b.Arch),
+	) > 0
+}
+
+// simpleRequires returns the requirements of a package, with rich
+// dependencies replaced by the capabilities which may have to be installed to
+// satisfy them
+func simpleRequires(p *api.Package) []api.Entry {
+	requires := []api.Entry{}
+	for _, req := range p.Format.Requires.Entries {
+		if rpm.IsRichDependency(req.Name) {
+			requires = append(requires, richEntries(req, (*rpm.Dependency).Installable)...)
+		} else {
+			requires = append(requires, req)
+		}
+	}
+	return requires
+}
+
+func richEntries(req api.Entry, entries func(*rpm.Dependency) []api.Entry) []api.Entry {
+	dep, err := rpm.ParseDependency(req)
+	if err != nil {
+		logrus.Warnf("Ignoring requirement: %v", err)
+		return nil
+	}
+	return entries(dep)
+}
+
+// provideFilesFromFilelists looks up the file requirements of the discovered
+// packages which no package provides in the filelists of the repositories and
+// registers the packages containing these files as their providers. It
+// reports whether new providers were found.
+func (r *RepoReducer) provideFilesFromFilelists(discovered map[api.PackageKey]*api.Package) (bool, error) {
+	if r.filelists == nil {
+		return false, nil
+	}
+	if r.searchedFiles == nil {
+		r.searchedFiles = map[string]struct{}{}
+	}
+	wanted := map[string]struct{}{}
+	for _, p := range discovered {
+		for _, req := range simpleRequires(p) {
+			if !strings.HasPrefix(req.Name, "/") {
+				continue
+			}
+			if _, exists := r.packageInfo.provides[req.Name]; exists {
+				continue
+			}
+			if _, searched := r.searchedFiles[req.Name]; searched {
+				continue
+			}
+			wanted[req.Name] = struct{}{}
+		}
+	}
+	if len(wanted) == 0 {
+		return false, nil
+	}
+
+	logrus.Infof("Looking up %d file requirements in filelists.", len(wanted))
+	byPkgid := map[string][]*api.Package{}
+	for i, p := range r.packageInfo.packages {
+		if p.Checksum.Text != "" {
+			byPkgid[p.Checksum.Text] = append(byPkgid[p.Checksum.Text], &r.packageInfo.packages[i])
+		}
+	}
+	found := false
+	err := r.filelists.WalkFilelists(r.repos, r.architectures, func(pkg *api.FileListPackage) error {
+		for _, file := range pkg.File {
+			if _, exists := wanted[file.Text]; !exists {
+				continue
+			}
+			for _, p := range byPkgid[pkg.Pkgid] {
+				logrus.Debugf("%s provides %s according to filelists", p.String(), file.Text)
+				p.Format.Files = append(p.Format.Files, file)
+				r.packageInfo.provides[file.Text] = append(r.packageInfo.provides[file.Text], p)
+				found = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	for name := range wanted {
+		r.searchedFiles[name] = struct{}{}
+	}
+	return found, nil
+}
+
+func NewRepoReducer(repos *bazeldnf.Repositories, repoFiles []string, baseSystem string, architectures []string, weakDeps bool, cacheHelper *repo.CacheHelper) *RepoReducer {
 	implicitRequires := make([]string, 0, 1)
 	if baseSystem != "" {
 		implicitRequires = append(implicitRequires, baseSystem)
@@ -157,11 +402,15 @@ func NewRepoReducer(repos *bazeldnf.Repositories, repoFiles []string, baseSystem
 			repos:         repos,
 			cacheHelper:   cacheHelper,
 		},
+		filelists:     cacheHelper,
+		repos:         repos,
+		architectures: architectures,
+		weakDeps:      weakDeps,
 	}
 }
 
-func Resolve(repos *bazeldnf.Repositories, repoFiles []string, baseSystem string, architectures []string, packages []string, ignoreMissing bool) (matched []string, involved []*api.Package, err error) {
-	repoReducer := NewRepoReducer(repos, repoFiles, baseSystem, architectures, repo.NewCacheHelper())
+func Resolve(repos *bazeldnf.Repositories, repoFiles []string, baseSystem string, architectures []string, packages []string, ignoreMissing bool, weakDeps bool) (matched []string, involved []*api.Package, err error) {
+	repoReducer := NewRepoReducer(repos, repoFiles, baseSystem, architectures, weakDeps, repo.NewCacheHelper())
 	logrus.Info("Loading packages.")
 	if err := repoReducer.Load(); err != nil {
 		return nil, nil, err
@@ -194,6 +194,123 @@ func TestReducerRequiresFoundMultipleProvidesInOne(t *testing.T) {
 	g.Expect(involved).Should(ConsistOf(&packages[0], &packages[1], &packages[2]))
 }
 
+func TestReducerRichRequires(t *testing.T) {
+	g := NewGomegaWithT(t)
+	packages := withRepository([]api.Package{
+		newPackageWithDeps("foo", []string{"(bar-libs if baz)", "(qux or perl(Quux))"}, nil),
+		newPackageWithDeps("bar", nil, []string{"bar-libs"}),
+		newPackage("baz"),
+		newPackage("qux"),
+		newPackageWithDeps("quux", nil, []string{"perl(Quux)"}),
+	})
+
+	packageInfo := packageInfo{
+		packages: packages,
+		provides: map[string][]*api.Package{
+			"bar-libs":   []*api.Package{&packages[1]},
+			"baz":        []*api.Package{&packages[2]},
+			"qux":        []*api.Package{&packages[3]},
+			"perl(Quux)": []*api.Package{&packages[4]},
+		},
+	}
+
+	matched, involved, err := resolve(&packageInfo, []string{"foo"}, []string{}, false)
+	g.Expect(err).Should(BeNil())
+	g.Expect(matched).Should(ConsistOf("foo"))
+	// the condition baz is not pulled in, the SAT solver decides about the alternatives
+	g.Expect(involved).Should(ConsistOf(&packages[0], &packages[1], &packages[3], &packages[4]))
+	g.Expect(packages[1].Format.Provides.Entries).Should(ConsistOf(api.Entry{Name: "bar-libs"}))
+	g.Expect(packages[4].Format.Provides.Entries).Should(ConsistOf(api.Entry{Name: "perl(Quux)"}))
+}
+
+func TestReducerWeakDependencies(t *testing.T) {
+	g := NewGomegaWithT(t)
+	packages := withRepository([]api.Package{
+		newPackageWithDeps("foo", nil, []string{"foo-libs"}),
+		newPackage("bar"),
+		newPackage("baz"),
+		newPackage("qux"),
+	})
+	packages[0].Format.Recommends = toDeps("bar")
+	packages[2].Format.Supplements = toDeps("(foo-libs and bar)")
+
+	packageInfo := packageInfo{
+		packages: packages,
+		provides: map[string][]*api.Package{
+			"foo-libs": []*api.Package{&packages[0]},
+			"bar":      []*api.Package{&packages[1]},
+		},
+	}
+
+	repoReducer := &RepoReducer{
+		loader:   &MockPackageLoader{packageInfo: &packageInfo},
+		weakDeps: true,
+	}
+	g.Expect(repoReducer.Load()).To(Succeed())
+	_, involved, err := repoReducer.Resolve([]string{"foo"}, false)
+	g.Expect(err).Should(BeNil())
+	g.Expect(involved).Should(ConsistOf(&packages[0], &packages[1], &packages[2]))
+	g.Expect(packages[0].Format.Provides.Entries).Should(ConsistOf(api.Entry{Name: "foo-libs"}))
+}
+
+func TestReducerObsoletingPackages(t *testing.T) {
+	g := NewGomegaWithT(t)
+	packages := withRepository([]api.Package{
+		newPackage("foo"),
+		newPackage("foo-ng"),
+		newPackage("bar"),
+	})
+	packages[1].Format.Obsoletes = toDeps("foo")
+	packages[2].Format.Obsoletes = toDeps("baz")
+
+	packageInfo := packageInfo{
+		packages: packages,
+		provides: map[string][]*api.Package{},
+	}
+
+	matched, involved, err := resolve(&packageInfo, []string{"foo"}, []string{}, false)
+	g.Expect(err).Should(BeNil())
+	g.Expect(matched).Should(ConsistOf("foo"))
+	// the SAT solver picks the obsoleting package instead
+	g.Expect(involved).Should(ConsistOf(&packages[0], &packages[1]))
+}
+
+func TestReducerObsoletingPackagesAreNotPinned(t *testing.T) {
+	g := NewGomegaWithT(t)
+	packages := withRepository([]api.Package{
+		newPackageWithDeps("foo", []string{"ng-feature"}, nil),
+		newPackageWithDeps("foo-ng", nil, []string{"ng-feature"}),
+		newPackage("foo-ng"),
+		newPackage("foo-ng"),
+		newPackage("foo-ng"),
+	})
+	for i := 1; i < len(packages); i++ {
+		packages[i].Format.Obsoletes = toDeps("foo")
+	}
+	packages[2].Version = api.Version{Epoch: "1"}
+	packages[2].Repository.Priority = 2
+	packages[3].Version = api.Version{Epoch: "1"}
+	packages[3].Repository.Priority = 1
+	packages[4].Version = api.Version{Epoch: "1"}
+	packages[4].Repository.Priority = 3
+
+	packageInfo := packageInfo{
+		packages: packages,
+		provides: map[string][]*api.Package{
+			"ng-feature": {&packages[1]},
+		},
+	}
+
+	for i := 0; i < 10; i++ {
+		matched, involved, err := resolve(&packageInfo, []string{"foo"}, []string{}, false)
+		g.Expect(err).Should(BeNil())
+		g.Expect(matched).Should(ConsistOf("foo"))
+		// the newest obsoleting package from the preferred repository is
+		// added, without excluding other versions required by foo
+		g.Expect(involved).Should(ConsistOf(&packages[0], &packages[1], &packages[3]))
+	}
+}
+
 func TestReducerMultiLevelRequires(t *testing.T) {
 	g := NewGomegaWithT(t)
 	packages := withRepository([]api.Package{
@@ -338,3 +455,55 @@ func TestSpecifyArch(t *testing.T) {
 	g.Expect(matched).Should(ConsistOf("foo", "bar"))
 	g.Expect(involved).Should(ConsistOf(&packages[0], &packages[3]))
 }
+
+type MockFilelistsCache struct {
+	filelists []*api.FileListPackage
+	walks     int
+}
+
+func (m *MockFilelistsCache) WalkFilelists(_ *bazeldnf.Repositories, _ []string, fn func(pkg *api.FileListPackage) error) error {
+	m.walks++
+	for _, pkg := range m.filelists {
+		if err := fn(pkg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestReducerFileRequiresFromFilelists(t *testing.T) {
+	g := NewGomegaWithT(t)
+	packages := withRepository([]api.Package{
+		newPackageWithDeps("foo", []string{"/usr/bin/bar"}, nil),
+		newPackageWithDeps("bar", []string{"/usr/bin/baz", "/usr/bin/missing"}, nil),
+		newPackageWithDeps("baz", []string{}, nil),
+		newPackageWithDeps("unrelated", []string{}, nil),
+	})
+	for i := range packages {
+		packages[i].Checksum = api.Checksum{Text: packages[i].Name + "-pkgid", Type: "sha256"}
+	}
+	filelists := &MockFilelistsCache{
+		filelists: []*api.FileListPackage{
+			{Pkgid: "bar-pkgid", Name: "bar", File: []api.ProvidedFile{{Text: "/usr/bin/bar"}}},
+			{Pkgid: "baz-pkgid", Name: "baz", File: []api.ProvidedFile{{Text: "/usr/bin/baz"}}},
+			{Pkgid: "unrelated-pkgid", Name: "unrelated", File: []api.ProvidedFile{{Text: "/usr/bin/unrelated"}}},
+		},
+	}
+	repoReducer := &RepoReducer{
+		loader: &MockPackageLoader{packageInfo: &packageInfo{
+			packages: packages,
+			provides: map[string][]*api.Package{},
+		}},
+		filelists: filelists,
+	}
+	g.Expect(repoReducer.Load()).To(Succeed())
+
+	matched, involved, err := repoReducer.Resolve([]string{"foo"}, false)
+	g.Expect(err).Should(BeNil())
+	g.Expect(matched).Should(ConsistOf("foo"))
+	g.Expect(involved).Should(ConsistOf(&packages[0], &packages[1], &packages[2]))
+	g.Expect(packages[1].Format.Files).Should(ConsistOf(api.ProvidedFile{Text: "/usr/bin/bar"}))
+	g.Expect(packages[3].Format.Files).Should(BeEmpty())
+	// the missing file is only looked up once
+	g.Expect(filelists.walks).Should(Equal(2))
+}
@@ -1,10 +1,18 @@
 package repo
 
 import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"slices"
@@ -63,8 +71,12 @@ func NewCacheHelper(cacheDir ...string) *CacheHelper {
 	}
 }
 
+func defaultCacheDir() string {
+	return xdg.CacheHome + "/bazeldnf"
+}
+
 func AddCacheHelperFlags(cmd *cobra.Command) {
-	cmd.Flags().StringVarP(&cacheHelperValues.cacheDir, "cache-dir", "c", xdg.CacheHome+"/bazeldnf", "Cache directory")
+	cmd.Flags().StringVarP(&cacheHelperValues.cacheDir, "cache-dir", "c", defaultCacheDir(), "Cache directory")
 }
 
 func (r *CacheHelper) LoadMetaLink(repo *bazeldnf.Repository) (*api.Metalink, error) {
@@ -75,38 +87,246 @@ func (r *CacheHelper) LoadMetaLink(repo *bazeldnf.Repository) (*api.Metalink, er
 	return metalink, nil
 }
 
+// HTTPValidators hold the response headers of a cached download which allow
+// sending conditional requests for it
+type HTTPValidators struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+func validatorsName(name string) string {
+	return name + ".validators.json"
+}
+
+// LoadValidators returns the validators of the cached file name, or nil if
+// either the file or its validators are not present.
+func (r *CacheHelper) LoadValidators(repo *bazeldnf.Repository, name string) *HTTPValidators {
+	if _, err := os.Stat(filepath.Join(r.repoDir(repo), name)); err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(r.repoDir(repo), validatorsName(name)))
+	if err != nil {
+		return nil
+	}
+	validators := &HTTPValidators{}
+	if err := json.Unmarshal(data, validators); err != nil {
+		logrus.Warnf("Ignoring invalid cache validators for %s of %s: %v", name, repo.Name, err)
+		return nil
+	}
+	return validators
+}
+
+func (r *CacheHelper) WriteValidators(repo *bazeldnf.Repository, name string, validators *HTTPValidators) error {
+	data, err := json.Marshal(validators)
+	if err != nil {
+		return err
+	}
+	return r.WriteToRepoDir(repo, bytes.NewReader(data), validatorsName(name))
+}
+
+func (r *CacheHelper) RemoveValidators(repo *bazeldnf.Repository, name string) error {
+	err := os.Remove(filepath.Join(r.repoDir(repo), validatorsName(name)))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove cache validators for %s of %s: %v", name, repo.Name, err)
+	}
+	return nil
+}
+
+// repoIdentityName is the file in the cache directory of a repository which
+// records the name and the sources of the repository
+const repoIdentityName = "repo.json"
+
+// RepoKey identifies the cache directory of a repository. It is derived from
+// the URLs and the architecture of the repository, but not from its name, so
+// that repositories which share a name but point to different releases or
+// architectures never mix up their metadata.
+func RepoKey(repo *bazeldnf.Repository) string {
+	sources := []string{repo.Metalink, repo.Mirrorlist, repo.Baseurl}
+	if repo.Metalink == "" && repo.Mirrorlist == "" && repo.Baseurl == "" {
+		// mirrors are only resolved from the other URLs, so that explicitly
+		// configured mirrors are the only source left
+		sources = append(sources, repo.Mirrors...)
+	}
+	hash := sha256.New()
+	for _, source := range append(sources, repo.Arch) {
+		fmt.Fprintf(hash, "%s\x00", source)
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+func (r *CacheHelper) repoDir(repo *bazeldnf.Repository) string {
+	return filepath.Join(r.cacheDir, RepoKey(repo))
+}
+
+// writeRepoIdentity records the name and the sources of the repository in its
+// cache directory, so that it can be displayed when inspecting the cache
+func (r *CacheHelper) writeRepoIdentity(repo *bazeldnf.Repository) error {
+	identity := &bazeldnf.Repository{
+		Name:       repo.Name,
+		Metalink:   repo.Metalink,
+		Mirrorlist: repo.Mirrorlist,
+		Baseurl:    repo.Baseurl,
+		Arch:       repo.Arch,
+	}
+	if repo.Metalink == "" && repo.Mirrorlist == "" && repo.Baseurl == "" {
+		identity.Mirrors = repo.Mirrors
+	}
+	data, err := json.Marshal(identity)
+	if err != nil {
+		return err
+	}
+	if existing, err := os.ReadFile(filepath.Join(r.repoDir(repo), repoIdentityName)); err == nil && bytes.Equal(existing, data) {
+		return nil
+	}
+	return r.WriteToRepoDir(repo, bytes.NewReader(data), repoIdentityName)
+}
+
+// LockRepo grants exclusive access to the cache directory of the repository,
+// also towards other bazeldnf processes sharing the cache. It is meant to be
+// held while the metadata of the repository gets updated. The returned
+// function releases the lock again.
+func (r *CacheHelper) LockRepo(repo *bazeldnf.Repository) (func(), error) {
+	unlock, err := r.lockDir(r.repoDir(repo), repo.Name, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.writeRepoIdentity(repo); err != nil {
+		unlock()
+		return nil, fmt.Errorf("failed to record the identity of %s: %v", repo.Name, err)
+	}
+	return unlock, nil
+}
+
+// RLockRepo grants shared access to the cache directory of the repository.
+// It is meant to be held while cached metadata is read, so that it can't be
+// updated in between. The returned function releases the lock again.
+func (r *CacheHelper) RLockRepo(repo *bazeldnf.Repository) (func(), error) {
+	return r.lockDir(r.repoDir(repo), repo.Name, false)
+}
+
+func (r *CacheHelper) lockDir(dir string, name string, exclusive bool) (func(), error) {
+	file := filepath.Join(dir, ".lock")
+	for {
+		if err := os.MkdirAll(dir, 0770); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory for %s: %v", name, err)
+		}
+		f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0660)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open lock file %s: %v", file, err)
+		}
+		if err := lockFile(f, exclusive); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %v", file, err)
+		}
+		// the directory may have been removed while waiting for the lock,
+		// then the lock protects nothing and has to be taken again
+		locked, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to inspect lock file %s: %v", file, err)
+		}
+		current, err := os.Stat(file)
+		if err == nil && os.SameFile(locked, current) {
+			return func() { f.Close() }, nil
+		}
+		f.Close()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to inspect lock file %s: %v", file, err)
+		}
+	}
+}
+
+// LoadMirrorlist returns the base URLs listed in the cached plain text
+// mirrorlist of the repository, ignoring comments and empty lines.
+func (r *CacheHelper) LoadMirrorlist(repo *bazeldnf.Repository) ([]string, error) {
+	reader, err := r.OpenFromRepoDir(repo, "mirrorlist")
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	mirrors := []string{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		mirrors = append(mirrors, line)
+	}
+	return mirrors, scanner.Err()
+}
+
+func (r *CacheHelper) LoadRepomd(repo *bazeldnf.Repository) (*api.Repomd, error) {
+	repomd := &api.Repomd{}
+	if err := r.UnmarshalFromRepoDir(repo, "repomd.xml", repomd); err != nil {
+		return nil, err
+	}
+	return repomd, nil
+}
+
+// WriteToRepoDir stores the content of body in the cache directory of the
+// repository. The content is written to a temporary file first which then
+// replaces the target file, so that readers never see partially written files.
 func (r *CacheHelper) WriteToRepoDir(repo *bazeldnf.Repository, body io.Reader, name string) error {
-	dir := filepath.Join(r.cacheDir, repo.Name)
+	dir := r.repoDir(repo)
 	file := filepath.Join(dir, name)
 
 	err := os.MkdirAll(dir, 0770)
 	if err != nil && !os.IsExist(err) {
 		return fmt.Errorf("failed to create cache directory for %s: %v", repo.Name, err)
 	}
-	f, err := os.Create(file)
+	f, err := os.CreateTemp(dir, "."+name+".tmp-*")
 	if err != nil {
-		return fmt.Errorf("failed to open file %s: %v", file, err)
+		return fmt.Errorf("failed to open temporary file for %s: %v", file, err)
 	}
-	defer f.Close()
-	_, err = io.Copy(f, body)
-	if err != nil {
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write file %s: %v", file, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write file %s: %v", file, err)
+	}
+	if err := os.Chmod(f.Name(), 0660); err != nil {
 		return fmt.Errorf("failed to write file %s: %v", file, err)
 	}
+	if err := os.Rename(f.Name(), file); err != nil {
+		return fmt.Errorf("failed to replace file %s: %v", file, err)
+	}
+	return nil
+}
+
+// RemoveFromRepoDir removes the file name from the cache directory of the
+// repository. A file which doesn't exist is not an error.
+func (r *CacheHelper) RemoveFromRepoDir(repo *bazeldnf.Repository, name string) error {
+	err := os.Remove(filepath.Join(r.repoDir(repo), name))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s of %s: %v", name, repo.Name, err)
+	}
 	return nil
 }
 
 func (r *CacheHelper) OpenFromRepoDir(repo *bazeldnf.Repository, name string) (io.ReadCloser, error) {
-	dir := filepath.Join(r.cacheDir, repo.Name)
+	return r.openFromDir(r.repoDir(repo), name)
+}
+
+func (r *CacheHelper) openFromDir(dir string, name string) (io.ReadCloser, error) {
 	file := filepath.Join(dir, name)
 	f, err := os.Open(file)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file %s: %v", file, err)
+		return nil, fmt.Errorf("failed to open file %s: %w", file, err)
 	}
 	return f, err
 }
 
 func (r *CacheHelper) UnmarshalFromRepoDir(repo *bazeldnf.Repository, name string, obj interface{}) error {
-	reader, err := r.OpenFromRepoDir(repo, name)
+	return r.unmarshalFromDir(r.repoDir(repo), name, obj)
+}
+
+func (r *CacheHelper) unmarshalFromDir(dir string, name string, obj interface{}) error {
+	reader, err := r.openFromDir(dir, name)
 	if err != nil {
 		return err
 	}
@@ -114,6 +334,30 @@ func (r *CacheHelper) UnmarshalFromRepoDir(repo *bazeldnf.Repository, name strin
 	return xml.NewDecoder(reader).Decode(obj)
 }
 
+// compressedMetadataSuffixes lists the compression formats which
+// getCompressFileReader can read
+var compressedMetadataSuffixes = []string{".gz", ".zst", ".xz", ".bz2", ".zck"}
+
+// metadataFile returns the metadata file of the given type which is
+// referenced in repomd.xml. If the compression of that file is not supported,
+// its zchunk variant is used instead, if the repository provides one. The
+// sqlite databases are only used for repositories which provide no xml.
+func metadataFile(repomd *api.Repomd, fileType string) *api.Data {
+	file := repomd.File(fileType)
+	if file != nil && slices.ContainsFunc(compressedMetadataSuffixes, func(suffix string) bool {
+		return strings.HasSuffix(file.Location.Href, suffix)
+	}) {
+		return file
+	}
+	if zck := repomd.File(fileType + "_zck"); zck != nil {
+		return zck
+	}
+	if file == nil {
+		return repomd.File(fileType + "_db")
+	}
+	return file
+}
+
 func (r *CacheHelper) getCompressFileReader(filename string, stream io.Reader) (io.ReadCloser, error) {
 	if strings.HasSuffix(filename, ".gz") {
 		return gzip.NewReader(stream)
@@ -135,34 +379,70 @@ func (r *CacheHelper) getCompressFileReader(filename string, stream io.Reader) (
 		return &XzReadCloser{reader: rc}, nil
 	}
 
+	if strings.HasSuffix(filename, ".bz2") {
+		return io.NopCloser(bzip2.NewReader(stream)), nil
+	}
+
+	if strings.HasSuffix(filename, ".zck") {
+		return newZchunkReader(stream)
+	}
+
 	return nil, fmt.Errorf("file format not supported: %s", filepath.Ext(filename))
 }
 
+// CurrentPrimary loads all packages of the cached primary metadata of the
+// repository. Prefer WalkPrimary where the packages can be processed one by
+// one.
 func (r *CacheHelper) CurrentPrimary(repo *bazeldnf.Repository) (*api.Repository, error) {
-	repomd := &api.Repomd{}
-	if err := r.UnmarshalFromRepoDir(repo, "repomd.xml", repomd); err != nil {
-		return nil, err
-	}
-	primary := repomd.File(api.PrimaryFileType)
-	primaryName := filepath.Base(primary.Location.Href)
-	file, err := r.OpenFromRepoDir(repo, primaryName)
+	repository := &api.Repository{}
+	err := r.WalkPrimary(repo, func(pkg *api.Package) error {
+		repository.Packages = append(repository.Packages, *pkg)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	return repository, nil
+}
 
-	rc, err := r.getCompressFileReader(primaryName, file)
+// WalkPrimary calls fn for every package in the cached primary metadata of the
+// repository. Packages are read one at a time from the primary index or, if
+// there is no up to date index, from primary.xml, so that the metadata never
+// has to be held in memory as a whole. The package passed to fn is not reused.
+func (r *CacheHelper) WalkPrimary(repo *bazeldnf.Repository, fn func(pkg *api.Package) error) error {
+	unlock, err := r.RLockRepo(repo)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rc.Close()
+	defer unlock()
 
-	repository := &api.Repository{}
-	err = xml.NewDecoder(rc).Decode(repository)
-	if err != nil {
-		return nil, err
+	repomd := &api.Repomd{}
+	if err := r.UnmarshalFromRepoDir(repo, "repomd.xml", repomd); err != nil {
+		return err
+	}
+	primary := metadataFile(repomd, api.PrimaryFileType)
+	if primary == nil {
+		return fmt.Errorf("no primary file referenced in repomd.xml of %s", repo.Name)
+	}
+	if err := r.resolveMirrors(repo); err != nil {
+		return err
+	}
+
+	withRepository := func(pkg *api.Package) error {
+		pkg.Repository = repo
+		return fn(pkg)
+	}
+	err = r.walkPrimaryIndex(repo, primary, withRepository)
+	if err == errNoPrimaryIndex {
+		logrus.Debugf("No up to date primary index for %s, loading primary.xml. Run fetch to create it.", repo.Name)
+		err = r.walkPrimaryFile(repo, primary, withRepository)
 	}
+	return err
+}
 
+// resolveMirrors sets the mirrors of the repository from the cached metalink
+// or mirrorlist, or from its baseurl, unless they are configured explicitly.
+func (r *CacheHelper) resolveMirrors(repo *bazeldnf.Repository) error {
 	if len(repo.Mirrors) == 0 && repo.Metalink != "" {
 		metalink, err := r.LoadMetaLink(repo)
 		if err == nil {
@@ -177,34 +457,219 @@ func (r *CacheHelper) CurrentPrimary(repo *bazeldnf.Repository) (*api.Repository
 			}
 			repo.Mirrors = urls
 		} else if !os.IsNotExist(err) {
-			return nil, err
+			return err
+		}
+	} else if len(repo.Mirrors) == 0 && repo.Mirrorlist != "" {
+		mirrors, err := r.LoadMirrorlist(repo)
+		if err == nil {
+			urls := []string{}
+			for _, mirror := range mirrors {
+				urls = append(urls, strings.TrimSuffix(mirror, "/")+"/")
+				if len(urls) == 4 {
+					break
+				}
+			}
+			repo.Mirrors = urls
+		} else if !os.IsNotExist(err) {
+			return err
 		}
 	} else if len(repo.Mirrors) == 0 && repo.Baseurl != "" {
 		repo.Mirrors = []string{repo.Baseurl}
 	}
+	return nil
+}
+
+// MirrorURLs returns all base URLs which the packages of the repository can
+// be downloaded from: its baseurl and mirrors, as well as every mirror of its
+// cached metalink or mirrorlist. Unlike resolveMirrors it does not stop after a
+// few https mirrors, so that it also covers rpm rules written while the
+// metalink or mirrorlist listed other mirrors first.
+func (r *CacheHelper) MirrorURLs(repo *bazeldnf.Repository) ([]string, error) {
+	urls := append([]string{}, repo.Mirrors...)
+	if repo.Baseurl != "" {
+		urls = append(urls, repo.Baseurl)
+	}
+	if repo.Metalink != "" {
+		metalink, err := r.LoadMetaLink(repo)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		} else if err == nil && metalink.Repomod() != nil {
+			for _, url := range metalink.Repomod().Resources.URLs {
+				urls = append(urls, strings.TrimSuffix(url.Text, "repodata/repomd.xml"))
+			}
+		}
+	}
+	if repo.Mirrorlist != "" {
+		mirrors, err := r.LoadMirrorlist(repo)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		urls = append(urls, mirrors...)
+	}
+	return urls, nil
+}
 
-	for i, _ := range repository.Packages {
-		repository.Packages[i].Repository = repo
+// walkPrimaryFile reads the packages of the cached primary metadata of the
+// repository, which is either primary.xml or a primary_db
+func (r *CacheHelper) walkPrimaryFile(repo *bazeldnf.Repository, primary *api.Data, fn func(pkg *api.Package) error) error {
+	if isSqliteMetadata(primary) {
+		return r.walkPrimaryDB(repo, primary, fn)
+	}
+	return r.walkPrimaryXML(repo, primary, fn)
+}
+
+// walkPrimaryXML streams the packages of the cached primary.xml of the repository
+func (r *CacheHelper) walkPrimaryXML(repo *bazeldnf.Repository, primary *api.Data, fn func(pkg *api.Package) error) error {
+	primaryName := filepath.Base(primary.Location.Href)
+	file, err := r.OpenFromRepoDir(repo, primaryName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rc, err := r.getCompressFileReader(primaryName, file)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return WalkPrimaryXML(rc, fn)
+}
+
+// WalkPrimaryXML decodes the <package> elements of primary.xml one by one and
+// calls fn for each of them.
+func WalkPrimaryXML(reader io.Reader, fn func(pkg *api.Package) error) error {
+	decoder := xml.NewDecoder(reader)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "package" {
+			continue
+		}
+		pkg := &api.Package{}
+		if err := decoder.DecodeElement(pkg, &start); err != nil {
+			return err
+		}
+		if err := fn(pkg); err != nil {
+			return err
+		}
 	}
-	return repository, nil
+}
+
+// WalkFilelist calls fn for every package in the cached filelists.xml of the
+// repository, reading one package at a time. Repositories without cached
+// filelists are skipped, since filelists are only fetched on request.
+func (r *CacheHelper) WalkFilelist(repo *bazeldnf.Repository, fn func(pkg *api.FileListPackage) error) error {
+	unlock, err := r.RLockRepo(repo)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	repomd := &api.Repomd{}
+	if err := r.UnmarshalFromRepoDir(repo, "repomd.xml", repomd); err != nil {
+		return err
+	}
+	filelists := metadataFile(repomd, api.FilelistsFileType)
+	if filelists == nil {
+		logrus.Debugf("Repository %s provides no filelists", repo.Name)
+		return nil
+	}
+	filelistsName := filepath.Base(filelists.Location.Href)
+	file, err := os.Open(filepath.Join(r.repoDir(repo), filelistsName))
+	if os.IsNotExist(err) {
+		logrus.Debugf("No filelists cached for %s, run fetch with --filelists to use them", repo.Name)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	if isSqliteMetadata(filelists) {
+		return r.walkFilelistsDB(repo, filelistsName, file, fn)
+	}
+
+	reader, err := r.getCompressFileReader(filelistsName, file)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	decoder := xml.NewDecoder(reader)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read filelists of %s: %v", repo.Name, err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "package" {
+			continue
+		}
+		pkg := &api.FileListPackage{}
+		if err := decoder.DecodeElement(pkg, &start); err != nil {
+			return fmt.Errorf("failed to read filelists of %s: %v", repo.Name, err)
+		}
+		if err := fn(pkg); err != nil {
+			return err
+		}
+	}
+}
+
+// WalkFilelists calls fn for every package in the cached filelists of all
+// enabled repositories matching the architectures, see WalkFilelist.
+func (r *CacheHelper) WalkFilelists(repos *bazeldnf.Repositories, architectures []string, fn func(pkg *api.FileListPackage) error) error {
+	for i, repo := range repos.Repositories {
+		if repo.Disabled || repo.Arch != "" && !slices.Contains(architectures, repo.Arch) {
+			continue
+		}
+		if err := r.WalkFilelist(&repos.Repositories[i], fn); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (r *CacheHelper) CurrentFilelistsForPackages(repo *bazeldnf.Repository, arches []string, packages []*api.Package) (filelistpkgs []*api.FileListPackage, remaining []*api.Package, err error) {
+	unlock, err := r.RLockRepo(repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
 	repomd := &api.Repomd{}
 
 	if err := r.UnmarshalFromRepoDir(repo, "repomd.xml", repomd); err != nil {
 		return nil, nil, err
 	}
 
-	filelists := repomd.File(api.FilelistsFileType)
+	filelists := metadataFile(repomd, api.FilelistsFileType)
+	if filelists == nil {
+		return nil, nil, fmt.Errorf("no filelists file referenced in repomd.xml of %s", repo.Name)
+	}
 	filelistsName := filepath.Base(filelists.Location.Href)
 
-	file, err := r.OpenFromRepoDir(repo, filelistsName)
+	file, err := os.Open(filepath.Join(r.repoDir(repo), filelistsName))
 	if err != nil {
 		return nil, nil, err
 	}
 	defer file.Close()
 
+	sort.SliceStable(packages, func(i, j int) bool {
+		if packages[i].Name == packages[j].Name {
+			return rpm.Compare(packages[i].Version, packages[j].Version) < 0
+		}
+		return packages[i].Name < packages[j].Name
+	})
+	if isSqliteMetadata(filelists) {
+		return r.filelistsDBForPackages(repo, filelistsName, file, arches, packages)
+	}
+
 	reader, err := r.getCompressFileReader(filelists.Location.Href, file)
 	if err != nil {
 		return nil, nil, err
@@ -214,13 +679,6 @@ func (r *CacheHelper) CurrentFilelistsForPackages(repo *bazeldnf.Repository, arc
 	d := xml.NewDecoder(reader)
 	pkgIndex := 0
 
-	sort.SliceStable(packages, func(i, j int) bool {
-		if packages[i].Name == packages[j].Name {
-			return rpm.Compare(packages[i].Version, packages[j].Version) < 0
-		}
-		return packages[i].Name < packages[j].Name
-	})
-
 	for {
 		if len(packages) == pkgIndex {
 			break
@@ -285,17 +743,30 @@ func (r *CacheHelper) CurrentFilelistsForPackages(repo *bazeldnf.Repository, arc
 	return filelistpkgs, remaining, nil
 }
 
-func (r *CacheHelper) CurrentPrimaries(repos *bazeldnf.Repositories, architectures []string) (primaries []*api.Repository, err error) {
+// WalkPrimaries calls fn for every package of all enabled repositories
+// matching the architectures, see WalkPrimary. If the metadata of any of these
+// repositories is not cached, a MissingMetadataError reports all of them
+// before any package is read.
+func (r *CacheHelper) WalkPrimaries(repos *bazeldnf.Repositories, architectures []string, fn func(pkg *api.Package) error) error {
+	selected := []*bazeldnf.Repository{}
 	for i, repo := range repos.Repositories {
+		if repo.Disabled {
+			logrus.Infof("Ignoring primary for disabled repository %s", repo.Name)
+			continue
+		}
 		if repo.Arch != "" && !slices.Contains(architectures, repo.Arch) {
 			logrus.Infof("Ignoring primary for %s - %s", repo.Name, repo.Arch)
 			continue
 		}
-		primary, err := r.CurrentPrimary(&repos.Repositories[i])
-		if err != nil {
-			return nil, err
+		selected = append(selected, &repos.Repositories[i])
+	}
+	if err := r.CheckMetadata(selected, false); err != nil {
+		return err
+	}
+	for _, repo := range selected {
+		if err := r.WalkPrimary(repo, fn); err != nil {
+			return err
 		}
-		primaries = append(primaries, primary)
 	}
-	return primaries, err
+	return nil
 }
@@ -0,0 +1,171 @@
+package repo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+func TestWriteToRepoDir(t *testing.T) {
+	helper := &CacheHelper{cacheDir: t.TempDir()}
+	repo := &bazeldnf.Repository{Name: "test"}
+
+	for _, content := range []string{"first", "second"} {
+		if err := helper.WriteToRepoDir(repo, strings.NewReader(content), "repomd.xml"); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		data, err := os.ReadFile(filepath.Join(helper.repoDir(repo), "repomd.xml"))
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		if string(data) != content {
+			t.Fatalf("expected %q, got %q", content, string(data))
+		}
+	}
+
+	entries, err := os.ReadDir(helper.repoDir(repo))
+	if err != nil {
+		t.Fatalf("failed to read cache dir: %v", err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp-") {
+			t.Fatalf("expected no leftover temporary files, found %s", entry.Name())
+		}
+	}
+}
+
+func TestLockRepo(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("cache locking is only supported on unix")
+	}
+	cacheDir := t.TempDir()
+	// separate helpers to mimic separate processes sharing the cache
+	writer := &CacheHelper{cacheDir: cacheDir}
+	reader := &CacheHelper{cacheDir: cacheDir}
+	repo := &bazeldnf.Repository{Name: "test"}
+
+	unlock, err := writer.LockRepo(repo)
+	if err != nil {
+		t.Fatalf("failed to lock repo: %v", err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		runlock, err := reader.RLockRepo(repo)
+		if err != nil {
+			t.Errorf("failed to lock repo for reading: %v", err)
+			close(acquired)
+			return
+		}
+		acquired <- runlock
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatalf("expected the read lock to wait for the write lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case runlock, ok := <-acquired:
+		if ok {
+			runlock()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the read lock to be granted after the write lock was released")
+	}
+}
+
+func TestWalkPrimaryXML(t *testing.T) {
+	names := []string{}
+	err := WalkPrimaryXML(strings.NewReader(testIndexPrimary), func(pkg *api.Package) error {
+		names = append(names, pkg.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walking primary.xml failed: %v", err)
+	}
+	if !reflect.DeepEqual(names, []string{"foo", "bar"}) {
+		t.Fatalf("expected packages foo and bar, got %v", names)
+	}
+
+	stop := fmt.Errorf("stop")
+	visited := 0
+	err = WalkPrimaryXML(strings.NewReader(testIndexPrimary), func(pkg *api.Package) error {
+		visited++
+		return stop
+	})
+	if err != stop || visited != 1 {
+		t.Fatalf("expected the walk to stop after the first package with an error, got %v after %d packages", err, visited)
+	}
+
+	err = WalkPrimaryXML(strings.NewReader("<metadata><package><name>foo</name>"), func(pkg *api.Package) error {
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("expected an error for truncated primary.xml")
+	}
+}
+
+func TestWalkFilelist(t *testing.T) {
+	helper := &CacheHelper{cacheDir: t.TempDir()}
+	repo := &bazeldnf.Repository{Name: "test"}
+	repomd := `<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <revision>1</revision>
+  <data type="filelists">
+    <checksum type="sha256">1111</checksum>
+    <location href="repodata/filelists.xml.gz"/>
+  </data>
+</repomd>`
+	if err := helper.WriteToRepoDir(repo, strings.NewReader(repomd), "repomd.xml"); err != nil {
+		t.Fatalf("writing repomd.xml: %v", err)
+	}
+
+	walked := 0
+	count := func(pkg *api.FileListPackage) error {
+		walked++
+		return nil
+	}
+	if err := helper.WalkFilelist(repo, count); err != nil || walked != 0 {
+		t.Fatalf("expected repositories without cached filelists to be skipped, got %v after %d packages", err, walked)
+	}
+
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	w.Write([]byte(`<filelists xmlns="http://linux.duke.edu/metadata/filelists" packages="2">
+<package pkgid="abcd" name="foo" arch="x86_64">
+  <version epoch="0" ver="1.0" rel="1"/>
+  <file>/usr/bin/foo</file>
+  <file type="dir">/usr/share/foo</file>
+</package>
+<package pkgid="efgh" name="bar" arch="noarch">
+  <version epoch="0" ver="1.0" rel="1"/>
+</package>
+</filelists>`))
+	w.Close()
+	if err := helper.WriteToRepoDir(repo, buf, "filelists.xml.gz"); err != nil {
+		t.Fatalf("writing filelists.xml: %v", err)
+	}
+	packages := []*api.FileListPackage{}
+	err := helper.WalkFilelist(repo, func(pkg *api.FileListPackage) error {
+		packages = append(packages, pkg)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walking filelists failed: %v", err)
+	}
+	if len(packages) != 2 || packages[0].Pkgid != "abcd" || len(packages[0].File) != 2 || packages[0].File[0].Text != "/usr/bin/foo" {
+		t.Fatalf("unexpected filelists packages: %v", packages)
+	}
+}
@@ -0,0 +1,219 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+// CachedRepository describes the content of the cache directory of a single
+// repository
+type CachedRepository struct {
+	// Key is the name of the cache directory, see RepoKey
+	Key string
+	// Name is the name of the repository, it is empty if the cache directory
+	// does not record it
+	Name string
+	// Arch is the architecture of the repository, if recorded
+	Arch string
+	Dir  string
+	// Size is the size of all files in the cache directory in bytes
+	Size int64
+	// Revision is the revision announced in the cached repomd.xml
+	Revision string
+	// Fetched is the time at which repomd.xml was last written, it is zero if
+	// the repository was never fetched
+	Fetched time.Time
+	// Unreferenced lists the files which are not referenced by the cached
+	// repomd.xml anymore and can be removed
+	Unreferenced     []string
+	UnreferencedSize int64
+	// Orphaned is set if the cache directory does not record its repository.
+	// Such directories were either written by older bazeldnf versions, which
+	// named them after the repository, or were never set up completely. They
+	// are not used anymore, so that all their files are unreferenced.
+	Orphaned bool
+}
+
+// cacheFiles are files in the cache directory of a repository which are kept
+// independent of their mention in repomd.xml
+var cacheFiles = []string{".lock", repoIdentityName, "repomd.xml", "repomd.xml.asc", "metalink", "mirrorlist", primaryIndexName}
+
+// CachedRepositories inspects the cache directories of all repositories found
+// in the cache directory
+func (r *CacheHelper) CachedRepositories() ([]*CachedRepository, error) {
+	entries, err := os.ReadDir(r.cacheDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory %s: %v", r.cacheDir, err)
+	}
+	cached := []*CachedRepository{}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), removedDirPrefix) {
+			continue
+		}
+		dir := filepath.Join(r.cacheDir, entry.Name())
+		unlock, err := r.lockDir(dir, entry.Name(), false)
+		if err != nil {
+			return nil, err
+		}
+		info, err := r.inspectDir(entry.Name())
+		unlock()
+		if err != nil {
+			return nil, err
+		}
+		cached = append(cached, info)
+	}
+	return cached, nil
+}
+
+// inspectDir determines name, size, age and unreferenced files of the cache
+// directory with the given key. The caller has to hold the lock of the
+// directory.
+func (r *CacheHelper) inspectDir(key string) (*CachedRepository, error) {
+	info := &CachedRepository{Key: key, Dir: filepath.Join(r.cacheDir, key)}
+	entries, err := os.ReadDir(info.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory %s: %v", info.Dir, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(info.Dir, repoIdentityName)); err == nil {
+		identity := &bazeldnf.Repository{}
+		if err := json.Unmarshal(data, identity); err != nil {
+			return nil, fmt.Errorf("invalid %s in %s: %v", repoIdentityName, info.Dir, err)
+		}
+		info.Name = identity.Name
+		info.Arch = identity.Arch
+	} else if os.IsNotExist(err) {
+		info.Orphaned = true
+	} else {
+		return nil, fmt.Errorf("failed to read %s in %s: %v", repoIdentityName, info.Dir, err)
+	}
+
+	referenced := map[string]bool{}
+	for _, name := range cacheFiles {
+		referenced[name] = true
+	}
+	if stat, err := os.Stat(filepath.Join(info.Dir, "repomd.xml")); err == nil {
+		info.Fetched = stat.ModTime()
+		repomd := &api.Repomd{}
+		if err := r.unmarshalFromDir(info.Dir, "repomd.xml", repomd); err != nil {
+			return nil, err
+		}
+		info.Revision = repomd.Revision
+		for _, data := range repomd.Data {
+			referenced[filepath.Base(data.Location.Href)] = true
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		stat, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect cache directory %s: %v", info.Dir, err)
+		}
+		info.Size += stat.Size()
+		name := entry.Name()
+		if !info.Orphaned && (referenced[name] || referenced[strings.TrimSuffix(name, validatorsName(""))]) {
+			continue
+		}
+		// without repomd.xml there is nothing to tell which metadata is
+		// still needed, so that only leftover temporary files are removed
+		if !info.Orphaned && info.Fetched.IsZero() && !strings.Contains(name, ".tmp-") {
+			continue
+		}
+		info.Unreferenced = append(info.Unreferenced, name)
+		info.UnreferencedSize += stat.Size()
+	}
+	sort.Strings(info.Unreferenced)
+	return info, nil
+}
+
+// GarbageCollect removes all files from the cache directory with the given
+// key which are not referenced by its current repomd.xml anymore. Orphaned
+// cache directories are removed completely. It returns the state of the cache
+// directory before the files were removed.
+func (r *CacheHelper) GarbageCollect(key string) (*CachedRepository, error) {
+	dir := filepath.Join(r.cacheDir, key)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, fmt.Errorf("cache directory %s does not exist", dir)
+	}
+	unlock, err := r.lockDir(dir, key, true)
+	if err != nil {
+		return nil, err
+	}
+	info, err := r.inspectDir(key)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	if info.Orphaned {
+		removed, err := r.moveAside(key)
+		unlock()
+		if err != nil {
+			return nil, err
+		}
+		return info, r.removeMovedDir(removed)
+	}
+	defer unlock()
+	for _, name := range info.Unreferenced {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove %s from %s: %v", name, dir, err)
+		}
+	}
+	return info, nil
+}
+
+// RemoveCachedRepo deletes the whole cache directory with the given key
+func (r *CacheHelper) RemoveCachedRepo(key string) error {
+	dir := filepath.Join(r.cacheDir, key)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return fmt.Errorf("cache directory %s does not exist", dir)
+	}
+	unlock, err := r.lockDir(dir, key, true)
+	if err != nil {
+		return err
+	}
+	removed, err := r.moveAside(key)
+	unlock()
+	if err != nil {
+		return err
+	}
+	return r.removeMovedDir(removed)
+}
+
+// removedDirPrefix starts the names of the directories in the cache directory
+// which hold cache directories of repositories until they are deleted
+const removedDirPrefix = ".removed-"
+
+// moveAside moves the cache directory with the given key out of the way, so
+// that it can be deleted without holding its lock. The caller has to hold the
+// lock. Processes waiting for the lock notice that the directory is gone and
+// lock the one which takes its place instead, see lockDir.
+func (r *CacheHelper) moveAside(key string) (string, error) {
+	removed, err := os.MkdirTemp(r.cacheDir, removedDirPrefix)
+	if err != nil {
+		return "", fmt.Errorf("failed to remove cache directory %s: %v", key, err)
+	}
+	if err := os.Rename(filepath.Join(r.cacheDir, key), filepath.Join(removed, key)); err != nil {
+		os.Remove(removed)
+		return "", fmt.Errorf("failed to remove cache directory %s: %v", key, err)
+	}
+	return removed, nil
+}
+
+func (r *CacheHelper) removeMovedDir(removed string) error {
+	if err := os.RemoveAll(removed); err != nil {
+		return fmt.Errorf("failed to remove cache directory %s: %v", removed, err)
+	}
+	return nil
+}
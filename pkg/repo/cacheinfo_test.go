@@ -0,0 +1,270 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+const testCacheRepomd = `<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <revision>1700000000</revision>
+  <data type="primary">
+    <location href="repodata/new-primary.xml.zst"/>
+  </data>
+</repomd>
+`
+
+func TestGarbageCollect(t *testing.T) {
+	helper := &CacheHelper{cacheDir: t.TempDir()}
+	repo := &bazeldnf.Repository{Name: "test", Baseurl: "http://localhost/", Arch: "x86_64"}
+	unlock, err := helper.LockRepo(repo)
+	if err != nil {
+		t.Fatalf("failed to lock repo: %v", err)
+	}
+	unlock()
+	files := map[string]string{
+		"repomd.xml":                          testCacheRepomd,
+		"repomd.xml.validators.json":          "{}",
+		"new-primary.xml.zst":                 "new",
+		"new-primary.xml.zst.validators.json": "{}",
+		"old-primary.xml.zst":                 "old",
+		"old-primary.xml.zst.validators.json": "{}",
+		".old-primary.xml.zst.tmp-123":        "partial",
+		primaryIndexName:                      "index",
+	}
+	for name, content := range files {
+		if err := helper.WriteToRepoDir(repo, strings.NewReader(content), name); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	cached, err := helper.CachedRepositories()
+	if err != nil {
+		t.Fatalf("failed to list cached repositories: %v", err)
+	}
+	if len(cached) != 1 || cached[0].Key != RepoKey(repo) || cached[0].Name != "test" || cached[0].Arch != "x86_64" || cached[0].Revision != "1700000000" || cached[0].Fetched.IsZero() {
+		t.Fatalf("unexpected cached repositories: %+v", cached)
+	}
+	unreferenced := []string{".old-primary.xml.zst.tmp-123", "old-primary.xml.zst", "old-primary.xml.zst.validators.json"}
+	if !reflect.DeepEqual(cached[0].Unreferenced, unreferenced) {
+		t.Fatalf("expected unreferenced files %v, got %v", unreferenced, cached[0].Unreferenced)
+	}
+	if cached[0].UnreferencedSize != int64(len("old")+len("{}")+len("partial")) {
+		t.Fatalf("unexpected size of unreferenced files: %d", cached[0].UnreferencedSize)
+	}
+
+	if _, err := helper.GarbageCollect(RepoKey(repo)); err != nil {
+		t.Fatalf("failed to collect garbage: %v", err)
+	}
+	for name := range files {
+		_, err := os.Stat(filepath.Join(helper.repoDir(repo), name))
+		removed := os.IsNotExist(err)
+		if expected := slices.Contains(unreferenced, name); removed != expected {
+			t.Fatalf("expected removal of %s to be %v", name, expected)
+		}
+	}
+
+	if err := helper.RemoveCachedRepo(RepoKey(repo)); err != nil {
+		t.Fatalf("failed to remove repository: %v", err)
+	}
+	if cached, err := helper.CachedRepositories(); err != nil || len(cached) != 0 {
+		t.Fatalf("expected an empty cache, got %v, %v", cached, err)
+	}
+	if err := helper.RemoveCachedRepo(RepoKey(repo)); err == nil {
+		t.Fatalf("expected an error when removing a repository which is not cached")
+	}
+}
+
+func TestGarbageCollectWithoutRepomd(t *testing.T) {
+	helper := &CacheHelper{cacheDir: t.TempDir()}
+	repo := &bazeldnf.Repository{Name: "test"}
+	unlock, err := helper.LockRepo(repo)
+	if err != nil {
+		t.Fatalf("failed to lock repo: %v", err)
+	}
+	unlock()
+	if err := helper.WriteToRepoDir(repo, strings.NewReader("data"), "primary.xml.zst"); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	info, err := helper.GarbageCollect(RepoKey(repo))
+	if err != nil {
+		t.Fatalf("failed to collect garbage: %v", err)
+	}
+	if len(info.Unreferenced) != 0 || !info.Fetched.IsZero() {
+		t.Fatalf("expected nothing to be removed without repomd.xml, got %v", info.Unreferenced)
+	}
+}
+
+func TestGarbageCollectOrphaned(t *testing.T) {
+	helper := &CacheHelper{cacheDir: t.TempDir()}
+	// older bazeldnf versions named the cache directory after the repository
+	// and did not record its identity
+	legacy := filepath.Join(helper.cacheDir, "fedora")
+	if err := os.MkdirAll(legacy, 0770); err != nil {
+		t.Fatalf("failed to create cache directory: %v", err)
+	}
+	for name, content := range map[string]string{"repomd.xml": testCacheRepomd, "new-primary.xml.zst": "new"} {
+		if err := os.WriteFile(filepath.Join(legacy, name), []byte(content), 0660); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	repo := &bazeldnf.Repository{Name: "fedora", Baseurl: "http://localhost/", Arch: "x86_64"}
+	unlock, err := helper.LockRepo(repo)
+	if err != nil {
+		t.Fatalf("failed to lock repo: %v", err)
+	}
+	unlock()
+
+	cached, err := helper.CachedRepositories()
+	if err != nil {
+		t.Fatalf("failed to list cached repositories: %v", err)
+	}
+	orphaned := map[string]bool{}
+	for _, c := range cached {
+		orphaned[c.Key] = c.Orphaned
+	}
+	if !reflect.DeepEqual(orphaned, map[string]bool{"fedora": true, RepoKey(repo): false}) {
+		t.Fatalf("expected only the legacy cache directory to be orphaned, got %v", orphaned)
+	}
+
+	info, err := helper.GarbageCollect("fedora")
+	if err != nil {
+		t.Fatalf("failed to collect garbage: %v", err)
+	}
+	if !info.Orphaned || info.UnreferencedSize != info.Size {
+		t.Fatalf("expected all files of the orphaned cache directory to be unreferenced, got %+v", info)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Fatalf("expected the orphaned cache directory to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(helper.repoDir(repo), repoIdentityName)); err != nil {
+		t.Fatalf("expected the current cache directory to be kept: %v", err)
+	}
+}
+
+func TestLockRemovedCacheDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("cache locking is only supported on unix")
+	}
+	cacheDir := t.TempDir()
+	// separate helpers to mimic separate processes sharing the cache
+	remover := &CacheHelper{cacheDir: cacheDir}
+	fetcher := &CacheHelper{cacheDir: cacheDir}
+	other := &CacheHelper{cacheDir: cacheDir}
+	repo := &bazeldnf.Repository{Name: "test", Baseurl: "http://localhost/"}
+
+	unlock, err := remover.lockDir(remover.repoDir(repo), repo.Name, true)
+	if err != nil {
+		t.Fatalf("failed to lock repo: %v", err)
+	}
+	acquired := make(chan func())
+	go func() {
+		unlock, err := fetcher.LockRepo(repo)
+		if err != nil {
+			t.Errorf("failed to lock repo: %v", err)
+			close(acquired)
+			return
+		}
+		acquired <- unlock
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("expected the lock to wait for the removal")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the cache directory is removed while the fetcher waits for its lock
+	removed, err := remover.moveAside(RepoKey(repo))
+	if err != nil {
+		t.Fatalf("failed to move the cache directory aside: %v", err)
+	}
+	cached, err := remover.CachedRepositories()
+	if err != nil {
+		t.Fatalf("failed to list cached repositories: %v", err)
+	}
+	if len(cached) != 0 {
+		t.Fatalf("expected the removed cache directory to be ignored, got %v", cached)
+	}
+	unlock()
+	if err := remover.removeMovedDir(removed); err != nil {
+		t.Fatalf("failed to remove the cache directory: %v", err)
+	}
+
+	var funlock func()
+	select {
+	case funlock = <-acquired:
+		if funlock == nil {
+			return
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the lock to be granted after the removal")
+	}
+	// the fetcher has to hold the lock of the new cache directory, not of the
+	// removed one
+	go func() {
+		unlock, err := other.RLockRepo(repo)
+		if err != nil {
+			t.Errorf("failed to lock repo for reading: %v", err)
+			close(acquired)
+			return
+		}
+		acquired <- unlock
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("expected the read lock to wait for the fetcher")
+	case <-time.After(100 * time.Millisecond):
+	}
+	funlock()
+	select {
+	case runlock, ok := <-acquired:
+		if ok {
+			runlock()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the read lock to be granted after the fetcher released its lock")
+	}
+	if _, err := os.Stat(filepath.Join(fetcher.repoDir(repo), repoIdentityName)); err != nil {
+		t.Fatalf("expected the fetcher to set up a new cache directory: %v", err)
+	}
+}
+
+func TestRepoKey(t *testing.T) {
+	fedora := &bazeldnf.Repository{Name: "fedora", Metalink: "https://mirrors.fedoraproject.org/metalink?repo=fedora-41&arch=x86_64", Arch: "x86_64"}
+	tests := []struct {
+		name string
+		repo *bazeldnf.Repository
+		same bool
+	}{
+		{name: "different name", repo: &bazeldnf.Repository{Name: "other", Metalink: fedora.Metalink, Arch: fedora.Arch}, same: true},
+		{name: "different priority", repo: &bazeldnf.Repository{Name: "fedora", Metalink: fedora.Metalink, Arch: fedora.Arch, Priority: 10}, same: true},
+		{name: "different release", repo: &bazeldnf.Repository{Name: "fedora", Metalink: "https://mirrors.fedoraproject.org/metalink?repo=fedora-40&arch=x86_64", Arch: fedora.Arch}},
+		{name: "different arch", repo: &bazeldnf.Repository{Name: "fedora", Metalink: fedora.Metalink, Arch: "aarch64"}},
+		{name: "baseurl instead of metalink", repo: &bazeldnf.Repository{Name: "fedora", Baseurl: fedora.Metalink, Arch: fedora.Arch}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := RepoKey(tt.repo) == RepoKey(fedora); same != tt.same {
+				t.Fatalf("expected keys to be equal: %v, got %s and %s", tt.same, RepoKey(tt.repo), RepoKey(fedora))
+			}
+		})
+	}
+
+	// resolving the mirrors of a repository must not change its key
+	withMirrors := &bazeldnf.Repository{Name: "fedora", Metalink: fedora.Metalink, Arch: fedora.Arch, Mirrors: []string{"https://mirror.example.com/"}}
+	if RepoKey(withMirrors) != RepoKey(fedora) {
+		t.Fatalf("expected mirrors to be ignored when the repository has a metalink")
+	}
+	onlyMirrors := &bazeldnf.Repository{Name: "fedora", Arch: fedora.Arch, Mirrors: []string{"https://mirror.example.com/"}}
+	if RepoKey(onlyMirrors) == RepoKey(&bazeldnf.Repository{Name: "fedora", Arch: fedora.Arch}) {
+		t.Fatalf("expected mirrors to be part of the key when they are the only source")
+	}
+}
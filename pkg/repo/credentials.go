@@ -0,0 +1,320 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// credentialHelperTimeout and credentialHelperCacheDuration match the
+	// defaults of Bazel
+	credentialHelperTimeout       = 10 * time.Second
+	credentialHelperCacheDuration = 30 * time.Minute
+)
+
+type credentialOpts struct {
+	helpers         []string
+	bearerTokenEnv  []string
+	bearerTokenFile []string
+	headerEnv       []string
+}
+
+var credentialValues = credentialOpts{}
+
+// AddCredentialFlags registers the flags which configure how requests get
+// authenticated
+func AddCredentialFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&credentialValues.helpers, "credential-helper", nil, "credential helper implementing the protocol of Bazel's --credential_helper, in the form [host=]path. A host like *.example.com matches example.com and all its subdomains, the most specific helper is used. Can be specified multiple times")
+	cmd.Flags().StringArrayVar(&credentialValues.bearerTokenEnv, "bearer-token-env", nil, "send the content of an environment variable as bearer token to a host, in the form host=VARIABLE. Can be specified multiple times")
+	cmd.Flags().StringArrayVar(&credentialValues.bearerTokenFile, "bearer-token-file", nil, "send the content of a file as bearer token to a host, in the form host=path. Can be specified multiple times")
+	cmd.Flags().StringArrayVar(&credentialValues.headerEnv, "auth-header-env", nil, "send the content of an environment variable in a custom header to a host, in the form host=Header-Name:VARIABLE. Can be specified multiple times")
+}
+
+// CredentialProvider supplies the headers which authenticate requests
+type CredentialProvider interface {
+	// Headers returns the headers for a request to the URL, or nil if the
+	// provider has no credentials for it
+	Headers(u *url.URL) (http.Header, error)
+}
+
+// credentialProviders asks one provider after the other and uses the headers
+// of the first one which has credentials for the URL
+type credentialProviders []CredentialProvider
+
+func (c credentialProviders) Headers(u *url.URL) (http.Header, error) {
+	for _, provider := range c {
+		headers, err := provider.Headers(u)
+		if err != nil || headers != nil {
+			return headers, err
+		}
+	}
+	return nil, nil
+}
+
+// failingProvider reports invalid credential settings on every request
+type failingProvider struct {
+	err error
+}
+
+func (f failingProvider) Headers(u *url.URL) (http.Header, error) {
+	return nil, f.err
+}
+
+// defaultCredentials returns the providers configured via flags, followed by
+// .netrc
+func defaultCredentials() CredentialProvider {
+	providers, err := credentialValues.providers()
+	if err != nil {
+		return failingProvider{err: fmt.Errorf("invalid credential settings: %v", err)}
+	}
+	return append(providers, netrcProvider{})
+}
+
+// providers returns the static headers before the credential helpers, so
+// that credentials given explicitly for a host always take precedence
+func (o *credentialOpts) providers() (credentialProviders, error) {
+	headers := staticHeaders{}
+	for _, value := range o.bearerTokenEnv {
+		scope, variable, err := splitScope(value, true)
+		if err != nil {
+			return nil, fmt.Errorf("--bearer-token-env: %v", err)
+		}
+		headers = append(headers, staticHeader{scope: scope, name: "Authorization", prefix: "Bearer ", value: envValue(variable)})
+	}
+	for _, value := range o.bearerTokenFile {
+		scope, file, err := splitScope(value, true)
+		if err != nil {
+			return nil, fmt.Errorf("--bearer-token-file: %v", err)
+		}
+		headers = append(headers, staticHeader{scope: scope, name: "Authorization", prefix: "Bearer ", value: fileValue(file)})
+	}
+	for _, value := range o.headerEnv {
+		scope, header, err := splitScope(value, true)
+		if err != nil {
+			return nil, fmt.Errorf("--auth-header-env: %v", err)
+		}
+		name, variable, found := strings.Cut(header, ":")
+		if !found || name == "" || variable == "" {
+			return nil, fmt.Errorf("--auth-header-env: expected host=Header-Name:VARIABLE, got %q", value)
+		}
+		headers = append(headers, staticHeader{scope: scope, name: name, value: envValue(variable)})
+	}
+
+	helpers := credentialHelpers{}
+	for _, value := range o.helpers {
+		scope, path, err := splitScope(value, false)
+		if err != nil {
+			return nil, fmt.Errorf("--credential-helper: %v", err)
+		}
+		helpers = append(helpers, &credentialHelper{scope: scope, path: path, cache: map[string]cachedCredentials{}})
+	}
+	return credentialProviders{headers, helpers}, nil
+}
+
+// splitScope splits values of the form host=value. Like for Bazel's
+// credential helpers, the host may only be omitted if it is not required.
+func splitScope(value string, required bool) (string, string, error) {
+	scope, rest, found := strings.Cut(value, "=")
+	if !found {
+		if required {
+			return "", "", fmt.Errorf("expected host=value, got %q", value)
+		}
+		return "", value, nil
+	}
+	if scope == "" || rest == "" || strings.Contains(scope, "/") {
+		return "", "", fmt.Errorf("expected host=value, got %q", value)
+	}
+	return scope, rest, nil
+}
+
+// scopeSpecificity returns how specific a host scope matches the host, or -1
+// if it doesn't match at all. An empty scope matches every host, a scope like
+// *.example.com matches example.com and all its subdomains, like in Bazel.
+func scopeSpecificity(scope string, host string) int {
+	switch {
+	case scope == "":
+		return 0
+	case strings.HasPrefix(scope, "*."):
+		if host == scope[2:] || strings.HasSuffix(host, scope[1:]) {
+			return len(scope)
+		}
+	case scope == host:
+		// exact matches are more specific than any wildcard
+		return len(scope) + 1<<16
+	}
+	return -1
+}
+
+func envValue(variable string) func() (string, error) {
+	return func() (string, error) {
+		value, exists := os.LookupEnv(variable)
+		if !exists {
+			return "", fmt.Errorf("environment variable %s is not set", variable)
+		}
+		return value, nil
+	}
+}
+
+func fileValue(file string) func() (string, error) {
+	return func() (string, error) {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read token: %v", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+}
+
+type staticHeader struct {
+	scope  string
+	name   string
+	prefix string
+	value  func() (string, error)
+}
+
+// staticHeaders adds all headers configured for the host of the URL
+type staticHeaders []staticHeader
+
+func (s staticHeaders) Headers(u *url.URL) (http.Header, error) {
+	var headers http.Header
+	for _, header := range s {
+		if scopeSpecificity(header.scope, u.Hostname()) < 0 {
+			continue
+		}
+		value, err := header.value()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s header for %s: %v", header.name, u.Hostname(), err)
+		}
+		if headers == nil {
+			headers = http.Header{}
+		}
+		headers.Set(header.name, header.prefix+value)
+	}
+	return headers, nil
+}
+
+// credentialHelpers delegates to the helper with the most specific scope for
+// the host of the URL
+type credentialHelpers []*credentialHelper
+
+func (c credentialHelpers) Headers(u *url.URL) (http.Header, error) {
+	var selected *credentialHelper
+	best := -1
+	for _, helper := range c {
+		if specificity := scopeSpecificity(helper.scope, u.Hostname()); specificity > best {
+			selected, best = helper, specificity
+		}
+	}
+	if selected == nil {
+		return nil, nil
+	}
+	return selected.Headers(u)
+}
+
+type cachedCredentials struct {
+	headers http.Header
+	expires time.Time
+}
+
+// credentialHelper runs an external executable which implements the
+// credential helper protocol of Bazel, see
+// https://github.com/EngFlow/credential-helper-spec. Its responses are cached
+// per URL.
+type credentialHelper struct {
+	scope string
+	path  string
+	lock  sync.Mutex
+	cache map[string]cachedCredentials
+}
+
+type credentialHelperRequest struct {
+	URI string `json:"uri"`
+}
+
+type credentialHelperResponse struct {
+	Headers map[string][]string `json:"headers"`
+	Expires string              `json:"expires,omitempty"`
+}
+
+func (c *credentialHelper) Headers(u *url.URL) (http.Header, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	uri := u.String()
+	if cached, exists := c.cache[uri]; exists && time.Now().Before(cached.expires) {
+		return cached.headers, nil
+	}
+
+	request, err := json.Marshal(&credentialHelperRequest{URI: uri})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), credentialHelperTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.path, "get")
+	cmd.Stdin = bytes.NewReader(request)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	log.Debugf("Requesting credentials for %s from %s", u.Hostname(), c.path)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("credential helper %s failed: %v: %s", c.path, err, strings.TrimSpace(stderr.String()))
+	}
+	response := &credentialHelperResponse{}
+	if err := json.Unmarshal(stdout.Bytes(), response); err != nil {
+		return nil, fmt.Errorf("credential helper %s returned an invalid response: %v", c.path, err)
+	}
+
+	expires := time.Now().Add(credentialHelperCacheDuration)
+	if response.Expires != "" {
+		helperExpires, err := time.Parse(time.RFC3339, response.Expires)
+		if err != nil {
+			return nil, fmt.Errorf("credential helper %s returned an invalid expiry: %v", c.path, err)
+		}
+		if helperExpires.Before(expires) {
+			expires = helperExpires
+		}
+	}
+	var headers http.Header
+	if len(response.Headers) > 0 {
+		headers = http.Header{}
+		for name, values := range response.Headers {
+			for _, value := range values {
+				headers.Add(name, value)
+			}
+		}
+	}
+	c.cache[uri] = cachedCredentials{headers: headers, expires: expires}
+	return headers, nil
+}
+
+// netrcProvider sends basic auth credentials from the .netrc file
+type netrcProvider struct{}
+
+func (netrcProvider) Headers(u *url.URL) (http.Header, error) {
+	netrc, err := getNetrc()
+	if err != nil {
+		return nil, fmt.Errorf("getting netrc: %w", err)
+	}
+	if netrc == nil {
+		return nil, nil
+	}
+	m := netrc.Machine(u.Hostname())
+	if m == nil {
+		return nil, nil
+	}
+	log.Debugf("Reading auth headers for %s from %s", u.Hostname(), netrc.Path)
+	auth := m.Get("login") + ":" + m.Get("password")
+	return http.Header{"Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte(auth))}}, nil
+}
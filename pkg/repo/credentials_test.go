@@ -0,0 +1,181 @@
+package repo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("parsing %s: %v", rawURL, err)
+	}
+	return u
+}
+
+func TestStaticCredentials(t *testing.T) {
+	t.Setenv("TEST_TOKEN", "env-token")
+	t.Setenv("TEST_KEY", "secret-key")
+	tokenFile := writeTestFile(t, "token", []byte("file-token\n"))
+
+	opts := &credentialOpts{
+		bearerTokenEnv:  []string{"repo.example.com=TEST_TOKEN"},
+		bearerTokenFile: []string{"*.example.org=" + tokenFile},
+		headerEnv:       []string{"repo.example.com=X-Api-Key:TEST_KEY"},
+	}
+	providers, err := opts.providers()
+	if err != nil {
+		t.Fatalf("creating providers: %v", err)
+	}
+
+	headers, err := providers.Headers(mustParseURL(t, "https://repo.example.com/repodata/repomd.xml"))
+	if err != nil {
+		t.Fatalf("getting headers: %v", err)
+	}
+	if headers.Get("Authorization") != "Bearer env-token" || headers.Get("X-Api-Key") != "secret-key" {
+		t.Fatalf("expected the bearer token and the custom header, got %v", headers)
+	}
+
+	headers, err = providers.Headers(mustParseURL(t, "https://mirror.example.org/repodata/repomd.xml"))
+	if err != nil {
+		t.Fatalf("getting headers: %v", err)
+	}
+	if headers.Get("Authorization") != "Bearer file-token" {
+		t.Fatalf("expected the bearer token from the file, got %v", headers)
+	}
+
+	headers, err = providers.Headers(mustParseURL(t, "https://other.example.net/repodata/repomd.xml"))
+	if err != nil || headers != nil {
+		t.Fatalf("expected no headers for other hosts, got %v, %v", headers, err)
+	}
+
+	opts = &credentialOpts{bearerTokenEnv: []string{"repo.example.com=TEST_MISSING_TOKEN"}}
+	providers, err = opts.providers()
+	if err != nil {
+		t.Fatalf("creating providers: %v", err)
+	}
+	if _, err := providers.Headers(mustParseURL(t, "https://repo.example.com/")); err == nil {
+		t.Fatalf("expected an error for an unset environment variable")
+	}
+}
+
+func TestInvalidCredentialFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		opts credentialOpts
+	}{
+		{name: "bearer token without host", opts: credentialOpts{bearerTokenEnv: []string{"TOKEN"}}},
+		{name: "bearer token file without file", opts: credentialOpts{bearerTokenFile: []string{"example.com="}}},
+		{name: "header without name", opts: credentialOpts{headerEnv: []string{"example.com=TOKEN"}}},
+		{name: "helper with url as host", opts: credentialOpts{helpers: []string{"https://example.com/=helper"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.opts.providers(); err == nil {
+				t.Fatalf("expected an error")
+			}
+		})
+	}
+}
+
+func TestScopeSpecificity(t *testing.T) {
+	if scopeSpecificity("", "repo.example.com") != 0 {
+		t.Fatalf("expected an empty scope to match every host")
+	}
+	if scopeSpecificity("*.example.com", "example.com") <= 0 {
+		t.Fatalf("expected a wildcard to match the domain itself")
+	}
+	if scopeSpecificity("*.example.com", "notexample.com") != -1 {
+		t.Fatalf("expected a wildcard to only match the domain and its subdomains")
+	}
+	if scopeSpecificity("example.com", "example.com") <= scopeSpecificity("*.example.com", "example.com") {
+		t.Fatalf("expected an exact match to be more specific than a wildcard matching the domain")
+	}
+	if scopeSpecificity("other.example.com", "repo.example.com") != -1 {
+		t.Fatalf("expected other hosts not to match")
+	}
+	exact := scopeSpecificity("repo.example.com", "repo.example.com")
+	subdomain := scopeSpecificity("*.repo.example.com", "a.repo.example.com")
+	domain := scopeSpecificity("*.example.com", "a.repo.example.com")
+	if !(exact > subdomain && subdomain > domain && domain > 0) {
+		t.Fatalf("expected exact matches to be most specific, got %d, %d, %d", exact, subdomain, domain)
+	}
+}
+
+// writeTestHelper writes a credential helper which records its input and
+// answers with the given response
+func writeTestHelper(t *testing.T, response string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	helper := filepath.Join(dir, "helper")
+	script := "#!/bin/sh\n" +
+		"[ \"$1\" = get ] || exit 1\n" +
+		"cat >> " + input + "\n" +
+		"cat <<'EOF'\n" + response + "\nEOF\n"
+	if err := os.WriteFile(helper, []byte(script), 0700); err != nil {
+		t.Fatalf("writing helper: %v", err)
+	}
+	return helper, input
+}
+
+func TestCredentialHelper(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("requires /bin/sh")
+	}
+	var authorization string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	helper, input := writeTestHelper(t, `{"headers":{"Authorization":["Bearer helper-token"]}}`)
+	other, otherInput := writeTestHelper(t, `{"headers":{"Authorization":["Bearer other-token"]}}`)
+	opts := &credentialOpts{helpers: []string{other, "127.0.0.1=" + helper}}
+	providers, err := opts.providers()
+	if err != nil {
+		t.Fatalf("creating providers: %v", err)
+	}
+
+	getter := noRetryGetter()
+	getter.credentials = providers
+	for i := 0; i < 2; i++ {
+		resp, err := getter.Get(s.URL + "/repodata/repomd.xml")
+		if err != nil {
+			t.Fatalf("expected the request to succeed, got %v", err)
+		}
+		resp.Body.Close()
+		if authorization != "Bearer helper-token" {
+			t.Fatalf("expected the headers of the most specific helper, got %q", authorization)
+		}
+	}
+
+	data, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatalf("reading helper input: %v", err)
+	}
+	expected := `{"uri":"` + s.URL + `/repodata/repomd.xml"}`
+	if strings.TrimSpace(string(data)) != expected {
+		t.Fatalf("expected the helper to be called once with %s, got %s", expected, data)
+	}
+	if _, err := os.Stat(otherInput); !os.IsNotExist(err) {
+		t.Fatalf("expected the less specific helper not to be called")
+	}
+
+	failing, _ := writeTestHelper(t, `not json`)
+	opts = &credentialOpts{helpers: []string{failing}}
+	providers, err = opts.providers()
+	if err != nil {
+		t.Fatalf("creating providers: %v", err)
+	}
+	if _, err := providers.Headers(mustParseURL(t, s.URL)); err == nil {
+		t.Fatalf("expected an error for an invalid helper response")
+	}
+}
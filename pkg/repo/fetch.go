@@ -1,15 +1,16 @@
 package repo
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
-	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"hash"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
@@ -24,6 +25,7 @@ import (
 	"github.com/rmohr/bazeldnf/pkg/api"
 	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/openpgp"
 )
 
 type RepoFetcher interface {
@@ -34,61 +36,236 @@ type RepoFetcherImpl struct {
 	Getter      Getter
 	Repos       []bazeldnf.Repository
 	CacheHelper *CacheHelper
+	// Concurrency limits how many repositories are fetched at the same time.
+	// Values smaller than 1 fetch one repository at a time.
+	Concurrency int
+	// Filelists enables fetching filelists.xml, which allows resolving file
+	// requirements which are not covered by primary.xml.
+	Filelists bool
+	// Offline only checks that the metadata of all repositories is cached,
+	// without accessing the network.
+	Offline bool
 }
 
-func (r *RepoFetcherImpl) Fetch() (err error) {
-	for _, repo := range r.Repos {
-		sha256sum := []string{}
-		var repomdURLs = []string{}
-		if repo.Metalink != "" {
-			var metalink *api.Metalink
-			metalink, repomdURLs, err = r.resolveMetaLink(&repo)
-			if err != nil {
-				return fmt.Errorf("failed to resolve metalink for %s: %v", repo.Name, err)
-			}
-			sha256sum, err = metalink.Repomod().SHA256()
-			if err != nil {
-				return fmt.Errorf("failed to get sha256sum of repomd file: %v", err)
+// Fetch downloads the metadata of all enabled repositories, fetching up to
+// Concurrency repositories in parallel. Failures of individual repositories
+// don't stop the others from being fetched, all errors are reported together.
+func (r *RepoFetcherImpl) Fetch() error {
+	repos := []*bazeldnf.Repository{}
+	for i := range r.Repos {
+		if r.Repos[i].Disabled {
+			log.Infof("Skipping disabled repository %s", r.Repos[i].Name)
+			continue
+		}
+		repos = append(repos, &r.Repos[i])
+	}
+	if r.Offline {
+		if err := r.CacheHelper.CheckMetadata(repos, r.Filelists); err != nil {
+			return err
+		}
+		log.Info("All metadata is cached, skipping the fetch in offline mode")
+		return nil
+	}
+	concurrency := r.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	errs := make([]error, len(repos))
+	var wg sync.WaitGroup
+	for i := range repos {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			repo := *repos[i]
+			if err := r.fetchRepo(&repo); err != nil {
+				log.Errorf("Failed to fetch repository %s: %v", repo.Name, err)
+				errs[i] = err
 			}
-		} else if repo.Baseurl != "" {
-			repomdURLs = append(repomdURLs, strings.TrimSuffix(repo.Baseurl, "/")+"/repodata/repomd.xml")
+		}(i)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (r *RepoFetcherImpl) fetchRepo(repo *bazeldnf.Repository) (err error) {
+	if getter, ok := r.Getter.(*getterImpl); ok {
+		// fetch the repository with its own tls and proxy settings
+		repoGetter, err := getter.forRepository(repo)
+		if err != nil {
+			return err
 		}
-		repomd, mirror, err := r.resolveRepomd(&repo, repomdURLs, sha256sum)
+		fetcher := *r
+		fetcher.Getter = repoGetter
+		r = &fetcher
+	}
+
+	unlock, err := r.CacheHelper.LockRepo(repo)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	sha256sum := []string{}
+	var repomdURLs = []string{}
+	if repo.Metalink != "" {
+		var metalink *api.Metalink
+		metalink, repomdURLs, err = r.resolveMetaLink(repo)
 		if err != nil {
-			return fmt.Errorf("failed to fetch repomd.xml for %s: %v", repo.Name, err)
+			return fmt.Errorf("failed to resolve metalink for %s: %v", repo.Name, err)
 		}
-		err = r.fetchFile(api.PrimaryFileType, &repo, repomd, mirror)
+		sha256sum, err = metalink.Repomod().SHA256()
 		if err != nil {
-			return fmt.Errorf("failed to fetch primary.xml for %s: %v", repo.Name, err)
+			return fmt.Errorf("failed to get sha256sum of repomd file for %s: %v", repo.Name, err)
 		}
-		/* not used right now, save some bandwidth
-		err = r.fetchFile(api.FilelistsFileType, &repo, repomd, mirror)
+	} else if repo.Mirrorlist != "" {
+		repomdURLs, err = r.resolveMirrorlist(repo)
 		if err != nil {
+			return fmt.Errorf("failed to resolve mirrorlist for %s: %v", repo.Name, err)
+		}
+	} else if repo.Baseurl != "" {
+		repomdURLs = append(repomdURLs, strings.TrimSuffix(repo.Baseurl, "/")+"/repodata/repomd.xml")
+	}
+	// remember the repomd.xml of the last fetch before it gets replaced
+	previous, err := r.CacheHelper.LoadRepomd(repo)
+	if err != nil {
+		previous = nil
+	}
+	backup, err := r.backupRepomd(repo)
+	if err != nil {
+		return err
+	}
+	repomd, mirrors, err := r.resolveRepomd(repo, repomdURLs, sha256sum)
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to fetch repomd.xml for %s: %v", repo.Name, err), backup.restore())
+	}
+	if repo.RepoGPGCheck {
+		if err := r.verifyRepomdSignature(repo, mirrors); err != nil {
+			return errors.Join(fmt.Errorf("failed to verify repomd.xml for %s: %v", repo.Name, err), backup.restore())
+		}
+	}
+	if r.isUpToDate(api.PrimaryFileType, repo, previous, repomd) {
+		log.Infof("primary.xml for %s is up to date with revision %s", repo.Name, repomd.Revision)
+	} else {
+		err = r.fetchFile(api.PrimaryFileType, repo, repomd, mirrors)
+		if err != nil {
+			return fmt.Errorf("failed to fetch primary.xml for %s: %v", repo.Name, err)
+		}
+	}
+	if !r.CacheHelper.HasPrimaryIndex(repo, repomd) {
+		if err := r.CacheHelper.WritePrimaryIndex(repo, repomd); err != nil {
+			return fmt.Errorf("failed to index primary.xml for %s: %v", repo.Name, err)
+		}
+	}
+	// filelists are large and only needed for file requirements which
+	// primary.xml doesn't cover, so they are only fetched on request
+	if r.Filelists {
+		if metadataFile(repomd, api.FilelistsFileType) == nil {
+			log.Warnf("Repository %s does not provide filelists", repo.Name)
+		} else if r.isUpToDate(api.FilelistsFileType, repo, previous, repomd) {
+			log.Infof("filelists.xml for %s is up to date with revision %s", repo.Name, repomd.Revision)
+		} else if err := r.fetchFile(api.FilelistsFileType, repo, repomd, mirrors); err != nil {
 			return fmt.Errorf("failed to fetch filelists.xml for %s: %v", repo.Name, err)
 		}
-		*/
 	}
 	return nil
 }
 
-func NewRemoteRepoFetcher(repos []bazeldnf.Repository) RepoFetcher {
+func NewRemoteRepoFetcher(repos []bazeldnf.Repository, concurrency int, filelists bool) RepoFetcher {
 	return &RepoFetcherImpl{
 		Repos:       repos,
-		Getter:      &getterImpl{},
+		Getter:      &getterImpl{credentials: defaultCredentials()},
 		CacheHelper: NewCacheHelper(),
+		Concurrency: concurrency,
+		Filelists:   filelists,
+		Offline:     IsOffline(),
 	}
 }
 
-func (r *RepoFetcherImpl) resolveMetaLink(repo *bazeldnf.Repository) (*api.Metalink, []string, error) {
-	resp, err := r.Getter.Get(repo.Metalink)
+// NewGetter returns a Getter which downloads files with retries and
+// authentication, and which refuses to access the network in offline mode.
+func NewGetter() Getter {
+	return &getterImpl{credentials: defaultCredentials()}
+}
+
+// isUpToDate checks if the previously fetched repomd.xml references the same
+// revision and checksum of a file as the current one and if the cached copy of
+// that file is still intact, so that downloading it again can be skipped.
+func (r *RepoFetcherImpl) isUpToDate(fileType string, repo *bazeldnf.Repository, previous *api.Repomd, current *api.Repomd) bool {
+	if previous == nil || previous.Revision != current.Revision {
+		return false
+	}
+	previousFile := metadataFile(previous, fileType)
+	currentFile := metadataFile(current, fileType)
+	if previousFile == nil || currentFile == nil || previousFile.Location.Href != currentFile.Location.Href ||
+		previousFile.Checksum.Type != currentFile.Checksum.Type || previousFile.Checksum.Text != currentFile.Checksum.Text {
+		return false
+	}
+	sha, shasum, err := chooseHashType(currentFile)
 	if err != nil {
-		return nil, nil, err
+		return false
+	}
+	f, err := r.CacheHelper.OpenFromRepoDir(repo, filepath.Base(currentFile.Location.Href))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if _, err := io.Copy(sha, f); err != nil {
+		return false
+	}
+	return shasum == toHex(sha)
+}
+
+// download stores the content of rawURL in the cache file name of the
+// repository and writes the content to hasher. If the cached file was
+// downloaded from the same URL before, a conditional request is sent and the
+// cached file is kept when the server reports that it was not modified.
+func (r *RepoFetcherImpl) download(repo *bazeldnf.Repository, rawURL string, name string, hasher io.Writer) error {
+	header := http.Header{}
+	if validators := r.CacheHelper.LoadValidators(repo, name); validators != nil && validators.URL == rawURL {
+		if validators.ETag != "" {
+			header.Set("If-None-Match", validators.ETag)
+		}
+		if validators.LastModified != "" {
+			header.Set("If-Modified-Since", validators.LastModified)
+		}
+	}
+	resp, err := r.Getter.GetWithHeader(rawURL, header)
+	if err != nil {
+		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		log.Infof("%s was not modified, using the cached copy", rawURL)
+		f, err := r.CacheHelper.OpenFromRepoDir(repo, name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(hasher, f)
+		return err
+	}
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return nil, nil, fmt.Errorf("Failed to download %s: %v ", repo.Metalink, fmt.Errorf("status : %v", resp.StatusCode))
+		return fmt.Errorf("Failed to download %s: %v ", rawURL, fmt.Errorf("status : %v", resp.StatusCode))
+	}
+	// the validators belong to the old content, drop them before it gets replaced
+	if err := r.CacheHelper.RemoveValidators(repo, name); err != nil {
+		return err
 	}
-	if err := r.CacheHelper.WriteToRepoDir(repo, resp.Body, "metalink"); err != nil {
+	if err := r.CacheHelper.WriteToRepoDir(repo, io.TeeReader(resp.Body, hasher), name); err != nil {
+		return err
+	}
+	return r.CacheHelper.WriteValidators(repo, name, &HTTPValidators{
+		URL:          rawURL,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	})
+}
+
+func (r *RepoFetcherImpl) resolveMetaLink(repo *bazeldnf.Repository) (*api.Metalink, []string, error) {
+	if err := r.download(repo, repo.Metalink, "metalink", io.Discard); err != nil {
 		return nil, nil, err
 	}
 
@@ -118,26 +295,39 @@ func (r *RepoFetcherImpl) resolveMetaLink(repo *bazeldnf.Repository) (*api.Metal
 	return metalink, urls, nil
 }
 
-func (r *RepoFetcherImpl) resolveRepomd(repo *bazeldnf.Repository, repomdURLs []string, sha256sums []string) (repomd *api.Repomd, mirror *url.URL, err error) {
-	for _, u := range repomdURLs {
+// resolveMirrorlist downloads the plain text mirrorlist of the repository and
+// returns the repomd.xml URLs of all listed mirrors.
+func (r *RepoFetcherImpl) resolveMirrorlist(repo *bazeldnf.Repository) ([]string, error) {
+	if err := r.download(repo, repo.Mirrorlist, "mirrorlist", io.Discard); err != nil {
+		return nil, err
+	}
+
+	mirrors, err := r.CacheHelper.LoadMirrorlist(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := []string{}
+	for _, mirror := range mirrors {
+		urls = append(urls, strings.TrimSuffix(mirror, "/")+"/repodata/repomd.xml")
+	}
+
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("Mirrorlist contains no mirrors")
+	}
+
+	return urls, nil
+}
+
+func (r *RepoFetcherImpl) resolveRepomd(repo *bazeldnf.Repository, repomdURLs []string, sha256sums []string) (repomd *api.Repomd, mirrors *mirrorList, err error) {
+	for i, u := range repomdURLs {
 		sha := sha256.New()
 		log.Infof("Resolving repomd.xml from %s", u)
-		resp, err := r.Getter.Get(u)
+		err := r.download(repo, u, "repomd.xml", sha)
 		if err != nil {
 			log.Errorf("Failed to resolve repomd.xml from %s: %v", u, err)
 			continue
 		}
-		defer resp.Body.Close()
-		if resp.StatusCode < 200 || resp.StatusCode > 299 {
-			log.Warningf("Failed to download %s: %v ", u, fmt.Errorf("status : %v", resp.StatusCode))
-			continue
-		}
-		body := io.TeeReader(resp.Body, sha)
-		err = r.CacheHelper.WriteToRepoDir(repo, body, "repomd.xml")
-		if err != nil {
-			log.Errorf("Failed to save repomd.xml from %s: %v", u, err)
-			continue
-		}
 		if len(sha256sums) > 0 {
 			matched := false
 			for _, sum := range sha256sums {
@@ -161,23 +351,162 @@ func (r *RepoFetcherImpl) resolveRepomd(repo *bazeldnf.Repository, repomdURLs []
 			log.Errorf("Failed to decode repomd.xml from %s: %v", u, err)
 			continue
 		}
-		repomd = file
-		mirror, err = url.Parse(u)
+		mirror, err := mirrorFromRepomdURL(u)
 		if err != nil {
 			log.Fatalf("Invalid URL for repomd.xml from %s, this should be impossible: %v", u, err)
 		}
+		repomd = file
+		mirrors = &mirrorList{
+			getter:    r.Getter,
+			repomdSum: toHex(sha),
+			verified:  []*url.URL{mirror},
+			pending:   repomdURLs[i+1:],
+		}
 		break
 	}
 
 	if repomd == nil {
 		return nil, nil, fmt.Errorf("All mirrors tried, could not download repomd.xml")
 	}
+	return repomd, mirrors, nil
+}
+
+// repomdBackup holds the cached repomd.xml and its validators from before a
+// fetch, so that a repomd.xml which gets rejected doesn't stay in the cache.
+type repomdBackup struct {
+	cache      *CacheHelper
+	repo       *bazeldnf.Repository
+	data       []byte
+	validators *HTTPValidators
+}
+
+func (r *RepoFetcherImpl) backupRepomd(repo *bazeldnf.Repository) (*repomdBackup, error) {
+	backup := &repomdBackup{
+		cache:      r.CacheHelper,
+		repo:       repo,
+		validators: r.CacheHelper.LoadValidators(repo, "repomd.xml"),
+	}
+	f, err := r.CacheHelper.OpenFromRepoDir(repo, "repomd.xml")
+	if errors.Is(err, fs.ErrNotExist) {
+		return backup, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if backup.data, err = io.ReadAll(f); err != nil {
+		return nil, fmt.Errorf("failed to read cached repomd.xml for %s: %v", repo.Name, err)
+	}
+	return backup, nil
+}
+
+// restore puts the backed up repomd.xml and its validators back into the
+// cache, or removes repomd.xml if there was none before.
+func (b *repomdBackup) restore() error {
+	if b.data == nil {
+		if err := b.cache.RemoveFromRepoDir(b.repo, "repomd.xml"); err != nil {
+			return err
+		}
+		return b.cache.RemoveValidators(b.repo, "repomd.xml")
+	}
+	if err := b.cache.WriteToRepoDir(b.repo, bytes.NewReader(b.data), "repomd.xml"); err != nil {
+		return err
+	}
+	if b.validators == nil {
+		return b.cache.RemoveValidators(b.repo, "repomd.xml")
+	}
+	return b.cache.WriteValidators(b.repo, "repomd.xml", b.validators)
+}
+
+func mirrorFromRepomdURL(repomdURL string) (*url.URL, error) {
+	mirror, err := url.Parse(repomdURL)
+	if err != nil {
+		return nil, err
+	}
 	mirror.Path = strings.TrimSuffix(path.Dir(mirror.Path), "repodata")
-	return repomd, mirror, nil
+	return mirror, nil
 }
 
-func (r *RepoFetcherImpl) fetchFile(fileType string, repo *bazeldnf.Repository, repomd *api.Repomd, mirror *url.URL) (err error) {
-	file := repomd.File(fileType)
+// mirrorList hands out the mirrors of a repository which serve a repomd.xml
+// identical to the one which was accepted. Mirrors besides the one which
+// served the accepted repomd.xml are only checked once they are needed.
+type mirrorList struct {
+	getter    Getter
+	repomdSum string
+	verified  []*url.URL
+	pending   []string
+}
+
+// get returns the i-th mirror with an identical repomd.xml, or nil if there
+// are no more such mirrors. Mirrors which had to be skipped while looking for
+// it are reported as failures.
+func (m *mirrorList) get(i int) (mirror *url.URL, failures []error) {
+	for len(m.verified) <= i && len(m.pending) > 0 {
+		u := m.pending[0]
+		m.pending = m.pending[1:]
+		if err := m.verify(u); err != nil {
+			log.Warningf("Skipping mirror %s: %v", u, err)
+			failures = append(failures, fmt.Errorf("%s: %v", u, err))
+			continue
+		}
+		mirror, err := mirrorFromRepomdURL(u)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("%s: %v", u, err))
+			continue
+		}
+		m.verified = append(m.verified, mirror)
+	}
+	if len(m.verified) <= i {
+		return nil, failures
+	}
+	return m.verified[i], failures
+}
+
+// try calls fetch with one mirror after the other until it succeeds. If all
+// mirrors fail, the failure of every mirror is reported.
+func (m *mirrorList) try(fetch func(mirror *url.URL) error) error {
+	var failures []error
+	for i := 0; ; i++ {
+		mirror, skipped := m.get(i)
+		failures = append(failures, skipped...)
+		if mirror == nil {
+			break
+		}
+		err := fetch(mirror)
+		if err == nil {
+			if len(failures) > 0 {
+				log.Warningf("Succeeded with mirror %s after %d mirror failures", mirror, len(failures))
+			}
+			return nil
+		}
+		log.Warningf("Failed to load from mirror %s: %v", mirror, err)
+		failures = append(failures, fmt.Errorf("%s: %v", mirror, err))
+	}
+	return fmt.Errorf("All mirrors failed: %w", errors.Join(failures...))
+}
+
+func (m *mirrorList) verify(repomdURL string) error {
+	resp, err := m.getter.Get(repomdURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status : %v", resp.StatusCode)
+	}
+	sha := sha256.New()
+	if _, err := io.Copy(sha, resp.Body); err != nil {
+		return err
+	}
+	if toHex(sha) != m.repomdSum {
+		return fmt.Errorf("repomd.xml differs, expected sha256 sum %s, but got %s", m.repomdSum, toHex(sha))
+	}
+	return nil
+}
+
+// fetchFile downloads the file of the given type referenced in repomd. If a
+// mirror fails to deliver the file, the remaining mirrors are tried in turn.
+func (r *RepoFetcherImpl) fetchFile(fileType string, repo *bazeldnf.Repository, repomd *api.Repomd, mirrors *mirrorList) (err error) {
+	file := metadataFile(repomd, fileType)
 	if file == nil {
 		return fmt.Errorf("No 'file' file referenced in repomd")
 	}
@@ -185,6 +514,12 @@ func (r *RepoFetcherImpl) fetchFile(fileType string, repo *bazeldnf.Repository,
 		return fmt.Errorf("The 'file' file has no href associated")
 	}
 
+	return mirrors.try(func(mirror *url.URL) error {
+		return r.fetchFileFromMirror(fileType, repo, file, mirror)
+	})
+}
+
+func (r *RepoFetcherImpl) fetchFileFromMirror(fileType string, repo *bazeldnf.Repository, file *api.Data, mirror *url.URL) (err error) {
 	fileURL := file.Location.Href
 	fileName := filepath.Base(file.Location.Href)
 	if !path.IsAbs(file.Location.Href) {
@@ -219,12 +554,81 @@ func (r *RepoFetcherImpl) fetchFile(fileType string, repo *bazeldnf.Repository,
 	return nil
 }
 
+// verifyRepomdSignature checks the cached repomd.xml against the detached
+// signature repomd.xml.asc which is published next to it, using the gpg key of
+// the repository.
+func (r *RepoFetcherImpl) verifyRepomdSignature(repo *bazeldnf.Repository, mirrors *mirrorList) error {
+	if repo.GPGKey == "" {
+		return fmt.Errorf("repo_gpgcheck is enabled, but no gpgkey is configured")
+	}
+	keyring, err := r.loadKeyring(repo.GPGKey)
+	if err != nil {
+		return err
+	}
+
+	err = mirrors.try(func(mirror *url.URL) error {
+		sigURL := *mirror
+		sigURL.Path = path.Join(mirror.Path, "repodata/repomd.xml.asc")
+		log.Infof("Loading repomd.xml signature from %s", sigURL.String())
+		resp, err := r.Getter.Get(sigURL.String())
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("Failed to download %s: %v ", sigURL.String(), fmt.Errorf("status : %v", resp.StatusCode))
+		}
+		return r.CacheHelper.WriteToRepoDir(repo, resp.Body, "repomd.xml.asc")
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch repomd.xml.asc: %w", err)
+	}
+
+	repomd, err := r.CacheHelper.OpenFromRepoDir(repo, "repomd.xml")
+	if err != nil {
+		return err
+	}
+	defer repomd.Close()
+	signature, err := r.CacheHelper.OpenFromRepoDir(repo, "repomd.xml.asc")
+	if err != nil {
+		return err
+	}
+	defer signature.Close()
+	signer, err := openpgp.CheckArmoredDetachedSignature(keyring, repomd, signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	log.Infof("Verified repomd.xml for %s, signed by key %s", repo.Name, signer.PrimaryKey.KeyIdString())
+	return nil
+}
+
+func (r *RepoFetcherImpl) loadKeyring(gpgKey string) (openpgp.EntityList, error) {
+	resp, err := r.Getter.Get(gpgKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch gpgkey %s: %w", gpgKey, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("could not fetch gpgkey %s: status : %v", gpgKey, resp.StatusCode)
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not load gpgkey %s: %w", gpgKey, err)
+	}
+	return keyring, nil
+}
+
 type Getter interface {
 	Get(url string) (resp *http.Response, err error)
+	// GetWithHeader works like Get but adds the given headers to the request,
+	// e.g. to issue conditional requests
+	GetWithHeader(url string, header http.Header) (resp *http.Response, err error)
 }
 
 type getterImpl struct {
 	client *retryablehttp.Client
+	// credentials authenticate the requests, only .netrc is used if unset
+	credentials CredentialProvider
 }
 
 func fileGet(filename string) (*http.Response, error) {
@@ -288,33 +692,26 @@ func getNetrc() (*netrc.Netrc, error) {
 	return nil, nil
 }
 
-func addAuthHeader(r *retryablehttp.Request) error {
-	netrc, err := getNetrc()
-	if err != nil {
-		return fmt.Errorf("getting netrc: %w", err)
-	}
-	if netrc == nil {
-		return nil
-	}
-	m := netrc.Machine(r.URL.Hostname())
-	if m != nil {
-		log.Debugf("Reading auth headers for %s from %s", r.URL.Hostname(), netrc.Path)
-		auth := m.Get("login") + ":" + m.Get("password")
-		r.Header.Add("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
-	}
-	return nil
-}
-
-func (g *getterImpl) httpGet(rawUrl string) (*http.Response, error) {
+func (g *getterImpl) httpGet(rawUrl string, header http.Header) (*http.Response, error) {
 	req, err := retryablehttp.NewRequest("GET", rawUrl, nil)
 
 	if err != nil {
 		return nil, err
 	}
-	err = addAuthHeader(req)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	credentials := g.credentials
+	if credentials == nil {
+		credentials = netrcProvider{}
+	}
+	auth, err := credentials.Headers(req.URL)
 	if err != nil {
 		return nil, err
 	}
+	for k, v := range auth {
+		req.Header[k] = v
+	}
 	client := g.client
 	if client == nil {
 		client = retryablehttp.NewClient()
@@ -323,6 +720,10 @@ func (g *getterImpl) httpGet(rawUrl string) (*http.Response, error) {
 }
 
 func (g *getterImpl) Get(rawURL string) (*http.Response, error) {
+	return g.GetWithHeader(rawURL, nil)
+}
+
+func (g *getterImpl) GetWithHeader(rawURL string, header http.Header) (*http.Response, error) {
 	u, err := url.Parse(rawURL)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to parse URL: %w", err)
@@ -330,7 +731,10 @@ func (g *getterImpl) Get(rawURL string) (*http.Response, error) {
 	if u.Scheme == "file" {
 		return fileGet(u.Path)
 	}
-	return g.httpGet(rawURL)
+	if IsOffline() {
+		return nil, fmt.Errorf("cannot download %s: %w", rawURL, ErrOffline)
+	}
+	return g.httpGet(rawURL, header)
 }
 
 func toHex(hasher hash.Hash) string {
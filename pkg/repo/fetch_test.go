@@ -2,17 +2,27 @@ package repo
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
 )
 
 const retryAttempts = 5
@@ -160,3 +170,418 @@ password %s`, user, password)
 		t.Fatalf("We've set NETRC so the server should reply with 200 but got %d", resp.StatusCode)
 	}
 }
+
+const testPrimary = `<?xml version="1.0" encoding="UTF-8"?>
+<metadata xmlns="http://linux.duke.edu/metadata/common" xmlns:rpm="http://linux.duke.edu/metadata/rpm" packages="1">
+<package type="rpm">
+  <name>testpkg</name>
+  <arch>x86_64</arch>
+  <version epoch="0" ver="1.0" rel="1"/>
+</package>
+</metadata>
+`
+
+// newTestRepoHandler serves a minimal rpm-md repository with a gzipped
+// primary.xml. If signer is set, a repomd.xml.asc signature is served too.
+func newTestRepoHandler(t *testing.T, signer *openpgp.Entity) http.Handler {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write([]byte(testPrimary)); err != nil {
+		t.Fatalf("compressing primary.xml: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("compressing primary.xml: %v", err)
+	}
+	primary := buf.Bytes()
+	sum := sha256.Sum256(primary)
+	primaryName := hex.EncodeToString(sum[:]) + "-primary.xml.gz"
+	repomd := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <revision>1</revision>
+  <data type="primary">
+    <checksum type="sha256">%s</checksum>
+    <location href="repodata/%s"/>
+  </data>
+</repomd>
+`, hex.EncodeToString(sum[:]), primaryName)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repodata/repomd.xml", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("ETag", `"repomd-1"`)
+		http.ServeContent(rw, r, "repomd.xml", time.Time{}, strings.NewReader(repomd))
+	})
+	mux.HandleFunc("/repodata/"+primaryName, func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write(primary)
+	})
+	if signer != nil {
+		signature := &bytes.Buffer{}
+		if err := openpgp.ArmoredDetachSign(signature, signer, strings.NewReader(repomd), nil); err != nil {
+			t.Fatalf("signing repomd.xml: %v", err)
+		}
+		mux.HandleFunc("/repodata/repomd.xml.asc", func(rw http.ResponseWriter, r *http.Request) {
+			rw.Write(signature.Bytes())
+		})
+	}
+	return mux
+}
+
+func TestFetchConcurrently(t *testing.T) {
+	const concurrency = 2
+	var inFlight, maxInFlight atomic.Int32
+	handler := newTestRepoHandler(t, nil)
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			max := maxInFlight.Load()
+			if current <= max || maxInFlight.CompareAndSwap(max, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		if strings.HasPrefix(r.URL.Path, "/missing/") {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.StripPrefix(strings.SplitN(r.URL.Path, "/repodata/", 2)[0], handler).ServeHTTP(rw, r)
+	}))
+	defer s.Close()
+
+	repos := []bazeldnf.Repository{}
+	for i := 0; i < 5; i++ {
+		repos = append(repos, bazeldnf.Repository{Name: fmt.Sprintf("repo%d", i), Baseurl: fmt.Sprintf("%s/repo%d/", s.URL, i)})
+	}
+	repos = append(repos, bazeldnf.Repository{Name: "broken", Baseurl: s.URL + "/missing/"})
+
+	client := retryablehttp.NewClient()
+	client.RetryMax = 0
+	cacheHelper := NewCacheHelper(t.TempDir())
+	fetcher := &RepoFetcherImpl{
+		Getter:      &getterImpl{client: client},
+		Repos:       repos,
+		CacheHelper: cacheHelper,
+		Concurrency: concurrency,
+	}
+
+	err := fetcher.Fetch()
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("expected an error for the broken repository, got %v", err)
+	}
+	if maxInFlight.Load() > concurrency {
+		t.Fatalf("expected at most %d parallel requests, but got %d", concurrency, maxInFlight.Load())
+	}
+	for i := range repos[:5] {
+		primary, err := cacheHelper.CurrentPrimary(&repos[i])
+		if err != nil {
+			t.Fatalf("loading primary of %s: %v", repos[i].Name, err)
+		}
+		if len(primary.Packages) != 1 || primary.Packages[0].Name != "testpkg" {
+			t.Fatalf("unexpected packages in primary of %s: %v", repos[i].Name, primary.Packages)
+		}
+	}
+}
+
+func TestFetchUnchangedRepository(t *testing.T) {
+	handler := newTestRepoHandler(t, nil)
+	requests := map[string]int{}
+	notModified := 0
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests[path.Base(r.URL.Path)]++
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		if recorder.Code == http.StatusNotModified {
+			notModified++
+		}
+		for k, v := range recorder.Header() {
+			rw.Header()[k] = v
+		}
+		rw.WriteHeader(recorder.Code)
+		rw.Write(recorder.Body.Bytes())
+	}))
+	defer s.Close()
+
+	repos := []bazeldnf.Repository{{Name: "repo", Baseurl: s.URL}}
+	cacheHelper := NewCacheHelper(t.TempDir())
+	fetcher := &RepoFetcherImpl{
+		Getter:      &getterImpl{},
+		Repos:       repos,
+		CacheHelper: cacheHelper,
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := fetcher.Fetch(); err != nil {
+			t.Fatalf("fetch %d failed: %v", i, err)
+		}
+	}
+	if requests["repomd.xml"] != 3 {
+		t.Fatalf("expected repomd.xml to be requested 3 times, but got %d", requests["repomd.xml"])
+	}
+	if notModified != 2 {
+		t.Fatalf("expected 2 conditional requests to be answered with 304, but got %d", notModified)
+	}
+	primaries := 0
+	for name, count := range requests {
+		if strings.HasSuffix(name, "-primary.xml.gz") {
+			primaries += count
+		}
+	}
+	if primaries != 1 {
+		t.Fatalf("expected primary.xml to be downloaded once, but got %d downloads", primaries)
+	}
+	primary, err := cacheHelper.CurrentPrimary(&repos[0])
+	if err != nil {
+		t.Fatalf("loading primary failed: %v", err)
+	}
+	if len(primary.Packages) != 1 {
+		t.Fatalf("expected one package in primary, but got %d", len(primary.Packages))
+	}
+}
+
+func TestFetchFileMirrorFailover(t *testing.T) {
+	handler := newTestRepoHandler(t, nil)
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mirror, file, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		switch {
+		case mirror == "lagging" && file == "repodata/repomd.xml":
+			fmt.Fprint(rw, "<repomd><revision>0</revision></repomd>")
+		case mirror == "broken" && file != "repodata/repomd.xml":
+			rw.WriteHeader(http.StatusNotFound)
+		default:
+			http.StripPrefix("/"+mirror, handler).ServeHTTP(rw, r)
+		}
+	}))
+	defer s.Close()
+
+	fetcher := &RepoFetcherImpl{
+		Getter:      &getterImpl{},
+		CacheHelper: NewCacheHelper(t.TempDir()),
+	}
+	repo := &bazeldnf.Repository{Name: "repo"}
+	repomdURL := func(mirror string) string {
+		return fmt.Sprintf("%s/%s/repodata/repomd.xml", s.URL, mirror)
+	}
+
+	repomd, mirrors, err := fetcher.resolveRepomd(repo, []string{repomdURL("broken"), repomdURL("lagging"), repomdURL("good")}, nil)
+	if err != nil {
+		t.Fatalf("resolving repomd.xml failed: %v", err)
+	}
+	if err := fetcher.fetchFile(api.PrimaryFileType, repo, repomd, mirrors); err != nil {
+		t.Fatalf("expected fallback to the good mirror, but got: %v", err)
+	}
+	if _, err := fetcher.CacheHelper.CurrentPrimary(repo); err != nil {
+		t.Fatalf("loading primary failed: %v", err)
+	}
+
+	repomd, mirrors, err = fetcher.resolveRepomd(repo, []string{repomdURL("broken"), repomdURL("lagging")}, nil)
+	if err != nil {
+		t.Fatalf("resolving repomd.xml failed: %v", err)
+	}
+	err = fetcher.fetchFile(api.PrimaryFileType, repo, repomd, mirrors)
+	if err == nil {
+		t.Fatalf("expected all mirrors to fail")
+	}
+	for _, expected := range []string{"/broken/", "status : 404", "/lagging/", "repomd.xml differs"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected error to contain %q, but got: %v", expected, err)
+		}
+	}
+}
+
+func writeArmoredPublicKey(t *testing.T, entity *openpgp.Entity) string {
+	t.Helper()
+	keyFile := path.Join(t.TempDir(), "RPM-GPG-KEY")
+	buf := &bytes.Buffer{}
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("armoring key: %v", err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatalf("serializing key: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("armoring key: %v", err)
+	}
+	if err := os.WriteFile(keyFile, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("writing key: %v", err)
+	}
+	return "file://" + keyFile
+}
+
+func TestFetchRepomdSignature(t *testing.T) {
+	signer, err := openpgp.NewEntity("repo", "", "repo@example.com", nil)
+	if err != nil {
+		t.Fatalf("creating signing key: %v", err)
+	}
+	other, err := openpgp.NewEntity("other", "", "other@example.com", nil)
+	if err != nil {
+		t.Fatalf("creating other key: %v", err)
+	}
+	s := httptest.NewServer(newTestRepoHandler(t, signer))
+	defer s.Close()
+	unsigned := httptest.NewServer(newTestRepoHandler(t, nil))
+	defer unsigned.Close()
+
+	for _, tc := range []struct {
+		name    string
+		key     *openpgp.Entity
+		baseurl string
+		err     string
+	}{
+		{
+			name:    "valid signature",
+			key:     signer,
+			baseurl: s.URL,
+		},
+		{
+			name:    "unknown signer",
+			key:     other,
+			baseurl: s.URL,
+			err:     "invalid signature",
+		},
+		{
+			name:    "missing signature",
+			key:     signer,
+			baseurl: unsigned.URL,
+			err:     "failed to fetch repomd.xml.asc",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fetcher := &RepoFetcherImpl{
+				Getter: &getterImpl{},
+				Repos: []bazeldnf.Repository{{
+					Name:         "repo",
+					Baseurl:      tc.baseurl,
+					GPGKey:       writeArmoredPublicKey(t, tc.key),
+					RepoGPGCheck: true,
+				}},
+				CacheHelper: NewCacheHelper(t.TempDir()),
+			}
+			err := fetcher.Fetch()
+			if tc.err == "" && err != nil {
+				t.Fatalf("fetch failed: %v", err)
+			}
+			if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Fatalf("expected error %q, but got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestFetchMirrorlist(t *testing.T) {
+	handler := newTestRepoHandler(t, nil)
+	var mirrorlist string
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mirror, file, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		switch {
+		case r.URL.Path == "/mirrorlist":
+			fmt.Fprint(rw, mirrorlist)
+		case mirror == "broken":
+			rw.WriteHeader(http.StatusNotFound)
+		case file != "":
+			http.StripPrefix("/"+mirror, handler).ServeHTTP(rw, r)
+		}
+	}))
+	defer s.Close()
+	mirrorlist = fmt.Sprintf("# mirrors for testing\n%s/broken/\n\n%s/good\n%s/other/\n", s.URL, s.URL, s.URL)
+
+	repos := []bazeldnf.Repository{{Name: "repo", Mirrorlist: s.URL + "/mirrorlist"}}
+	cacheHelper := NewCacheHelper(t.TempDir())
+	fetcher := &RepoFetcherImpl{
+		Getter:      &getterImpl{},
+		Repos:       repos,
+		CacheHelper: cacheHelper,
+	}
+	if err := fetcher.Fetch(); err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+
+	primary, err := cacheHelper.CurrentPrimary(&repos[0])
+	if err != nil {
+		t.Fatalf("loading primary failed: %v", err)
+	}
+	if len(primary.Packages) != 1 {
+		t.Fatalf("expected one package in primary, but got %d", len(primary.Packages))
+	}
+	expected := []string{s.URL + "/broken/", s.URL + "/good/", s.URL + "/other/"}
+	if !reflect.DeepEqual(repos[0].Mirrors, expected) {
+		t.Fatalf("expected mirrors %v, but got %v", expected, repos[0].Mirrors)
+	}
+}
+
+func TestFetchRepomdSignatureKeepsCache(t *testing.T) {
+	signer, err := openpgp.NewEntity("repo", "", "repo@example.com", nil)
+	if err != nil {
+		t.Fatalf("creating signing key: %v", err)
+	}
+	other, err := openpgp.NewEntity("other", "", "other@example.com", nil)
+	if err != nil {
+		t.Fatalf("creating other key: %v", err)
+	}
+	s := httptest.NewServer(newTestRepoHandler(t, signer))
+	defer s.Close()
+
+	for _, tc := range []struct {
+		name       string
+		previous   string
+		validators *HTTPValidators
+	}{
+		{
+			name: "empty cache",
+		},
+		{
+			name:       "cached repomd.xml",
+			previous:   "previous repomd.xml",
+			validators: &HTTPValidators{URL: "http://mirror.example.com/repodata/repomd.xml", ETag: `"previous"`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := bazeldnf.Repository{
+				Name:         "repo",
+				Baseurl:      s.URL,
+				GPGKey:       writeArmoredPublicKey(t, other),
+				RepoGPGCheck: true,
+			}
+			cache := NewCacheHelper(t.TempDir())
+			if tc.previous != "" {
+				if err := cache.WriteToRepoDir(&repo, strings.NewReader(tc.previous), "repomd.xml"); err != nil {
+					t.Fatalf("writing cached repomd.xml: %v", err)
+				}
+				if err := cache.WriteValidators(&repo, "repomd.xml", tc.validators); err != nil {
+					t.Fatalf("writing cached validators: %v", err)
+				}
+			}
+			fetcher := &RepoFetcherImpl{
+				Getter:      &getterImpl{},
+				Repos:       []bazeldnf.Repository{repo},
+				CacheHelper: cache,
+			}
+			if err := fetcher.Fetch(); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+				t.Fatalf("expected an invalid signature, but got %v", err)
+			}
+
+			f, err := cache.OpenFromRepoDir(&repo, "repomd.xml")
+			if tc.previous == "" {
+				if err == nil {
+					f.Close()
+					t.Fatalf("expected the rejected repomd.xml to be removed from the cache")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("opening cached repomd.xml: %v", err)
+			}
+			defer f.Close()
+			data, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatalf("reading cached repomd.xml: %v", err)
+			}
+			if string(data) != tc.previous {
+				t.Fatalf("expected the cached repomd.xml to be unchanged, but got %q", data)
+			}
+			if validators := cache.LoadValidators(&repo, "repomd.xml"); validators == nil || *validators != *tc.validators {
+				t.Fatalf("expected the cached validators %v to be unchanged, but got %v", tc.validators, validators)
+			}
+		})
+	}
+}
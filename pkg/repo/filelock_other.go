@@ -0,0 +1,12 @@
+//go:build !unix
+
+package repo
+
+import (
+	"os"
+)
+
+// lockFile is a no-op on platforms without flock support
+func lockFile(f *os.File, exclusive bool) error {
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package repo
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile acquires an advisory lock on the open file, which is released when
+// the file gets closed. Exclusive locks wait for all other locks to be
+// released, shared locks only wait for exclusive ones.
+func lockFile(f *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
@@ -60,7 +60,12 @@ func NewRemoteInit(os string, arch string, repoFile string) *RepoInit {
 	}
 }
 
+// LoadRepoFile reads repository definitions from a bazeldnf repo.yaml file or,
+// if the file name ends with .repo, from a yum/dnf .repo file.
 func LoadRepoFile(file string) (*bazeldnf.Repositories, error) {
+	if strings.HasSuffix(file, ".repo") {
+		return LoadYumRepoFile(file)
+	}
 	repofile, err := ioutil.ReadFile(file)
 	if err != nil {
 		return nil, err
@@ -73,6 +78,8 @@ func LoadRepoFile(file string) (*bazeldnf.Repositories, error) {
 	return repos, err
 }
 
+// LoadRepoFiles reads the repository definitions of all files and expands
+// variables like $releasever in them with the values configured via flags.
 func LoadRepoFiles(files []string) (*bazeldnf.Repositories, error) {
 	repos := &bazeldnf.Repositories{}
 	for i, _ := range files {
@@ -82,5 +89,12 @@ func LoadRepoFiles(files []string) (*bazeldnf.Repositories, error) {
 		}
 		repos.Repositories = append(repos.Repositories, tmp.Repositories...)
 	}
+	vars, err := repoVarsValues.Vars()
+	if err != nil {
+		return nil, err
+	}
+	if err := ExpandRepoVars(repos, vars); err != nil {
+		return nil, err
+	}
 	return repos, nil
 }
@@ -0,0 +1,154 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+	"github.com/spf13/cobra"
+)
+
+type offlineOpts struct {
+	offline bool
+}
+
+var offlineValues = offlineOpts{}
+
+// ErrOffline is returned for every attempt to access the network in offline
+// mode
+var ErrOffline = errors.New("network access is disabled in offline mode")
+
+// AddOfflineFlags registers the --offline flag for the command and all its
+// subcommands
+func AddOfflineFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVar(&offlineValues.offline, "offline", false, "never access the network and only work with cached metadata and local files")
+}
+
+// IsOffline reports whether network access is disabled
+func IsOffline() bool {
+	return offlineValues.offline
+}
+
+// MissingMetadata lists the files of a repository which are missing in the
+// cache
+type MissingMetadata struct {
+	Repository *bazeldnf.Repository
+	Files      []string
+}
+
+// MissingMetadataError reports all repositories with incomplete cached
+// metadata at once
+type MissingMetadataError struct {
+	Missing []MissingMetadata
+	// FetchCommand is the fetch invocation which adds the missing metadata to
+	// the cache, if known
+	FetchCommand string
+}
+
+func (e *MissingMetadataError) Error() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "the cache is missing metadata of %d repositories:", len(e.Missing))
+	for _, missing := range e.Missing {
+		fmt.Fprintf(b, "\n  %s (%s): %s", missing.Repository.Name, missing.Repository.Arch, strings.Join(missing.Files, ", "))
+	}
+	if e.FetchCommand != "" {
+		fmt.Fprintf(b, "\nrun the following command to fetch it:\n  %s", e.FetchCommand)
+	} else {
+		fmt.Fprintf(b, "\nrun bazeldnf fetch to fetch it")
+	}
+	return b.String()
+}
+
+// CheckMetadata verifies that all metadata needed to load the packages of the
+// repositories is cached, and the filelists too if requested. If anything is
+// missing, a MissingMetadataError lists all missing files.
+func (r *CacheHelper) CheckMetadata(repos []*bazeldnf.Repository, filelists bool) error {
+	report := &MissingMetadataError{}
+	for _, repo := range repos {
+		if files := r.missingMetadata(repo, filelists); len(files) > 0 {
+			report.Missing = append(report.Missing, MissingMetadata{Repository: repo, Files: files})
+		}
+	}
+	if len(report.Missing) > 0 {
+		return report
+	}
+	return nil
+}
+
+func (r *CacheHelper) missingMetadata(repo *bazeldnf.Repository, filelists bool) []string {
+	missing := []string{}
+	isCached := func(name string) bool {
+		_, err := os.Stat(filepath.Join(r.repoDir(repo), name))
+		return err == nil
+	}
+	// the mirrors of packages are taken from the metalink or the mirrorlist
+	if len(repo.Mirrors) == 0 && repo.Metalink != "" && !isCached("metalink") {
+		missing = append(missing, "metalink")
+	} else if len(repo.Mirrors) == 0 && repo.Metalink == "" && repo.Mirrorlist != "" && !isCached("mirrorlist") {
+		missing = append(missing, "mirrorlist")
+	}
+	repomd, err := r.LoadRepomd(repo)
+	if err != nil {
+		return append(missing, "repomd.xml")
+	}
+	fileTypes := []string{api.PrimaryFileType}
+	if filelists {
+		fileTypes = append(fileTypes, api.FilelistsFileType)
+	}
+	for _, fileType := range fileTypes {
+		file := metadataFile(repomd, fileType)
+		if file == nil {
+			continue
+		}
+		if name := filepath.Base(file.Location.Href); !isCached(name) {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// FetchCommand returns the fetch invocation which fills the cache for the
+// given repository files, with the variables and the cache directory of the
+// current invocation. basearch is the $basearch the repository files were
+// expanded with, so that the fetched metadata ends up in the same cache
+// directories.
+func FetchCommand(repofiles []string, filelists bool, basearch string) string {
+	args := []string{"bazeldnf", "fetch"}
+	for _, repofile := range repofiles {
+		args = append(args, "--repofile", repofile)
+	}
+	if repoVarsValues.releasever != "" {
+		args = append(args, "--releasever", repoVarsValues.releasever)
+	}
+	if basearch != "" {
+		args = append(args, "--basearch", basearch)
+	}
+	names := []string{}
+	for name := range repoVarsValues.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "--var", name+"="+repoVarsValues.vars[name])
+	}
+	for _, file := range repoVarsValues.varsFiles {
+		args = append(args, "--vars-file", file)
+	}
+	if filelists {
+		args = append(args, "--filelists")
+	}
+	if cacheHelperValues.cacheDir != "" && cacheHelperValues.cacheDir != defaultCacheDir() {
+		args = append(args, "--cache-dir", cacheHelperValues.cacheDir)
+	}
+	for i, arg := range args {
+		if strings.ContainsAny(arg, " \t\"'$\\&;|<>()*?") {
+			args[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(args, " ")
+}
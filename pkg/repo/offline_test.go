@@ -0,0 +1,112 @@
+package repo
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+func setOffline(t *testing.T) {
+	t.Helper()
+	offlineValues.offline = true
+	t.Cleanup(func() { offlineValues.offline = false })
+}
+
+func TestCheckMetadata(t *testing.T) {
+	helper := &CacheHelper{cacheDir: t.TempDir()}
+	cached := &bazeldnf.Repository{Name: "cached", Baseurl: "http://localhost/cached/", Arch: "x86_64"}
+	writeTestPrimary(t, helper, cached, "1111")
+	partial := &bazeldnf.Repository{Name: "partial", Baseurl: "http://localhost/partial/", Arch: "x86_64"}
+	writeTestPrimary(t, helper, partial, "2222")
+	if err := os.Remove(filepath.Join(helper.repoDir(partial), "primary.xml.gz")); err != nil {
+		t.Fatalf("removing primary.xml: %v", err)
+	}
+	uncached := &bazeldnf.Repository{Name: "uncached", Metalink: "http://localhost/metalink", Arch: "x86_64"}
+
+	if err := helper.CheckMetadata([]*bazeldnf.Repository{cached}, false); err != nil {
+		t.Fatalf("expected complete metadata, got %v", err)
+	}
+	if err := helper.CheckMetadata([]*bazeldnf.Repository{cached}, true); err != nil {
+		t.Fatalf("expected no filelists to be required if the repository has none, got %v", err)
+	}
+
+	err := helper.CheckMetadata([]*bazeldnf.Repository{cached, partial, uncached}, false)
+	var missing *MissingMetadataError
+	if !errors.As(err, &missing) {
+		t.Fatalf("expected a MissingMetadataError, got %v", err)
+	}
+	expected := []MissingMetadata{
+		{Repository: partial, Files: []string{"primary.xml.gz"}},
+		{Repository: uncached, Files: []string{"metalink", "repomd.xml"}},
+	}
+	if !reflect.DeepEqual(missing.Missing, expected) {
+		t.Fatalf("expected %v to be missing, got %v", expected, missing.Missing)
+	}
+	missing.FetchCommand = "bazeldnf fetch --repofile repo.yaml"
+	for _, part := range []string{"partial (x86_64): primary.xml.gz", "uncached (x86_64): metalink, repomd.xml", "bazeldnf fetch --repofile repo.yaml"} {
+		if !strings.Contains(missing.Error(), part) {
+			t.Fatalf("expected %q in the report, got:\n%s", part, missing.Error())
+		}
+	}
+}
+
+func TestOfflineFetch(t *testing.T) {
+	setOffline(t)
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer s.Close()
+
+	helper := &CacheHelper{cacheDir: t.TempDir()}
+	repos := []bazeldnf.Repository{{Name: "repo", Baseurl: s.URL + "/", Arch: "x86_64"}}
+	fetcher := &RepoFetcherImpl{Getter: &getterImpl{}, Repos: repos, CacheHelper: helper, Offline: true}
+	var missing *MissingMetadataError
+	if err := fetcher.Fetch(); !errors.As(err, &missing) {
+		t.Fatalf("expected a MissingMetadataError, got %v", err)
+	}
+
+	writeTestPrimary(t, helper, &repos[0], "1111")
+	if err := fetcher.Fetch(); err != nil {
+		t.Fatalf("expected the cached metadata to be accepted, got %v", err)
+	}
+
+	if _, err := fetcher.Getter.Get(s.URL + "/repodata/repomd.xml"); !errors.Is(err, ErrOffline) {
+		t.Fatalf("expected downloads to be refused, got %v", err)
+	}
+	if requests != 0 {
+		t.Fatalf("expected no requests in offline mode, got %d", requests)
+	}
+
+	local := filepath.Join(t.TempDir(), "RPM-GPG-KEY")
+	if err := os.WriteFile(local, []byte("key"), 0644); err != nil {
+		t.Fatalf("writing local file: %v", err)
+	}
+	resp, err := fetcher.Getter.Get("file://" + local)
+	if err != nil {
+		t.Fatalf("expected local files to be readable in offline mode, got %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestFetchCommand(t *testing.T) {
+	previousVars, previousCacheDir := repoVarsValues, cacheHelperValues.cacheDir
+	defer func() {
+		repoVarsValues, cacheHelperValues.cacheDir = previousVars, previousCacheDir
+	}()
+	repoVarsValues = repoVarsOpts{releasever: "41", vars: map[string]string{"stream": "9", "contentdir": "pub/centos"}}
+	cacheHelperValues.cacheDir = "/tmp/my cache"
+
+	command := FetchCommand([]string{"repo.yaml", "extra.repo"}, true, "aarch64")
+	expected := "bazeldnf fetch --repofile repo.yaml --repofile extra.repo --releasever 41 --basearch aarch64 --var contentdir=pub/centos --var stream=9 --filelists --cache-dir '/tmp/my cache'"
+	if command != expected {
+		t.Fatalf("expected %q, got %q", expected, command)
+	}
+}
@@ -0,0 +1,316 @@
+package repo
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+// isSqliteMetadata checks if the metadata file is a sqlite database, like
+// primary_db or filelists_db, instead of xml
+func isSqliteMetadata(file *api.Data) bool {
+	return strings.HasSuffix(file.Type, "_db")
+}
+
+// openSqliteFromRepoDir opens a cached sqlite metadata file, see openSqlite.
+// The returned function releases all resources again.
+func (r *CacheHelper) openSqliteFromRepoDir(repo *bazeldnf.Repository, file *api.Data) (*sqliteDB, func(), error) {
+	name := filepath.Base(file.Location.Href)
+	f, err := os.Open(filepath.Join(r.repoDir(repo), name))
+	if err != nil {
+		return nil, nil, err
+	}
+	db, cleanup, err := r.openSqlite(name, f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return db, func() {
+		cleanup()
+		f.Close()
+	}, nil
+}
+
+// openSqlite opens the sqlite metadata file f. Compressed databases are
+// decompressed into a temporary file first, since sqlite needs random access.
+// The returned function releases all resources besides f again.
+func (r *CacheHelper) openSqlite(name string, f *os.File) (*sqliteDB, func(), error) {
+	if strings.HasSuffix(name, ".sqlite") {
+		db, err := openSqliteDB(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open %s: %v", name, err)
+		}
+		return db, func() {}, nil
+	}
+
+	reader, err := r.getCompressFileReader(name, f)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+	tmp, err := os.CreateTemp("", "bazeldnf-*.sqlite")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	if _, err := io.Copy(tmp, reader); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to decompress %s: %v", name, err)
+	}
+	db, err := openSqliteDB(tmp)
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to open %s: %v", name, err)
+	}
+	return db, cleanup, nil
+}
+
+// walkPrimaryDB reads the packages from a cached primary_db. The
+// dependencies and files of the packages are stored in separate tables, which
+// are grouped by package before the packages are assembled one at a time.
+func (r *CacheHelper) walkPrimaryDB(repo *bazeldnf.Repository, primary *api.Data, fn func(pkg *api.Package) error) error {
+	db, cleanup, err := r.openSqliteFromRepoDir(repo, primary)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	dependencies := map[string]func(pkg *api.Package) *api.Dependencies{
+		"provides":    func(pkg *api.Package) *api.Dependencies { return &pkg.Format.Provides },
+		"requires":    func(pkg *api.Package) *api.Dependencies { return &pkg.Format.Requires },
+		"conflicts":   func(pkg *api.Package) *api.Dependencies { return &pkg.Format.Conflicts },
+		"obsoletes":   func(pkg *api.Package) *api.Dependencies { return &pkg.Format.Obsoletes },
+		"recommends":  func(pkg *api.Package) *api.Dependencies { return &pkg.Format.Recommends },
+		"suggests":    func(pkg *api.Package) *api.Dependencies { return &pkg.Format.Suggests },
+		"enhances":    func(pkg *api.Package) *api.Dependencies { return &pkg.Format.Enhances },
+		"supplements": func(pkg *api.Package) *api.Dependencies { return &pkg.Format.Supplements },
+	}
+	dependencyEntries := map[string]map[int64][]api.Entry{}
+	for table := range dependencies {
+		if !db.hasTable(table) {
+			continue
+		}
+		dependencyEntries[table], err = groupByPackage(db, table, func(row *sqliteRow) api.Entry {
+			return api.Entry{
+				Name:  row.String("name"),
+				Flags: row.String("flags"),
+				Epoch: row.String("epoch"),
+				Ver:   row.String("version"),
+				Rel:   row.String("release"),
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read %s of %s: %v", table, repo.Name, err)
+		}
+	}
+	files := map[int64][]api.ProvidedFile{}
+	if db.hasTable("files") {
+		files, err = groupByPackage(db, "files", func(row *sqliteRow) api.ProvidedFile {
+			return api.ProvidedFile{
+				Text: row.String("name"),
+				Type: xmlFileType(row.String("type")),
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read files of %s: %v", repo.Name, err)
+		}
+	}
+
+	packages, err := db.packageCursor()
+	if err != nil {
+		return fmt.Errorf("failed to read packages of %s: %v", repo.Name, err)
+	}
+	for {
+		row, err := packages.next()
+		if err != nil {
+			return fmt.Errorf("failed to read packages of %s: %v", repo.Name, err)
+		} else if row == nil {
+			return nil
+		}
+		pkg := packageFromRow(row)
+		key := row.Int("pkgKey")
+		for table, entries := range dependencyEntries {
+			dependencies[table](pkg).Entries = entries[key]
+			delete(entries, key)
+		}
+		pkg.Format.Files = files[key]
+		delete(files, key)
+		if err := fn(pkg); err != nil {
+			return err
+		}
+	}
+}
+
+// packageFromRow creates a package from a row of the packages table of a
+// primary_db, without its dependencies and files
+func packageFromRow(row *sqliteRow) *api.Package {
+	pkg := &api.Package{
+		Type:        "rpm",
+		Name:        row.String("name"),
+		Arch:        row.String("arch"),
+		Summary:     row.String("summary"),
+		Description: row.String("description"),
+		Packager:    row.String("rpm_packager"),
+		URL:         row.String("url"),
+	}
+	pkg.Version = api.Version{
+		Epoch: row.String("epoch"),
+		Ver:   row.String("version"),
+		Rel:   row.String("release"),
+	}
+	pkg.Checksum = api.Checksum{
+		Text:  row.String("pkgId"),
+		Type:  row.String("checksum_type"),
+		Pkgid: "YES",
+	}
+	pkg.Time.File = row.String("time_file")
+	pkg.Time.Build = row.String("time_build")
+	pkg.Size.Package = int(row.Int("size_package"))
+	pkg.Size.Installed = int(row.Int("size_installed"))
+	pkg.Size.Archive = int(row.Int("size_archive"))
+	pkg.Location.Href = row.String("location_href")
+	pkg.Format.License = row.String("rpm_license")
+	pkg.Format.Vendor = row.String("rpm_vendor")
+	pkg.Format.Group = row.String("rpm_group")
+	pkg.Format.Buildhost = row.String("rpm_buildhost")
+	pkg.Format.Sourcerpm = row.String("rpm_sourcerpm")
+	pkg.Format.HeaderRange.Start = row.String("rpm_header_start")
+	pkg.Format.HeaderRange.End = row.String("rpm_header_end")
+	return pkg
+}
+
+// walkFilelistsDB reads the files of all packages from the filelists_db file
+// with the given name, where the files of every directory are stored in a
+// single row. Like walkPrimaryDB it groups the rows by package before
+// assembling the packages. filelists_db only records the pkgId of the
+// packages.
+func (r *CacheHelper) walkFilelistsDB(repo *bazeldnf.Repository, name string, file *os.File, fn func(pkg *api.FileListPackage) error) error {
+	db, cleanup, err := r.openSqlite(name, file)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	files, err := groupByPackage(db, "filelist", func(row *sqliteRow) []api.ProvidedFile {
+		dirname := strings.TrimSuffix(row.String("dirname"), "/")
+		types := row.String("filetypes")
+		files := []api.ProvidedFile{}
+		for i, name := range strings.Split(row.String("filenames"), "/") {
+			fileType := ""
+			if i < len(types) {
+				switch types[i] {
+				case 'd':
+					fileType = "dir"
+				case 'g':
+					fileType = "ghost"
+				}
+			}
+			files = append(files, api.ProvidedFile{Text: dirname + "/" + name, Type: fileType})
+		}
+		return files
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read filelists of %s: %v", repo.Name, err)
+	}
+
+	packages, err := db.packageCursor()
+	if err != nil {
+		return fmt.Errorf("failed to read packages of %s: %v", repo.Name, err)
+	}
+	for {
+		row, err := packages.next()
+		if err != nil {
+			return fmt.Errorf("failed to read packages of %s: %v", repo.Name, err)
+		} else if row == nil {
+			return nil
+		}
+		key := row.Int("pkgKey")
+		pkg := &api.FileListPackage{Pkgid: row.String("pkgId")}
+		for _, dir := range files[key] {
+			pkg.File = append(pkg.File, dir...)
+		}
+		delete(files, key)
+		if err := fn(pkg); err != nil {
+			return err
+		}
+	}
+}
+
+// filelistsDBForPackages looks up the files of the packages in the
+// filelists_db file with the given name. Since filelists_db doesn't record the
+// names and versions of the packages, they are matched by their checksum.
+// Packages which are not found are returned as remaining.
+func (r *CacheHelper) filelistsDBForPackages(repo *bazeldnf.Repository, name string, file *os.File, arches []string, packages []*api.Package) (filelistpkgs []*api.FileListPackage, remaining []*api.Package, err error) {
+	wanted := map[string]*api.Package{}
+	for _, pkg := range packages {
+		if slices.Contains(arches, pkg.Arch) {
+			wanted[pkg.Checksum.Text] = pkg
+		}
+	}
+	found := map[string]*api.FileListPackage{}
+	err = r.walkFilelistsDB(repo, name, file, func(filelist *api.FileListPackage) error {
+		pkg, exists := wanted[filelist.Pkgid]
+		if !exists {
+			return nil
+		}
+		filelist.Name = pkg.Name
+		filelist.Arch = pkg.Arch
+		filelist.Version = pkg.Version
+		found[filelist.Pkgid] = filelist
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, pkg := range packages {
+		if filelist, exists := found[pkg.Checksum.Text]; exists && wanted[pkg.Checksum.Text] == pkg {
+			filelistpkgs = append(filelistpkgs, filelist)
+		} else {
+			remaining = append(remaining, pkg)
+		}
+	}
+	return filelistpkgs, remaining, nil
+}
+
+// groupByPackage reads all rows of a table of rpm-md sqlite metadata and
+// groups them by the pkgKey of their package. SQLite doesn't guarantee any
+// order of the rows, so the rows of a package may be spread over the table.
+func groupByPackage[T any](db *sqliteDB, table string, convert func(row *sqliteRow) T) (map[int64][]T, error) {
+	grouped := map[int64][]T{}
+	err := db.walkTable(table, func(row *sqliteRow) error {
+		key := row.Int("pkgKey")
+		grouped[key] = append(grouped[key], convert(row))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return grouped, nil
+}
+
+// packageCursor returns a cursor over the packages table
+func (db *sqliteDB) packageCursor() (*sqliteCursor, error) {
+	table, exists := db.tables["packages"]
+	if !exists {
+		return nil, fmt.Errorf("table packages does not exist")
+	}
+	return db.cursor(table)
+}
+
+// xmlFileType maps the file types of the files table to the type attribute
+// used in primary.xml, where regular files have no type
+func xmlFileType(fileType string) string {
+	if fileType == "file" {
+		return ""
+	}
+	return fileType
+}
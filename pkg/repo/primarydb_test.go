@@ -0,0 +1,151 @@
+package repo
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+const testRepomdDB = `<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <revision>1</revision>
+  <data type="primary_db">
+    <checksum type="sha256">1111</checksum>
+    <location href="repodata/primary.sqlite.bz2"/>
+    <database_version>10</database_version>
+  </data>
+  <data type="filelists_db">
+    <checksum type="sha256">2222</checksum>
+    <location href="repodata/filelists.sqlite.bz2"/>
+    <database_version>10</database_version>
+  </data>
+</repomd>`
+
+func newTestDBCache(t *testing.T) (*CacheHelper, *bazeldnf.Repository) {
+	t.Helper()
+	helper := &CacheHelper{cacheDir: t.TempDir()}
+	repo := &bazeldnf.Repository{Name: "test", Baseurl: "http://localhost/"}
+	if err := helper.WriteToRepoDir(repo, strings.NewReader(testRepomdDB), "repomd.xml"); err != nil {
+		t.Fatalf("writing repomd.xml: %v", err)
+	}
+	for _, name := range []string{"primary.sqlite.bz2", "filelists.sqlite.bz2"} {
+		f, err := os.Open("testdata/" + name)
+		if err != nil {
+			t.Fatalf("opening testdata: %v", err)
+		}
+		defer f.Close()
+		if err := helper.WriteToRepoDir(repo, f, name); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+	return helper, repo
+}
+
+func TestWalkPrimaryDB(t *testing.T) {
+	helper, repo := newTestDBCache(t)
+
+	fromDB, err := helper.CurrentPrimary(repo)
+	if err != nil {
+		t.Fatalf("loading primary_db: %v", err)
+	}
+	if len(fromDB.Packages) != 502 {
+		t.Fatalf("expected 502 packages, got %d", len(fromDB.Packages))
+	}
+	foo, bar := &fromDB.Packages[0], &fromDB.Packages[1]
+
+	fromXML := &api.Repository{}
+	err = WalkPrimaryXML(strings.NewReader(testIndexPrimary), func(pkg *api.Package) error {
+		fromXML.Packages = append(fromXML.Packages, *pkg)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("loading primary.xml: %v", err)
+	}
+	expected := newIndexedPackage(&fromXML.Packages[0])
+	expected.Files = append(expected.Files, api.ProvidedFile{Text: "/etc/foo", Type: "dir"})
+	if actual := newIndexedPackage(foo); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected package %v, got %v", expected, actual)
+	}
+	if foo.Summary != "foo summary" || len(foo.Description) != 3400 || foo.Time.File != "1700000000" || foo.Repository != repo {
+		t.Fatalf("unexpected package %v", foo)
+	}
+	if bar.String() != "bar-0:1.0-1.noarch (test)" || bar.Size.Package != -1 || bar.Size.Archive != 70000 || len(bar.Format.Provides.Entries) != 0 {
+		t.Fatalf("unexpected package %v", bar)
+	}
+	if pad := fromDB.Packages[501]; pad.Name != "pad502" || pad.Format.Provides.Entries[0].Name != "pad502" {
+		t.Fatalf("unexpected package %v", pad)
+	}
+
+	repomd, err := helper.LoadRepomd(repo)
+	if err != nil {
+		t.Fatalf("loading repomd.xml: %v", err)
+	}
+	if err := helper.WritePrimaryIndex(repo, repomd); err != nil {
+		t.Fatalf("indexing primary_db: %v", err)
+	}
+	if !helper.HasPrimaryIndex(repo, repomd) {
+		t.Fatalf("expected an up to date primary index")
+	}
+}
+
+func TestWalkFilelistsDB(t *testing.T) {
+	helper, repo := newTestDBCache(t)
+
+	packages := []*api.FileListPackage{}
+	err := helper.WalkFilelist(repo, func(pkg *api.FileListPackage) error {
+		packages = append(packages, pkg)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walking filelists_db: %v", err)
+	}
+	expected := []*api.FileListPackage{
+		{Pkgid: "abcd", File: []api.ProvidedFile{{Text: "/usr/bin/foo"}, {Text: "/usr/bin/foo-helper"}, {Text: "/usr/share/foo", Type: "dir"}}},
+		{Pkgid: "efgh"},
+	}
+	if !reflect.DeepEqual(expected, packages) {
+		t.Fatalf("expected %v, got %v", expected, packages)
+	}
+}
+
+func TestCurrentFilelistsForPackagesDB(t *testing.T) {
+	helper, repo := newTestDBCache(t)
+
+	foo := &api.Package{Name: "foo", Arch: "x86_64", Version: api.Version{Ver: "1"}, Checksum: api.Checksum{Text: "abcd"}}
+	missing := &api.Package{Name: "missing", Arch: "x86_64", Version: api.Version{Ver: "1"}, Checksum: api.Checksum{Text: "ijkl"}}
+	filelists, remaining, err := helper.CurrentFilelistsForPackages(repo, []string{"x86_64"}, []*api.Package{missing, foo})
+	if err != nil {
+		t.Fatalf("looking up filelists: %v", err)
+	}
+	expected := []*api.FileListPackage{
+		{Pkgid: "abcd", Name: "foo", Arch: "x86_64", Version: api.Version{Ver: "1"}, File: []api.ProvidedFile{{Text: "/usr/bin/foo"}, {Text: "/usr/bin/foo-helper"}, {Text: "/usr/share/foo", Type: "dir"}}},
+	}
+	if !reflect.DeepEqual(expected, filelists) {
+		t.Fatalf("expected %v, got %v", expected, filelists)
+	}
+	if !reflect.DeepEqual([]*api.Package{missing}, remaining) {
+		t.Fatalf("expected %v to remain, got %v", missing, remaining)
+	}
+}
+
+func TestSqliteVarint(t *testing.T) {
+	tests := []struct {
+		data     []byte
+		value    uint64
+		consumed int
+	}{
+		{data: []byte{0x05}, value: 5, consumed: 1},
+		{data: []byte{0x81, 0x00}, value: 128, consumed: 2},
+		{data: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, value: 0xffffffffffffffff, consumed: 9},
+		{data: []byte{0x81}, value: 0, consumed: 0},
+	}
+	for _, tt := range tests {
+		value, consumed := sqliteVarint(tt.data)
+		if value != tt.value || consumed != tt.consumed {
+			t.Errorf("sqliteVarint(%x) = %d, %d, expected %d, %d", tt.data, value, consumed, tt.value, tt.consumed)
+		}
+	}
+}
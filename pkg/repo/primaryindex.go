@@ -0,0 +1,183 @@
+package repo
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+const (
+	primaryIndexName = "primary.index"
+	// primaryIndexVersion has to be increased whenever the layout of
+	// indexedPackage changes, so that outdated indexes get rebuilt.
+	primaryIndexVersion = 1
+)
+
+// errNoPrimaryIndex indicates that there is no usable index for the current
+// primary.xml in the cache
+var errNoPrimaryIndex = errors.New("no valid primary index")
+
+// primaryIndexHeader is stored in front of the packages of a primary index
+// and binds the index to the primary.xml it was created from.
+type primaryIndexHeader struct {
+	Version  int
+	Checksum string
+}
+
+// indexedPackage holds the subset of the fields of a package which is
+// required for dependency resolution and for rendering rpm rules.
+type indexedPackage struct {
+	Type        string
+	Name        string
+	Arch        string
+	Version     api.Version
+	Checksum    api.Checksum
+	Location    api.Location
+	Size        [3]int
+	Provides    []api.Entry
+	Requires    []api.Entry
+	Files       []api.ProvidedFile
+	Conflicts   []api.Entry
+	Obsoletes   []api.Entry
+	Recommends  []api.Entry
+	Suggests    []api.Entry
+	Enhances    []api.Entry
+	Supplements []api.Entry
+}
+
+func newIndexedPackage(pkg *api.Package) *indexedPackage {
+	return &indexedPackage{
+		Type:        pkg.Type,
+		Name:        pkg.Name,
+		Arch:        pkg.Arch,
+		Version:     pkg.Version,
+		Checksum:    pkg.Checksum,
+		Location:    pkg.Location,
+		Size:        [3]int{pkg.Size.Package, pkg.Size.Installed, pkg.Size.Archive},
+		Provides:    pkg.Format.Provides.Entries,
+		Requires:    pkg.Format.Requires.Entries,
+		Files:       pkg.Format.Files,
+		Conflicts:   pkg.Format.Conflicts.Entries,
+		Obsoletes:   pkg.Format.Obsoletes.Entries,
+		Recommends:  pkg.Format.Recommends.Entries,
+		Suggests:    pkg.Format.Suggests.Entries,
+		Enhances:    pkg.Format.Enhances.Entries,
+		Supplements: pkg.Format.Supplements.Entries,
+	}
+}
+
+func (p *indexedPackage) toPackage(pkg *api.Package) {
+	pkg.Type = p.Type
+	pkg.Name = p.Name
+	pkg.Arch = p.Arch
+	pkg.Version = p.Version
+	pkg.Checksum = p.Checksum
+	pkg.Location = p.Location
+	pkg.Size.Package, pkg.Size.Installed, pkg.Size.Archive = p.Size[0], p.Size[1], p.Size[2]
+	pkg.Format.Provides.Entries = p.Provides
+	pkg.Format.Requires.Entries = p.Requires
+	pkg.Format.Files = p.Files
+	pkg.Format.Conflicts.Entries = p.Conflicts
+	pkg.Format.Obsoletes.Entries = p.Obsoletes
+	pkg.Format.Recommends.Entries = p.Recommends
+	pkg.Format.Suggests.Entries = p.Suggests
+	pkg.Format.Enhances.Entries = p.Enhances
+	pkg.Format.Supplements.Entries = p.Supplements
+}
+
+// primaryIndexChecksum identifies the primary.xml an index belongs to
+func primaryIndexChecksum(primary *api.Data) string {
+	return primary.Checksum.Type + ":" + primary.Checksum.Text
+}
+
+// WritePrimaryIndex creates a binary index of the packages in the cached
+// primary metadata of the repository, which is much faster to load than the xml.
+func (r *CacheHelper) WritePrimaryIndex(repo *bazeldnf.Repository, repomd *api.Repomd) error {
+	primary := metadataFile(repomd, api.PrimaryFileType)
+	if primary == nil {
+		return fmt.Errorf("no primary file referenced in repomd.xml")
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		encoder := gob.NewEncoder(writer)
+		header := &primaryIndexHeader{
+			Version:  primaryIndexVersion,
+			Checksum: primaryIndexChecksum(primary),
+		}
+		if err := encoder.Encode(header); err != nil {
+			writer.CloseWithError(err)
+			return
+		}
+		writer.CloseWithError(r.walkPrimaryFile(repo, primary, func(pkg *api.Package) error {
+			return encoder.Encode(newIndexedPackage(pkg))
+		}))
+	}()
+	defer reader.Close()
+	return r.WriteToRepoDir(repo, reader, primaryIndexName)
+}
+
+// HasPrimaryIndex checks if the cache holds an up to date index for the
+// primary.xml referenced in repomd.
+func (r *CacheHelper) HasPrimaryIndex(repo *bazeldnf.Repository, repomd *api.Repomd) bool {
+	primary := metadataFile(repomd, api.PrimaryFileType)
+	if primary == nil {
+		return false
+	}
+	f, err := r.OpenFromRepoDir(repo, primaryIndexName)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	_, err = decodePrimaryIndexHeader(gob.NewDecoder(f), primaryIndexChecksum(primary))
+	return err == nil
+}
+
+// walkPrimaryIndex streams the packages from the binary index. If the index
+// is missing, was created by a different bazeldnf version or belongs to a
+// different primary.xml, errNoPrimaryIndex is returned.
+func (r *CacheHelper) walkPrimaryIndex(repo *bazeldnf.Repository, primary *api.Data, fn func(pkg *api.Package) error) error {
+	f, err := os.Open(filepath.Join(r.repoDir(repo), primaryIndexName))
+	if os.IsNotExist(err) {
+		return errNoPrimaryIndex
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := gob.NewDecoder(bufio.NewReader(f))
+	if _, err := decodePrimaryIndexHeader(decoder, primaryIndexChecksum(primary)); err != nil {
+		return err
+	}
+	for {
+		indexed := indexedPackage{}
+		if err := decoder.Decode(&indexed); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read primary index of %s: %v", repo.Name, err)
+		}
+		pkg := &api.Package{}
+		indexed.toPackage(pkg)
+		if err := fn(pkg); err != nil {
+			return err
+		}
+	}
+}
+
+func decodePrimaryIndexHeader(decoder *gob.Decoder, checksum string) (*primaryIndexHeader, error) {
+	header := &primaryIndexHeader{}
+	if err := decoder.Decode(header); err != nil {
+		return nil, errNoPrimaryIndex
+	}
+	if header.Version != primaryIndexVersion || header.Checksum != checksum {
+		return nil, errNoPrimaryIndex
+	}
+	return header, nil
+}
@@ -0,0 +1,156 @@
+package repo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+const testIndexPrimary = `<?xml version="1.0" encoding="UTF-8"?>
+<metadata xmlns="http://linux.duke.edu/metadata/common" xmlns:rpm="http://linux.duke.edu/metadata/rpm" packages="2">
+<package type="rpm">
+  <name>foo</name>
+  <arch>x86_64</arch>
+  <version epoch="1" ver="2.0" rel="3.fc40"/>
+  <checksum type="sha256" pkgid="YES">abcd</checksum>
+  <summary>not indexed</summary>
+  <size package="100" installed="200" archive="300"/>
+  <location href="Packages/f/foo-2.0-3.fc40.x86_64.rpm"/>
+  <format>
+    <rpm:provides>
+      <rpm:entry name="foo" flags="EQ" epoch="1" ver="2.0" rel="3.fc40"/>
+    </rpm:provides>
+    <rpm:requires>
+      <rpm:entry name="bar" flags="GE" epoch="0" ver="1.0"/>
+    </rpm:requires>
+    <rpm:recommends>
+      <rpm:entry name="baz"/>
+    </rpm:recommends>
+    <file>/usr/bin/foo</file>
+  </format>
+</package>
+<package type="rpm">
+  <name>bar</name>
+  <arch>noarch</arch>
+  <version epoch="0" ver="1.0" rel="1"/>
+</package>
+</metadata>
+`
+
+func writeTestPrimary(t *testing.T, helper *CacheHelper, repo *bazeldnf.Repository, checksum string) *api.Repomd {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write([]byte(testIndexPrimary)); err != nil {
+		t.Fatalf("compressing primary.xml: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("compressing primary.xml: %v", err)
+	}
+	if err := helper.WriteToRepoDir(repo, buf, "primary.xml.gz"); err != nil {
+		t.Fatalf("writing primary.xml: %v", err)
+	}
+	repomd := `<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <revision>1</revision>
+  <data type="primary">
+    <checksum type="sha256">` + checksum + `</checksum>
+    <location href="repodata/primary.xml.gz"/>
+  </data>
+</repomd>`
+	if err := helper.WriteToRepoDir(repo, strings.NewReader(repomd), "repomd.xml"); err != nil {
+		t.Fatalf("writing repomd.xml: %v", err)
+	}
+	loaded, err := helper.LoadRepomd(repo)
+	if err != nil {
+		t.Fatalf("loading repomd.xml: %v", err)
+	}
+	return loaded
+}
+
+func TestPrimaryIndex(t *testing.T) {
+	helper := &CacheHelper{cacheDir: t.TempDir()}
+	repo := &bazeldnf.Repository{Name: "test", Baseurl: "http://localhost/"}
+	repomd := writeTestPrimary(t, helper, repo, "1111")
+
+	if helper.HasPrimaryIndex(repo, repomd) {
+		t.Fatalf("expected no primary index before it was written")
+	}
+	fromXML, err := helper.CurrentPrimary(repo)
+	if err != nil {
+		t.Fatalf("loading primary.xml: %v", err)
+	}
+	if err := helper.WritePrimaryIndex(repo, repomd); err != nil {
+		t.Fatalf("writing primary index: %v", err)
+	}
+	if !helper.HasPrimaryIndex(repo, repomd) {
+		t.Fatalf("expected an up to date primary index")
+	}
+	fromIndex := &api.Repository{}
+	err = helper.walkPrimaryIndex(repo, repomd.File(api.PrimaryFileType), func(pkg *api.Package) error {
+		fromIndex.Packages = append(fromIndex.Packages, *pkg)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("loading primary index: %v", err)
+	}
+	if len(fromIndex.Packages) != len(fromXML.Packages) {
+		t.Fatalf("expected %d packages, got %d", len(fromXML.Packages), len(fromIndex.Packages))
+	}
+	for i := range fromXML.Packages {
+		expected := newIndexedPackage(&fromXML.Packages[i])
+		actual := newIndexedPackage(&fromIndex.Packages[i])
+		if !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("expected package %v, got %v", expected, actual)
+		}
+	}
+	if fromIndex.Packages[0].Summary != "" {
+		t.Fatalf("expected the summary to not be indexed")
+	}
+
+	current, err := helper.CurrentPrimary(repo)
+	if err != nil {
+		t.Fatalf("loading primary: %v", err)
+	}
+	if current.Packages[0].Repository != repo || current.Packages[0].Size.Archive != 300 {
+		t.Fatalf("unexpected package loaded from the primary index: %v", current.Packages[0])
+	}
+}
+
+func TestPrimaryIndexOutdated(t *testing.T) {
+	helper := &CacheHelper{cacheDir: t.TempDir()}
+	repo := &bazeldnf.Repository{Name: "test"}
+	repomd := writeTestPrimary(t, helper, repo, "1111")
+	if err := helper.WritePrimaryIndex(repo, repomd); err != nil {
+		t.Fatalf("writing primary index: %v", err)
+	}
+
+	repomd = writeTestPrimary(t, helper, repo, "2222")
+	if helper.HasPrimaryIndex(repo, repomd) {
+		t.Fatalf("expected the primary index to be outdated")
+	}
+	noop := func(*api.Package) error { return nil }
+	if err := helper.walkPrimaryIndex(repo, repomd.File(api.PrimaryFileType), noop); err != errNoPrimaryIndex {
+		t.Fatalf("expected errNoPrimaryIndex, got %v", err)
+	}
+	primary, err := helper.CurrentPrimary(repo)
+	if err != nil {
+		t.Fatalf("loading primary: %v", err)
+	}
+	if len(primary.Packages) != 2 {
+		t.Fatalf("expected 2 packages, got %d", len(primary.Packages))
+	}
+
+	if err := os.WriteFile(filepath.Join(helper.repoDir(repo), primaryIndexName), []byte("garbage"), 0660); err != nil {
+		t.Fatalf("corrupting primary index: %v", err)
+	}
+	if helper.HasPrimaryIndex(repo, repomd) {
+		t.Fatalf("expected a corrupted primary index to be ignored")
+	}
+}
@@ -0,0 +1,472 @@
+package repo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// sqliteDB is a minimal read-only reader for sqlite3 database files. It only
+// supports walking over the rows of tables, which is all that is needed to
+// read the primary_db and filelists_db metadata of rpm-md repositories
+// without depending on cgo. See https://www.sqlite.org/fileformat.html for
+// the file format.
+type sqliteDB struct {
+	file       io.ReaderAt
+	pageSize   int
+	usableSize int
+	tables     map[string]*sqliteTable
+}
+
+type sqliteTable struct {
+	name     string
+	rootPage uint32
+	columns  map[string]int
+	// rowidColumn is the column which is an alias for the rowid, or -1
+	rowidColumn int
+}
+
+// sqliteRow holds the values of a table row, which are nil, int64, float64,
+// string or []byte
+type sqliteRow struct {
+	table  *sqliteTable
+	values []interface{}
+}
+
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+const (
+	sqliteInteriorTablePage = 0x05
+	sqliteLeafTablePage     = 0x0d
+	sqliteMaxDepth          = 64
+)
+
+func openSqliteDB(file io.ReaderAt) (*sqliteDB, error) {
+	header := make([]byte, 100)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("failed to read sqlite header: %v", err)
+	}
+	if !bytes.Equal(header[:16], sqliteMagic) {
+		return nil, fmt.Errorf("not a sqlite3 database")
+	}
+	if encoding := binary.BigEndian.Uint32(header[56:]); encoding != 1 && encoding != 0 {
+		return nil, fmt.Errorf("unsupported sqlite text encoding %d", encoding)
+	}
+	db := &sqliteDB{
+		file:     file,
+		pageSize: int(binary.BigEndian.Uint16(header[16:])),
+		tables:   map[string]*sqliteTable{},
+	}
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	db.usableSize = db.pageSize - int(header[20])
+	if db.pageSize < 512 || db.usableSize < 480 {
+		return nil, fmt.Errorf("invalid sqlite page size %d", db.pageSize)
+	}
+
+	// the schema table is always rooted at the first page
+	schema := &sqliteTable{
+		name:        "sqlite_schema",
+		rootPage:    1,
+		columns:     map[string]int{"type": 0, "name": 1, "tbl_name": 2, "rootpage": 3, "sql": 4},
+		rowidColumn: -1,
+	}
+	err := db.walk(schema, func(row *sqliteRow) error {
+		if row.String("type") != "table" {
+			return nil
+		}
+		table, err := parseSqliteTable(row.String("name"), row.String("sql"))
+		if err != nil {
+			return err
+		}
+		table.rootPage = uint32(row.Int("rootpage"))
+		db.tables[table.name] = table
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sqlite schema: %v", err)
+	}
+	return db, nil
+}
+
+// parseSqliteTable extracts the column names from a CREATE TABLE statement
+func parseSqliteTable(name string, sql string) (*sqliteTable, error) {
+	start := strings.Index(sql, "(")
+	end := strings.LastIndex(sql, ")")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("failed to parse definition of table %s", name)
+	}
+	table := &sqliteTable{name: name, columns: map[string]int{}, rowidColumn: -1}
+	depth := 0
+	definitions := []string{}
+	current := strings.Builder{}
+	for _, c := range sql[start+1 : end] {
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			definitions = append(definitions, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteRune(c)
+	}
+	definitions = append(definitions, current.String())
+
+	for _, definition := range definitions {
+		fields := strings.Fields(definition)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "PRIMARY", "UNIQUE", "CHECK", "FOREIGN", "CONSTRAINT":
+			continue
+		}
+		column := strings.Trim(fields[0], "\"`[]'")
+		upper := strings.ToUpper(strings.Join(fields[1:], " "))
+		if strings.HasPrefix(upper, "INTEGER PRIMARY KEY") {
+			table.rowidColumn = len(table.columns)
+		}
+		table.columns[column] = len(table.columns)
+	}
+	return table, nil
+}
+
+// walkTable calls fn for every row of the table
+func (db *sqliteDB) walkTable(name string, fn func(row *sqliteRow) error) error {
+	table, exists := db.tables[name]
+	if !exists {
+		return fmt.Errorf("table %s does not exist", name)
+	}
+	return db.walk(table, fn)
+}
+
+func (db *sqliteDB) hasTable(name string) bool {
+	_, exists := db.tables[name]
+	return exists
+}
+
+func (db *sqliteDB) walk(table *sqliteTable, fn func(row *sqliteRow) error) error {
+	cursor, err := db.cursor(table)
+	if err != nil {
+		return err
+	}
+	for {
+		row, err := cursor.next()
+		if err != nil || row == nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+}
+
+func (db *sqliteDB) readPage(number uint32) ([]byte, error) {
+	if number == 0 {
+		return nil, fmt.Errorf("invalid page number 0")
+	}
+	page := make([]byte, db.pageSize)
+	if _, err := db.file.ReadAt(page, int64(number-1)*int64(db.pageSize)); err != nil {
+		return nil, fmt.Errorf("failed to read page %d: %v", number, err)
+	}
+	return page, nil
+}
+
+// sqliteCursor iterates over the rows of a table in rowid order. Only the
+// pages on the path from the root to the current leaf are kept in memory, so
+// that large tables can be read without loading them as a whole.
+type sqliteCursor struct {
+	db    *sqliteDB
+	table *sqliteTable
+	stack []*sqliteCursorPage
+}
+
+// sqliteCursorPage is a b-tree page together with the position of the cursor
+// on it
+type sqliteCursorPage struct {
+	number     uint32
+	data       []byte
+	offset     int
+	headerSize int
+	cells      int
+	// position is the next cell to visit. On interior pages the position
+	// after the last cell refers to the right-most child.
+	position int
+}
+
+func (p *sqliteCursorPage) interior() bool {
+	return p.headerSize == 12
+}
+
+// cursor returns a cursor positioned before the first row of the table
+func (db *sqliteDB) cursor(table *sqliteTable) (*sqliteCursor, error) {
+	cursor := &sqliteCursor{db: db, table: table}
+	if err := cursor.push(table.rootPage); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+// push descends into the table b-tree page with the given number
+func (c *sqliteCursor) push(number uint32) error {
+	if len(c.stack) > sqliteMaxDepth {
+		return fmt.Errorf("table b-tree is too deep")
+	}
+	data, err := c.db.readPage(number)
+	if err != nil {
+		return err
+	}
+	page := &sqliteCursorPage{number: number, data: data, headerSize: 8}
+	if number == 1 {
+		page.offset = 100
+	}
+	if page.offset+12 > len(data) {
+		return fmt.Errorf("page %d is too small", number)
+	}
+	switch data[page.offset] {
+	case sqliteInteriorTablePage:
+		page.headerSize = 12
+	case sqliteLeafTablePage:
+	default:
+		return fmt.Errorf("page %d is not a table page", number)
+	}
+	page.cells = int(binary.BigEndian.Uint16(data[page.offset+3:]))
+	if page.offset+page.headerSize+2*page.cells > len(data) {
+		return fmt.Errorf("page %d has too many cells", number)
+	}
+	c.stack = append(c.stack, page)
+	return nil
+}
+
+// next returns the next row of the table, or nil if all rows were read
+func (c *sqliteCursor) next() (*sqliteRow, error) {
+	for len(c.stack) > 0 {
+		page := c.stack[len(c.stack)-1]
+		last := page.cells - 1
+		if page.interior() {
+			last = page.cells
+		}
+		if page.position > last {
+			c.stack = c.stack[:len(c.stack)-1]
+			continue
+		}
+		i := page.position
+		page.position++
+
+		if page.interior() && i == page.cells {
+			if err := c.push(binary.BigEndian.Uint32(page.data[page.offset+8:])); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		cell := int(binary.BigEndian.Uint16(page.data[page.offset+page.headerSize+2*i:]))
+		if page.interior() {
+			if cell+4 > len(page.data) {
+				return nil, fmt.Errorf("invalid cell offset on page %d", page.number)
+			}
+			if err := c.push(binary.BigEndian.Uint32(page.data[cell:])); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if cell >= len(page.data) {
+			return nil, fmt.Errorf("invalid cell offset on page %d", page.number)
+		}
+		rowid, payload, err := c.db.readLeafCell(page.data, cell)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cell on page %d: %v", page.number, err)
+		}
+		values, err := decodeSqliteRecord(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid record in table %s: %v", c.table.name, err)
+		}
+		if c.table.rowidColumn >= 0 && c.table.rowidColumn < len(values) && values[c.table.rowidColumn] == nil {
+			values[c.table.rowidColumn] = rowid
+		}
+		return &sqliteRow{table: c.table, values: values}, nil
+	}
+	return nil, nil
+}
+
+// readLeafCell returns the rowid and the full payload of a table leaf cell,
+// following overflow pages if necessary
+func (db *sqliteDB) readLeafCell(page []byte, cell int) (int64, []byte, error) {
+	size, n := sqliteVarint(page[cell:])
+	if n == 0 {
+		return 0, nil, fmt.Errorf("truncated payload size")
+	}
+	cell += n
+	rowid, n := sqliteVarint(page[cell:])
+	if n == 0 {
+		return 0, nil, fmt.Errorf("truncated rowid")
+	}
+	cell += n
+
+	payloadSize := int(size)
+	local := payloadSize
+	maxLocal := db.usableSize - 35
+	if payloadSize > maxLocal {
+		minLocal := (db.usableSize-12)*32/255 - 23
+		local = minLocal + (payloadSize-minLocal)%(db.usableSize-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+	if cell+local > len(page) {
+		return 0, nil, fmt.Errorf("payload exceeds page")
+	}
+	payload := make([]byte, 0, payloadSize)
+	payload = append(payload, page[cell:cell+local]...)
+	if local == payloadSize {
+		return int64(rowid), payload, nil
+	}
+	if cell+local+4 > len(page) {
+		return 0, nil, fmt.Errorf("payload exceeds page")
+	}
+	next := binary.BigEndian.Uint32(page[cell+local:])
+	for len(payload) < payloadSize {
+		if next == 0 {
+			return 0, nil, fmt.Errorf("truncated overflow chain")
+		}
+		overflow, err := db.readPage(next)
+		if err != nil {
+			return 0, nil, err
+		}
+		next = binary.BigEndian.Uint32(overflow)
+		chunk := overflow[4:db.usableSize]
+		if remaining := payloadSize - len(payload); len(chunk) > remaining {
+			chunk = chunk[:remaining]
+		}
+		payload = append(payload, chunk...)
+	}
+	return int64(rowid), payload, nil
+}
+
+// sqliteVarint decodes a big endian variable length integer of up to 9
+// bytes. It returns the number of consumed bytes, or 0 if b is too short.
+func sqliteVarint(b []byte) (uint64, int) {
+	var value uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		if i == 8 {
+			return value<<8 | uint64(b[i]), 9
+		}
+		value = value<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return value, i + 1
+		}
+	}
+	return value, 9
+}
+
+func decodeSqliteRecord(payload []byte) ([]interface{}, error) {
+	headerSize, n := sqliteVarint(payload)
+	if n == 0 || int(headerSize) > len(payload) || int(headerSize) < n {
+		return nil, fmt.Errorf("invalid record header")
+	}
+	serialTypes := []uint64{}
+	for pos := n; pos < int(headerSize); {
+		serialType, n := sqliteVarint(payload[pos:headerSize])
+		if n == 0 {
+			return nil, fmt.Errorf("invalid record header")
+		}
+		serialTypes = append(serialTypes, serialType)
+		pos += n
+	}
+
+	values := make([]interface{}, 0, len(serialTypes))
+	data := payload[headerSize:]
+	for _, serialType := range serialTypes {
+		size := 0
+		switch {
+		case serialType <= 4:
+			size = int(serialType)
+		case serialType == 5:
+			size = 6
+		case serialType == 6 || serialType == 7:
+			size = 8
+		case serialType >= 12:
+			size = int(serialType-12) / 2
+		}
+		if size > len(data) {
+			return nil, fmt.Errorf("record exceeds payload")
+		}
+		field := data[:size]
+		data = data[size:]
+
+		switch {
+		case serialType == 0:
+			values = append(values, nil)
+		case serialType <= 6:
+			var value int64
+			for _, b := range field {
+				value = value<<8 | int64(b)
+			}
+			// sign extend
+			if size < 8 && size > 0 && field[0]&0x80 != 0 {
+				value -= 1 << (8 * size)
+			}
+			values = append(values, value)
+		case serialType == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(field)))
+		case serialType == 8:
+			values = append(values, int64(0))
+		case serialType == 9:
+			values = append(values, int64(1))
+		case serialType >= 12 && serialType%2 == 0:
+			values = append(values, append([]byte{}, field...))
+		case serialType >= 13:
+			values = append(values, string(field))
+		default:
+			return nil, fmt.Errorf("invalid serial type %d", serialType)
+		}
+	}
+	return values, nil
+}
+
+func (r *sqliteRow) value(column string) interface{} {
+	i, exists := r.table.columns[column]
+	if !exists || i >= len(r.values) {
+		return nil
+	}
+	return r.values[i]
+}
+
+// String returns the value of the column as text, or "" if it is NULL
+func (r *sqliteRow) String(column string) string {
+	switch value := r.value(column).(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	case int64:
+		return fmt.Sprintf("%d", value)
+	case float64:
+		return fmt.Sprintf("%v", value)
+	}
+	return ""
+}
+
+// Int returns the value of the column as integer, or 0 if it is NULL
+func (r *sqliteRow) Int(column string) int64 {
+	switch value := r.value(column).(type) {
+	case int64:
+		return value
+	case float64:
+		return int64(value)
+	case string:
+		var i int64
+		fmt.Sscanf(value, "%d", &i)
+		return i
+	}
+	return 0
+}
@@ -0,0 +1,182 @@
+package repo
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// loadTestSqlite returns testdata/btree.sqlite.bz2, a database with a page
+// size of 512 bytes, which contains the tables
+//
+//	numbers (id INTEGER PRIMARY KEY, value TEXT, half REAL, negative INTEGER)
+//	blobs (id INTEGER PRIMARY KEY, data BLOB)
+//	requires (pkgKey INTEGER, name TEXT)
+//	unordered (pkgKey INTEGER, name TEXT)
+//
+// numbers holds 3000 rows, so that its b-tree has three levels. The blobs
+// around the maximum local payload size and beyond use overflow pages.
+func loadTestSqlite(t *testing.T) []byte {
+	t.Helper()
+	f, err := os.Open("testdata/btree.sqlite.bz2")
+	if err != nil {
+		t.Fatalf("opening testdata: %v", err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(bzip2.NewReader(f))
+	if err != nil {
+		t.Fatalf("decompressing testdata: %v", err)
+	}
+	return data
+}
+
+func openTestSqlite(t *testing.T, data []byte) *sqliteDB {
+	t.Helper()
+	db, err := openSqliteDB(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("opening sqlite database: %v", err)
+	}
+	return db
+}
+
+func TestSqliteMultiLevelTree(t *testing.T) {
+	db := openTestSqlite(t, loadTestSqlite(t))
+
+	root, err := db.readPage(db.tables["numbers"].rootPage)
+	if err != nil {
+		t.Fatalf("reading root page: %v", err)
+	}
+	child, err := db.readPage(binary.BigEndian.Uint32(root[8:]))
+	if err != nil {
+		t.Fatalf("reading child page: %v", err)
+	}
+	if root[0] != sqliteInteriorTablePage || child[0] != sqliteInteriorTablePage {
+		t.Fatalf("expected a b-tree with at least three levels")
+	}
+
+	rows := 0
+	err = db.walkTable("numbers", func(row *sqliteRow) error {
+		rows++
+		id := int64(rows)
+		if row.Int("id") != id || row.String("value") != fmt.Sprintf("number %d", id) || row.String("half") != fmt.Sprint(float64(id)/2) || row.Int("negative") != -id*1000 {
+			return fmt.Errorf("unexpected row %v, expected id %d", row.values, id)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walking numbers: %v", err)
+	}
+	if rows != 3000 {
+		t.Fatalf("expected 3000 rows, got %d", rows)
+	}
+}
+
+func TestSqliteOverflowPages(t *testing.T) {
+	db := openTestSqlite(t, loadTestSqlite(t))
+
+	sizes := []int{476, 477, 478, 1000, 5000}
+	rows := 0
+	err := db.walkTable("blobs", func(row *sqliteRow) error {
+		id := row.Int("id")
+		expected := make([]byte, sizes[rows])
+		for j := range expected {
+			expected[j] = byte((j*7 + int(id)) % 256)
+		}
+		if data, ok := row.value("data").([]byte); !ok || !bytes.Equal(data, expected) {
+			return fmt.Errorf("unexpected data of blob %d", id)
+		}
+		rows++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walking blobs: %v", err)
+	}
+	if rows != len(sizes) {
+		t.Fatalf("expected %d blobs, got %d", len(sizes), rows)
+	}
+}
+
+func TestGroupByPackage(t *testing.T) {
+	db := openTestSqlite(t, loadTestSqlite(t))
+
+	for _, tc := range []struct {
+		table    string
+		expected string
+	}{
+		{table: "requires", expected: "map[1:[a b] 2:[orphan] 3:[c] 5:[d]]"},
+		// the rows of package 1 are not next to each other
+		{table: "unordered", expected: "map[1:[a b] 3:[c]]"},
+	} {
+		names, err := groupByPackage(db, tc.table, func(row *sqliteRow) string {
+			return row.String("name")
+		})
+		if err != nil {
+			t.Fatalf("reading %s: %v", tc.table, err)
+		}
+		if fmt.Sprint(names) != tc.expected {
+			t.Fatalf("unexpected %s %v, expected %s", tc.table, names, tc.expected)
+		}
+	}
+}
+
+func TestSqliteCorruptDatabase(t *testing.T) {
+	data := loadTestSqlite(t)
+	db := openTestSqlite(t, data)
+	pageSize := db.pageSize
+	numbersRoot := int(db.tables["numbers"].rootPage)
+	page := func(data []byte, number int) []byte {
+		return data[(number-1)*pageSize : number*pageSize]
+	}
+	var overflowPages []int
+	for number := 2; number <= len(data)/pageSize; number++ {
+		if pageType := page(data, number)[0]; pageType != sqliteInteriorTablePage && pageType != sqliteLeafTablePage {
+			overflowPages = append(overflowPages, number)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		table   string
+		corrupt func(data []byte) []byte
+		err     string
+	}{
+		{name: "invalid header", corrupt: func(data []byte) []byte {
+			copy(data, "SQLite format 2")
+			return data
+		}, err: "not a sqlite3 database"},
+		{name: "truncated file", table: "numbers", corrupt: func(data []byte) []byte {
+			return data[:len(data)/2]
+		}, err: "failed to read page"},
+		{name: "cyclic b-tree", table: "numbers", corrupt: func(data []byte) []byte {
+			binary.BigEndian.PutUint32(page(data, numbersRoot)[8:], uint32(numbersRoot))
+			return data
+		}, err: "table b-tree is too deep"},
+		{name: "child is no table page", table: "numbers", corrupt: func(data []byte) []byte {
+			binary.BigEndian.PutUint32(page(data, numbersRoot)[8:], uint32(overflowPages[0]))
+			return data
+		}, err: "is not a table page"},
+		{name: "truncated overflow chain", table: "blobs", corrupt: func(data []byte) []byte {
+			for _, number := range overflowPages {
+				binary.BigEndian.PutUint32(page(data, number), 0)
+			}
+			return data
+		}, err: "truncated overflow chain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corrupted := tt.corrupt(bytes.Clone(data))
+			db, err := openSqliteDB(bytes.NewReader(corrupted))
+			if err == nil {
+				err = db.walkTable(tt.table, func(row *sqliteRow) error { return nil })
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package repo
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+// hasTransportSettings checks if the repository needs a dedicated http
+// transport for its tls or proxy settings
+func hasTransportSettings(repo *bazeldnf.Repository) bool {
+	return repo.SSLCACert != "" || repo.SSLClientCert != "" || repo.SSLClientKey != "" ||
+		!repo.EffectiveSSLVerify() || repo.Proxy != ""
+}
+
+// newTransport creates a http transport which applies the tls and proxy
+// settings of the repository, following the semantics of dnf
+func newTransport(repo *bazeldnf.Repository) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	tlsConfig := &tls.Config{}
+	if repo.SSLCACert != "" {
+		data, err := os.ReadFile(repo.SSLCACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sslcacert: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in sslcacert %s", repo.SSLCACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if repo.SSLClientCert != "" {
+		// like in dnf, the key may be part of the certificate file
+		key := repo.SSLClientKey
+		if key == "" {
+			key = repo.SSLClientCert
+		}
+		cert, err := tls.LoadX509KeyPair(repo.SSLClientCert, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load sslclientcert: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	} else if repo.SSLClientKey != "" {
+		return nil, fmt.Errorf("sslclientkey is set without sslclientcert")
+	}
+	if !repo.EffectiveSSLVerify() {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	switch repo.Proxy {
+	case "":
+		// keep using the proxies from the environment
+	case "_none_":
+		transport.Proxy = nil
+	default:
+		proxyURL, err := url.Parse(repo.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %v", err)
+		}
+		if repo.ProxyUsername != "" {
+			proxyURL.User = url.UserPassword(repo.ProxyUsername, repo.ProxyPassword)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return transport, nil
+}
+
+// forRepository returns a getter which applies the tls and proxy settings of
+// the repository. Without such settings the getter itself is returned.
+func (g *getterImpl) forRepository(repo *bazeldnf.Repository) (*getterImpl, error) {
+	if !hasTransportSettings(repo) {
+		return g, nil
+	}
+	transport, err := newTransport(repo)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tls or proxy settings for %s: %v", repo.Name, err)
+	}
+	client := retryablehttp.NewClient()
+	if g.client != nil {
+		client.RetryMax = g.client.RetryMax
+		client.RetryWaitMin = g.client.RetryWaitMin
+		client.RetryWaitMax = g.client.RetryWaitMax
+		client.Logger = g.client.Logger
+	}
+	client.HTTPClient.Transport = transport
+	return &getterImpl{client: client, credentials: g.credentials}, nil
+}
+
+// NewRepositoryGetter returns a Getter like NewGetter, which additionally
+// applies the tls and proxy settings of the repository.
+func NewRepositoryGetter(repo *bazeldnf.Repository) (Getter, error) {
+	return (&getterImpl{credentials: defaultCredentials()}).forRepository(repo)
+}
@@ -0,0 +1,184 @@
+package repo
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert creates a certificate signed by parent, or a self signed
+// certificate authority if parent is nil
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshalling key: %v", err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeTestFile(t *testing.T, name string, content []byte) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, content, 0600); err != nil {
+		t.Fatalf("writing %s: %v", name, err)
+	}
+	return file
+}
+
+func noRetryGetter() *getterImpl {
+	client := retryablehttp.NewClient()
+	client.RetryMax = 0
+	return &getterImpl{client: client}
+}
+
+func TestRepositoryTLS(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	serverCert := newTestCert(t, "server", ca)
+	clientCert := newTestCert(t, "client", ca)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	s.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.cert.Raw}, PrivateKey: serverCert.key}},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	s.StartTLS()
+	defer s.Close()
+
+	caFile := writeTestFile(t, "ca.pem", ca.certPEM)
+	certFile := writeTestFile(t, "client.pem", clientCert.certPEM)
+	keyFile := writeTestFile(t, "client.key", clientCert.keyPEM)
+	combinedFile := writeTestFile(t, "combined.pem", append(append([]byte{}, clientCert.certPEM...), clientCert.keyPEM...))
+	noVerify := false
+
+	tests := []struct {
+		name    string
+		repo    *bazeldnf.Repository
+		wantErr bool
+	}{
+		{name: "without settings", repo: &bazeldnf.Repository{}, wantErr: true},
+		{name: "without client certificate", repo: &bazeldnf.Repository{SSLCACert: caFile}, wantErr: true},
+		{name: "with ca and client certificate", repo: &bazeldnf.Repository{SSLCACert: caFile, SSLClientCert: certFile, SSLClientKey: keyFile}},
+		{name: "with key in the certificate file", repo: &bazeldnf.Repository{SSLCACert: caFile, SSLClientCert: combinedFile}},
+		{name: "without verification", repo: &bazeldnf.Repository{SSLVerify: &noVerify, SSLClientCert: certFile, SSLClientKey: keyFile}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getter, err := noRetryGetter().forRepository(tt.repo)
+			if err != nil {
+				t.Fatalf("creating getter: %v", err)
+			}
+			resp, err := getter.Get(s.URL)
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("expected the request to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected the request to succeed, got %v", err)
+			}
+			resp.Body.Close()
+		})
+	}
+
+	if _, err := noRetryGetter().forRepository(&bazeldnf.Repository{SSLCACert: keyFile}); err == nil {
+		t.Fatalf("expected an error for a sslcacert without certificates")
+	}
+	if _, err := noRetryGetter().forRepository(&bazeldnf.Repository{SSLClientKey: keyFile}); err == nil {
+		t.Fatalf("expected an error for a sslclientkey without sslclientcert")
+	}
+}
+
+func TestRepositoryProxy(t *testing.T) {
+	var proxied string
+	var proxyAuth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		proxyAuth = r.Header.Get("Proxy-Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	getter, err := noRetryGetter().forRepository(&bazeldnf.Repository{Proxy: proxy.URL, ProxyUsername: "user", ProxyPassword: "secret"})
+	if err != nil {
+		t.Fatalf("creating getter: %v", err)
+	}
+	resp, err := getter.Get("http://repo.example.com/repodata/repomd.xml")
+	if err != nil {
+		t.Fatalf("expected the request to go through the proxy, got %v", err)
+	}
+	resp.Body.Close()
+	if proxied != "http://repo.example.com/repodata/repomd.xml" {
+		t.Fatalf("expected the proxy to receive the request, got %q", proxied)
+	}
+	if proxyAuth != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Fatalf("expected proxy credentials, got %q", proxyAuth)
+	}
+
+	plain := noRetryGetter()
+	if got, err := plain.forRepository(&bazeldnf.Repository{Name: "plain"}); err != nil || got != plain {
+		t.Fatalf("expected repositories without settings to share the getter")
+	}
+}
@@ -0,0 +1,201 @@
+package repo
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+	"github.com/spf13/cobra"
+)
+
+type repoVarsOpts struct {
+	releasever string
+	basearch   string
+	vars       map[string]string
+	varsFiles  []string
+}
+
+var repoVarsValues = repoVarsOpts{}
+
+// AddRepoVarsFlags registers the flags which provide the values for variables
+// like $releasever and $basearch in repository definitions
+func AddRepoVarsFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&repoVarsValues.releasever, "releasever", "", "value of $releasever in repository definitions")
+	cmd.Flags().StringVar(&repoVarsValues.basearch, "basearch", "", "value of $basearch in repository definitions, defaults to the arch of the repository")
+	cmd.Flags().StringToStringVar(&repoVarsValues.vars, "var", nil, "additional variables for repository definitions in the form name=value. Can be specified multiple times")
+	cmd.Flags().StringArrayVar(&repoVarsValues.varsFiles, "vars-file", nil, "file with name=value lines, or a directory like /etc/dnf/vars with one file per variable, providing variables for repository definitions. Can be specified multiple times")
+}
+
+// Vars returns the variables configured via flags. Values given directly on
+// the command line take precedence over values from vars files.
+func (o *repoVarsOpts) Vars() (map[string]string, error) {
+	vars := map[string]string{}
+	for _, file := range o.varsFiles {
+		fileVars, err := LoadVarsFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fileVars {
+			vars[k] = v
+		}
+	}
+	for k, v := range o.vars {
+		vars[k] = v
+	}
+	if o.releasever != "" {
+		vars["releasever"] = o.releasever
+	}
+	if o.basearch != "" {
+		vars["basearch"] = o.basearch
+	}
+	return vars, nil
+}
+
+// ConfiguredBasearch returns the value of $basearch configured via flags or
+// vars files, which applies to all repositories. It is empty if $basearch
+// defaults to the arch of every repository.
+func ConfiguredBasearch() string {
+	vars, err := repoVarsValues.Vars()
+	if err != nil {
+		return repoVarsValues.basearch
+	}
+	return vars["basearch"]
+}
+
+// LoadVarsFile reads variables either from a file with name=value lines or,
+// like dnf does for /etc/dnf/vars, from a directory where every file name is
+// a variable name and the file content is its value.
+func LoadVarsFile(file string) (map[string]string, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]string{}
+	if info.IsDir() {
+		entries, err := os.ReadDir(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			value, err := os.ReadFile(filepath.Join(file, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			vars[entry.Name()] = strings.TrimSpace(string(value))
+		}
+		return vars, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid line in vars file %s, expected name=value: %q", file, line)
+		}
+		vars[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return vars, scanner.Err()
+}
+
+var varRegexp = regexp.MustCompile(`\$(\{[A-Za-z0-9_]+\}|[A-Za-z0-9_]+)`)
+
+// substituteVars replaces $name and ${name} with the value of the variable.
+// Unknown variables are left untouched.
+func substituteVars(value string, vars map[string]string) string {
+	return varRegexp.ReplaceAllStringFunc(value, func(match string) string {
+		name := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(match, "$"), "{"), "}")
+		if v, exists := vars[name]; exists {
+			return v
+		}
+		return match
+	})
+}
+
+// ExpandRepoVars substitutes the variables in the arch and URL fields of all
+// repositories. If not set otherwise, $basearch and $arch default to the
+// arch of the repository. Variables which are still referenced afterwards,
+// like $basearch in repositories from yum/dnf .repo files, which have no
+// arch, are reported as an error together with the flag which sets them.
+func ExpandRepoVars(repos *bazeldnf.Repositories, vars map[string]string) error {
+	var errs []error
+	for i := range repos.Repositories {
+		repo := &repos.Repositories[i]
+		repo.Arch = substituteVars(repo.Arch, vars)
+
+		repoVars := map[string]string{}
+		if repo.Arch != "" && len(unresolvedVars(repo.Arch)) == 0 {
+			repoVars["basearch"] = repo.Arch
+		}
+		for k, v := range vars {
+			repoVars[k] = v
+		}
+		if _, exists := repoVars["arch"]; !exists && repoVars["basearch"] != "" {
+			repoVars["arch"] = repoVars["basearch"]
+		}
+
+		repo.Metalink = substituteVars(repo.Metalink, repoVars)
+		repo.Mirrorlist = substituteVars(repo.Mirrorlist, repoVars)
+		repo.Baseurl = substituteVars(repo.Baseurl, repoVars)
+		repo.GPGKey = substituteVars(repo.GPGKey, repoVars)
+		repo.SSLCACert = substituteVars(repo.SSLCACert, repoVars)
+		repo.SSLClientCert = substituteVars(repo.SSLClientCert, repoVars)
+		repo.SSLClientKey = substituteVars(repo.SSLClientKey, repoVars)
+		repo.Proxy = substituteVars(repo.Proxy, repoVars)
+		for j := range repo.Mirrors {
+			repo.Mirrors[j] = substituteVars(repo.Mirrors[j], repoVars)
+		}
+
+		var unresolved []string
+		values := []string{repo.Arch, repo.Metalink, repo.Mirrorlist, repo.Baseurl, repo.GPGKey, repo.SSLCACert, repo.SSLClientCert, repo.SSLClientKey, repo.Proxy}
+		for _, value := range append(values, repo.Mirrors...) {
+			for _, name := range unresolvedVars(value) {
+				if !slices.Contains(unresolved, name) {
+					unresolved = append(unresolved, name)
+				}
+			}
+		}
+		for _, name := range unresolved {
+			errs = append(errs, fmt.Errorf("repository %s refers to the undefined variable $%s, %s", repo.Name, name, varFlagHint(name)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// unresolvedVars returns the names of the variables which the value still
+// refers to
+func unresolvedVars(value string) []string {
+	var names []string
+	for _, match := range varRegexp.FindAllString(value, -1) {
+		names = append(names, strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(match, "$"), "{"), "}"))
+	}
+	return names
+}
+
+// varFlagHint names the flags which set the value of the variable
+func varFlagHint(name string) string {
+	switch name {
+	case "basearch", "arch":
+		return "set it with --basearch or the arch of the repository"
+	case "releasever":
+		return "set it with --releasever"
+	}
+	return fmt.Sprintf("set it with --var %s=<value> or --vars-file", name)
+}
@@ -0,0 +1,135 @@
+package repo
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+func TestExpandRepoVars(t *testing.T) {
+	repos := &bazeldnf.Repositories{
+		Repositories: []bazeldnf.Repository{
+			{
+				Name:     "fedora",
+				Arch:     "aarch64",
+				Metalink: "https://mirrors.fedoraproject.org/metalink?repo=fedora-$releasever&arch=$basearch",
+				GPGKey:   "https://example.com/RPM-GPG-KEY-fedora-${releasever}-$arch",
+			},
+			{
+				Name:    "internal",
+				Arch:    "$basearch",
+				Baseurl: "https://repo.example.com/$contentdir/$releasever/$basearch/",
+				Mirrors: []string{"https://mirror.example.com/$contentdir/$releasever/$basearch/"},
+			},
+			{
+				Name:    "unknown",
+				Baseurl: "https://repo.example.com/$unknown/${releasever}x/",
+			},
+		},
+	}
+
+	err := ExpandRepoVars(repos, map[string]string{"releasever": "41", "contentdir": "pub"})
+	expectedErrs := []string{
+		"repository internal refers to the undefined variable $basearch, set it with --basearch or the arch of the repository",
+		"repository unknown refers to the undefined variable $unknown, set it with --var unknown=<value> or --vars-file",
+	}
+	if err == nil || err.Error() != strings.Join(expectedErrs, "\n") {
+		t.Fatalf("expected the undefined variables to be reported, got %v", err)
+	}
+	if err := ExpandRepoVars(&bazeldnf.Repositories{Repositories: []bazeldnf.Repository{{Name: "fedora", Baseurl: "https://repo.example.com/$releasever/"}}}, map[string]string{}); err == nil || !strings.Contains(err.Error(), "repository fedora refers to the undefined variable $releasever, set it with --releasever") {
+		t.Fatalf("expected the undefined $releasever to be reported, got %v", err)
+	}
+	expected := []bazeldnf.Repository{
+		{
+			Name:     "fedora",
+			Arch:     "aarch64",
+			Metalink: "https://mirrors.fedoraproject.org/metalink?repo=fedora-41&arch=aarch64",
+			GPGKey:   "https://example.com/RPM-GPG-KEY-fedora-41-aarch64",
+		},
+		{
+			Name:    "internal",
+			Arch:    "$basearch",
+			Baseurl: "https://repo.example.com/pub/41/$basearch/",
+			Mirrors: []string{"https://mirror.example.com/pub/41/$basearch/"},
+		},
+		{
+			Name:    "unknown",
+			Baseurl: "https://repo.example.com/$unknown/41x/",
+		},
+	}
+	if !reflect.DeepEqual(repos.Repositories, expected) {
+		t.Fatalf("expected %+v, but got %+v", expected, repos.Repositories)
+	}
+
+	repos.Repositories[1] = bazeldnf.Repository{Name: "internal", Arch: "$basearch", Baseurl: "https://repo.example.com/$basearch/"}
+	repos.Repositories = repos.Repositories[:2]
+	if err := ExpandRepoVars(repos, map[string]string{"basearch": "x86_64"}); err != nil {
+		t.Fatalf("expanding $basearch failed: %v", err)
+	}
+	if repos.Repositories[1].Arch != "x86_64" || repos.Repositories[1].Baseurl != "https://repo.example.com/x86_64/" {
+		t.Fatalf("expected $basearch to be expanded, but got %+v", repos.Repositories[1])
+	}
+}
+
+func TestExpandRepoVarsWithoutArch(t *testing.T) {
+	repos := &bazeldnf.Repositories{
+		Repositories: []bazeldnf.Repository{
+			{Name: "yum", Baseurl: "https://repo.example.com/$basearch/"},
+			{Name: "yaml", Arch: "aarch64", Baseurl: "https://repo.example.com/$basearch/"},
+		},
+	}
+	err := ExpandRepoVars(repos, map[string]string{})
+	if err == nil || !strings.Contains(err.Error(), "repository yum refers to the undefined variable $basearch") || strings.Contains(err.Error(), "yaml") {
+		t.Fatalf("expected only the repository without arch to be reported, got %v", err)
+	}
+	if repos.Repositories[1].Baseurl != "https://repo.example.com/aarch64/" {
+		t.Fatalf("expected $basearch to default to the arch of the repository, got %+v", repos.Repositories[1])
+	}
+}
+
+func TestLoadVarsFile(t *testing.T) {
+	dir := t.TempDir()
+	file := path.Join(dir, "vars")
+	if err := os.WriteFile(file, []byte("# comment\nreleasever = 41\n\ncontentdir=pub\n"), 0644); err != nil {
+		t.Fatalf("writing vars file: %v", err)
+	}
+	vars, err := LoadVarsFile(file)
+	if err != nil {
+		t.Fatalf("loading vars file failed: %v", err)
+	}
+	if !reflect.DeepEqual(vars, map[string]string{"releasever": "41", "contentdir": "pub"}) {
+		t.Fatalf("unexpected vars %v", vars)
+	}
+
+	varsDir := path.Join(dir, "vars.d")
+	if err := os.Mkdir(varsDir, 0755); err != nil {
+		t.Fatalf("creating vars directory: %v", err)
+	}
+	if err := os.WriteFile(path.Join(varsDir, "stream"), []byte("9-stream\n"), 0644); err != nil {
+		t.Fatalf("writing vars file: %v", err)
+	}
+	vars, err = LoadVarsFile(varsDir)
+	if err != nil {
+		t.Fatalf("loading vars directory failed: %v", err)
+	}
+	if !reflect.DeepEqual(vars, map[string]string{"stream": "9-stream"}) {
+		t.Fatalf("unexpected vars %v", vars)
+	}
+
+	opts := repoVarsOpts{
+		releasever: "42",
+		vars:       map[string]string{"contentdir": "alt"},
+		varsFiles:  []string{file, varsDir},
+	}
+	vars, err = opts.Vars()
+	if err != nil {
+		t.Fatalf("collecting vars failed: %v", err)
+	}
+	if !reflect.DeepEqual(vars, map[string]string{"releasever": "42", "contentdir": "alt", "stream": "9-stream"}) {
+		t.Fatalf("unexpected vars %v", vars)
+	}
+}
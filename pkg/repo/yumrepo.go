@@ -0,0 +1,188 @@
+package repo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+	"github.com/sirupsen/logrus"
+)
+
+// iniSection holds the options of one section of a yum/dnf .repo file
+type iniSection struct {
+	name    string
+	options map[string]string
+}
+
+// parseINI reads the sections of a yum/dnf .repo file. Values may continue on
+// the following lines if these lines are indented, like it is common for
+// lists of baseurls.
+func parseINI(reader io.Reader) ([]*iniSection, error) {
+	sections := []*iniSection{}
+	var current *iniSection
+	lastKey := ""
+	scanner := bufio.NewScanner(reader)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "[") {
+			if !strings.HasSuffix(trimmed, "]") {
+				return nil, fmt.Errorf("line %d: invalid section header %q", lineNo, trimmed)
+			}
+			current = &iniSection{
+				name:    strings.TrimSpace(trimmed[1 : len(trimmed)-1]),
+				options: map[string]string{},
+			}
+			sections = append(sections, current)
+			lastKey = ""
+			continue
+		}
+		if current == nil {
+			return nil, fmt.Errorf("line %d: option outside of a section", lineNo)
+		}
+		if (line[0] == ' ' || line[0] == '\t') && lastKey != "" {
+			current.options[lastKey] += "\n" + trimmed
+			continue
+		}
+		key, value, found := strings.Cut(trimmed, "=")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected key=value, got %q", lineNo, trimmed)
+		}
+		lastKey = strings.ToLower(strings.TrimSpace(key))
+		current.options[lastKey] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sections, nil
+}
+
+// splitList splits list options like baseurl or gpgkey, which can be separated
+// by commas and whitespace
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
+
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "1", "yes", "true", "on":
+		return true, nil
+	case "0", "no", "false", "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean value %q", value)
+}
+
+// LoadYumRepoFile reads a yum/dnf .repo file, as found in /etc/yum.repos.d,
+// and maps every repository section onto a bazeldnf repository.
+func LoadYumRepoFile(file string) (*bazeldnf.Repositories, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sections, err := parseINI(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", file, err)
+	}
+
+	repos := &bazeldnf.Repositories{}
+	for _, section := range sections {
+		if section.name == "main" {
+			continue
+		}
+		repo, err := yumRepoToRepository(section)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load repository %s from %s: %v", section.name, file, err)
+		}
+		repos.Repositories = append(repos.Repositories, *repo)
+	}
+	return repos, nil
+}
+
+func yumRepoToRepository(section *iniSection) (*bazeldnf.Repository, error) {
+	repo := &bazeldnf.Repository{
+		Name: section.name,
+	}
+	for key, value := range section.options {
+		switch key {
+		case "baseurl":
+			urls := splitList(value)
+			if len(urls) == 0 {
+				continue
+			}
+			repo.Baseurl = urls[0]
+			if len(urls) > 1 {
+				repo.Mirrors = urls
+			}
+		case "metalink":
+			repo.Metalink = value
+		case "mirrorlist":
+			repo.Mirrorlist = value
+		case "gpgkey":
+			keys := splitList(value)
+			if len(keys) == 0 {
+				continue
+			}
+			if len(keys) > 1 {
+				logrus.Warnf("Repository %s lists multiple gpg keys, only using %s", section.name, keys[0])
+			}
+			repo.GPGKey = keys[0]
+		case "enabled":
+			enabled, err := parseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("enabled: %v", err)
+			}
+			repo.Disabled = !enabled
+		case "repo_gpgcheck":
+			check, err := parseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("repo_gpgcheck: %v", err)
+			}
+			repo.RepoGPGCheck = check
+		case "priority":
+			priority, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("priority: %v", err)
+			}
+			repo.Priority = priority
+		case "excludepkgs", "exclude":
+			repo.ExcludePkgs = append(repo.ExcludePkgs, splitList(value)...)
+		case "includepkgs":
+			repo.IncludePkgs = splitList(value)
+		case "sslcacert":
+			repo.SSLCACert = value
+		case "sslclientcert":
+			repo.SSLClientCert = value
+		case "sslclientkey":
+			repo.SSLClientKey = value
+		case "sslverify":
+			verify, err := parseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("sslverify: %v", err)
+			}
+			repo.SSLVerify = &verify
+		case "proxy":
+			repo.Proxy = value
+		case "proxy_username":
+			repo.ProxyUsername = value
+		case "proxy_password":
+			repo.ProxyPassword = value
+		}
+	}
+	if repo.Baseurl == "" && repo.Metalink == "" && repo.Mirrorlist == "" {
+		logrus.Warnf("Repository %s has neither a baseurl, a metalink nor a mirrorlist", section.name)
+	}
+	return repo, nil
+}
@@ -0,0 +1,148 @@
+package repo
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+const testYumRepoFile = `[main]
+gpgcheck=1
+
+# the release repository
+[fedora]
+name=Fedora 41 - x86_64
+metalink=https://mirrors.fedoraproject.org/metalink?repo=fedora-41&arch=x86_64
+enabled=1
+gpgcheck=1
+repo_gpgcheck=0
+gpgkey=file:///etc/pki/rpm-gpg/RPM-GPG-KEY-fedora-41-x86_64
+skip_if_unavailable=False
+
+[internal]
+name = Internal packages
+baseurl = https://repo1.example.com/internal/
+    https://repo2.example.com/internal/
+enabled = 0
+priority = 10
+excludepkgs = kernel*, glibc
+gpgkey = https://repo.example.com/KEY-1 https://repo.example.com/KEY-2
+sslcacert = /etc/pki/internal/ca.pem
+sslclientcert = /etc/pki/internal/client.pem
+sslclientkey = /etc/pki/internal/client.key
+sslverify = 1
+proxy = http://proxy.example.com:3128
+proxy_username = user
+proxy_password = secret
+
+[baseos]
+mirrorlist=http://mirrorlist.centos.org/?release=$releasever&arch=$basearch&repo=BaseOS
+`
+
+func TestLoadYumRepoFile(t *testing.T) {
+	file := path.Join(t.TempDir(), "example.repo")
+	if err := os.WriteFile(file, []byte(testYumRepoFile), 0644); err != nil {
+		t.Fatalf("writing repo file: %v", err)
+	}
+
+	repos, err := LoadRepoFile(file)
+	if err != nil {
+		t.Fatalf("loading repo file failed: %v", err)
+	}
+
+	verify := true
+	expected := []bazeldnf.Repository{
+		{
+			Name:     "fedora",
+			Metalink: "https://mirrors.fedoraproject.org/metalink?repo=fedora-41&arch=x86_64",
+			GPGKey:   "file:///etc/pki/rpm-gpg/RPM-GPG-KEY-fedora-41-x86_64",
+		},
+		{
+			Name:          "internal",
+			Disabled:      true,
+			Baseurl:       "https://repo1.example.com/internal/",
+			Mirrors:       []string{"https://repo1.example.com/internal/", "https://repo2.example.com/internal/"},
+			GPGKey:        "https://repo.example.com/KEY-1",
+			Priority:      10,
+			ExcludePkgs:   []string{"kernel*", "glibc"},
+			SSLCACert:     "/etc/pki/internal/ca.pem",
+			SSLClientCert: "/etc/pki/internal/client.pem",
+			SSLClientKey:  "/etc/pki/internal/client.key",
+			SSLVerify:     &verify,
+			Proxy:         "http://proxy.example.com:3128",
+			ProxyUsername: "user",
+			ProxyPassword: "secret",
+		},
+		{
+			Name:       "baseos",
+			Mirrorlist: "http://mirrorlist.centos.org/?release=$releasever&arch=$basearch&repo=BaseOS",
+		},
+	}
+	if !reflect.DeepEqual(repos.Repositories, expected) {
+		t.Fatalf("expected %+v, but got %+v", expected, repos.Repositories)
+	}
+}
+
+func TestLoadYumRepoFileErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+	}{
+		{name: "option outside of section", content: "baseurl=https://example.com\n"},
+		{name: "broken section header", content: "[fedora\n"},
+		{name: "missing value", content: "[fedora]\nbaseurl\n"},
+		{name: "invalid boolean", content: "[fedora]\nenabled=maybe\n"},
+		{name: "invalid priority", content: "[fedora]\npriority=high\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			file := path.Join(t.TempDir(), "broken.repo")
+			if err := os.WriteFile(file, []byte(tc.content), 0644); err != nil {
+				t.Fatalf("writing repo file: %v", err)
+			}
+			if _, err := LoadRepoFile(file); err == nil {
+				t.Fatalf("expected loading %q to fail", tc.content)
+			}
+		})
+	}
+}
+
+func TestDisabledYumRepository(t *testing.T) {
+	file := path.Join(t.TempDir(), "fedora.repo")
+	content := "[fedora]\nbaseurl=http://localhost/fedora/\n\n[fedora-debuginfo]\nbaseurl=http://localhost/debuginfo/\nenabled=0\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("writing repo file: %v", err)
+	}
+	repos, err := LoadRepoFile(file)
+	if err != nil {
+		t.Fatalf("loading repo file failed: %v", err)
+	}
+
+	// only the enabled repository is cached, the disabled one must never be
+	// required or read
+	helper := &CacheHelper{cacheDir: t.TempDir()}
+	writeTestPrimary(t, helper, &repos.Repositories[0], "1111")
+
+	walked := map[string]int{}
+	err = helper.WalkPrimaries(repos, []string{"x86_64", "noarch"}, func(pkg *api.Package) error {
+		walked[pkg.Repository.Name]++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walking the primaries failed: %v", err)
+	}
+	if walked["fedora"] == 0 || walked["fedora-debuginfo"] != 0 {
+		t.Fatalf("expected only packages of the enabled repository, got %v", walked)
+	}
+	if err := helper.WalkFilelists(repos, []string{"x86_64", "noarch"}, func(pkg *api.FileListPackage) error { return nil }); err != nil {
+		t.Fatalf("walking the filelists failed: %v", err)
+	}
+
+	fetcher := &RepoFetcherImpl{Getter: &getterImpl{}, Repos: repos.Repositories, CacheHelper: helper, Offline: true}
+	if err := fetcher.Fetch(); err != nil {
+		t.Fatalf("expected the disabled repository to be skipped, got %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zchunk files (.zck) consist of a header with an index of all chunks,
+// followed by the chunks themselves. With zstd compression, the first chunk
+// holds an optional dictionary and every other chunk is an independent zstd
+// frame compressed with that dictionary. See
+// https://github.com/zchunk/zchunk/blob/main/zchunk_format.txt for details.
+
+var zchunkMagic = []byte("\x00ZCK1")
+
+const (
+	zchunkFlagStreams          = 1
+	zchunkFlagOptionalElements = 2
+
+	zchunkCompressionNone = 0
+	zchunkCompressionZstd = 2
+
+	zstdDictMagic = 0xEC30A437
+)
+
+// zchunkChecksumSizes maps the checksum types of zchunk to their length
+var zchunkChecksumSizes = map[uint64]int{
+	0: 20, // SHA-1
+	1: 32, // SHA-256
+	2: 64, // SHA-512
+	3: 16, // SHA-512/128
+}
+
+type zchunkChunk struct {
+	length             uint64
+	uncompressedLength uint64
+}
+
+// zchunkReader decompresses the chunks of a zchunk file one at a time. The
+// checksums in the file are not verified, since the checksum of the whole
+// file is already verified against repomd.xml when it is fetched.
+type zchunkReader struct {
+	reader      *bufio.Reader
+	compression uint64
+	chunks      []zchunkChunk
+	decoder     *zstd.Decoder
+	current     []byte
+}
+
+func newZchunkReader(stream io.Reader) (io.ReadCloser, error) {
+	z := &zchunkReader{reader: bufio.NewReader(stream)}
+	if err := z.readHeader(); err != nil {
+		return nil, fmt.Errorf("invalid zchunk header: %v", err)
+	}
+	return z, nil
+}
+
+// readCompressedInt reads a little endian integer of which every byte carries
+// 7 bits, the highest bit marks the last byte.
+func (z *zchunkReader) readCompressedInt() (uint64, error) {
+	var value uint64
+	for shift := 0; shift < 64; shift += 7 {
+		b, err := z.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint64(b&0x7f) << shift
+		if b&0x80 != 0 {
+			return value, nil
+		}
+	}
+	return 0, fmt.Errorf("compressed integer overflows")
+}
+
+func (z *zchunkReader) skip(n uint64) error {
+	_, err := io.CopyN(io.Discard, z.reader, int64(n))
+	return err
+}
+
+func (z *zchunkReader) skipChecksum(checksumType uint64) error {
+	size, ok := zchunkChecksumSizes[checksumType]
+	if !ok {
+		return fmt.Errorf("unknown checksum type %d", checksumType)
+	}
+	return z.skip(uint64(size))
+}
+
+func (z *zchunkReader) readHeader() error {
+	// lead
+	magic := make([]byte, len(zchunkMagic))
+	if _, err := io.ReadFull(z.reader, magic); err != nil {
+		return err
+	}
+	if !bytes.Equal(magic, zchunkMagic) {
+		return fmt.Errorf("not a zchunk file")
+	}
+	checksumType, err := z.readCompressedInt()
+	if err != nil {
+		return err
+	}
+	if _, err := z.readCompressedInt(); err != nil { // header size
+		return err
+	}
+	if err := z.skipChecksum(checksumType); err != nil { // header checksum
+		return err
+	}
+
+	// preface
+	if err := z.skipChecksum(checksumType); err != nil { // data checksum
+		return err
+	}
+	flags, err := z.readCompressedInt()
+	if err != nil {
+		return err
+	}
+	if z.compression, err = z.readCompressedInt(); err != nil {
+		return err
+	}
+	if z.compression != zchunkCompressionNone && z.compression != zchunkCompressionZstd {
+		return fmt.Errorf("unsupported compression type %d", z.compression)
+	}
+	if flags&zchunkFlagOptionalElements != 0 {
+		count, err := z.readCompressedInt()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < count; i++ {
+			if _, err := z.readCompressedInt(); err != nil { // element id
+				return err
+			}
+			size, err := z.readCompressedInt()
+			if err != nil {
+				return err
+			}
+			if err := z.skip(size); err != nil {
+				return err
+			}
+		}
+	}
+
+	// index, the first entry is the dictionary
+	if _, err := z.readCompressedInt(); err != nil { // index size
+		return err
+	}
+	chunkChecksumType, err := z.readCompressedInt()
+	if err != nil {
+		return err
+	}
+	count, err := z.readCompressedInt()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < count; i++ {
+		if flags&zchunkFlagStreams != 0 {
+			if _, err := z.readCompressedInt(); err != nil { // stream
+				return err
+			}
+		}
+		if err := z.skipChecksum(chunkChecksumType); err != nil {
+			return err
+		}
+		chunk := zchunkChunk{}
+		if chunk.length, err = z.readCompressedInt(); err != nil {
+			return err
+		}
+		if chunk.uncompressedLength, err = z.readCompressedInt(); err != nil {
+			return err
+		}
+		z.chunks = append(z.chunks, chunk)
+	}
+
+	// signatures
+	signatures, err := z.readCompressedInt()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < signatures; i++ {
+		if _, err := z.readCompressedInt(); err != nil { // signature type
+			return err
+		}
+		size, err := z.readCompressedInt()
+		if err != nil {
+			return err
+		}
+		if err := z.skip(size); err != nil {
+			return err
+		}
+	}
+	return z.readDict()
+}
+
+// readDict consumes the dictionary chunk and prepares the decoder for the
+// remaining chunks
+func (z *zchunkReader) readDict() error {
+	if len(z.chunks) == 0 {
+		return nil
+	}
+	dictChunk := z.chunks[0]
+	z.chunks = z.chunks[1:]
+	if z.compression != zchunkCompressionZstd {
+		return z.skip(dictChunk.length)
+	}
+
+	options := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+	if dictChunk.length > 0 {
+		compressed := make([]byte, dictChunk.length)
+		if _, err := io.ReadFull(z.reader, compressed); err != nil {
+			return fmt.Errorf("failed to read dictionary: %v", err)
+		}
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		dict, err := decoder.DecodeAll(compressed, nil)
+		decoder.Close()
+		if err != nil {
+			return fmt.Errorf("failed to decompress dictionary: %v", err)
+		}
+		if len(dict) >= 4 && binary.LittleEndian.Uint32(dict) == zstdDictMagic {
+			options = append(options, zstd.WithDecoderDicts(dict))
+		} else if len(dict) > 0 {
+			options = append(options, zstd.WithDecoderDictRaw(0, dict))
+		}
+	}
+	decoder, err := zstd.NewReader(nil, options...)
+	if err != nil {
+		return err
+	}
+	z.decoder = decoder
+	return nil
+}
+
+func (z *zchunkReader) Read(p []byte) (int, error) {
+	for len(z.current) == 0 {
+		if len(z.chunks) == 0 {
+			return 0, io.EOF
+		}
+		if err := z.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, z.current)
+	z.current = z.current[n:]
+	return n, nil
+}
+
+func (z *zchunkReader) nextChunk() error {
+	chunk := z.chunks[0]
+	z.chunks = z.chunks[1:]
+	data := make([]byte, chunk.length)
+	if _, err := io.ReadFull(z.reader, data); err != nil {
+		return fmt.Errorf("failed to read zchunk chunk: %v", err)
+	}
+	if z.compression == zchunkCompressionNone || chunk.length == 0 {
+		z.current = data
+		return nil
+	}
+	decompressed, err := z.decoder.DecodeAll(data, nil)
+	if err != nil {
+		return fmt.Errorf("failed to decompress zchunk chunk: %v", err)
+	}
+	z.current = decompressed
+	return nil
+}
+
+func (z *zchunkReader) Close() error {
+	if z.decoder != nil {
+		z.decoder.Close()
+	}
+	return nil
+}
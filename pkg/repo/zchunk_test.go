@@ -0,0 +1,152 @@
+package repo
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rmohr/bazeldnf/pkg/api"
+)
+
+func appendCompressedInt(b []byte, value uint64) []byte {
+	for value >= 0x80 {
+		b = append(b, byte(value&0x7f))
+		value >>= 7
+	}
+	return append(b, byte(value)|0x80)
+}
+
+// newTestZchunk creates a zchunk file with one zstd compressed chunk per
+// element of chunks, compressed with a raw dictionary if dict is set
+func newTestZchunk(t *testing.T, dict []byte, chunks ...string) []byte {
+	t.Helper()
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("creating zstd encoder: %v", err)
+	}
+	compressedDict := []byte{}
+	if dict != nil {
+		compressedDict = encoder.EncodeAll(dict, nil)
+		encoder, err = zstd.NewWriter(nil, zstd.WithEncoderDictRaw(0, dict))
+		if err != nil {
+			t.Fatalf("creating zstd encoder: %v", err)
+		}
+	}
+	compressed := [][]byte{compressedDict}
+	uncompressedLengths := []int{len(dict)}
+	for _, chunk := range chunks {
+		compressed = append(compressed, encoder.EncodeAll([]byte(chunk), nil))
+		uncompressedLengths = append(uncompressedLengths, len(chunk))
+	}
+
+	index := appendCompressedInt(nil, 3) // chunk checksum type SHA-512/128
+	index = appendCompressedInt(index, uint64(len(compressed)))
+	for i, chunk := range compressed {
+		index = append(index, make([]byte, 16)...)
+		index = appendCompressedInt(index, uint64(len(chunk)))
+		index = appendCompressedInt(index, uint64(uncompressedLengths[i]))
+	}
+
+	header := make([]byte, 32)                                       // data checksum
+	header = appendCompressedInt(header, zchunkFlagOptionalElements) // flags
+	header = appendCompressedInt(header, zchunkCompressionZstd)
+	header = appendCompressedInt(header, 1) // optional element count
+	header = appendCompressedInt(header, 7) // optional element id
+	header = appendCompressedInt(header, 3)
+	header = append(header, "foo"...)
+	header = appendCompressedInt(header, uint64(len(index)))
+	header = append(header, index...)
+	header = appendCompressedInt(header, 0) // signature count
+
+	file := append([]byte{}, zchunkMagic...)
+	file = appendCompressedInt(file, 1) // checksum type SHA-256
+	file = appendCompressedInt(file, uint64(len(header)))
+	file = append(file, make([]byte, 32)...) // header checksum
+	file = append(file, header...)
+	for _, chunk := range compressed {
+		file = append(file, chunk...)
+	}
+	return file
+}
+
+func TestZchunkReader(t *testing.T) {
+	tests := []struct {
+		name string
+		dict []byte
+	}{
+		{name: "without dictionary"},
+		{name: "with dictionary", dict: []byte("<package type=\"rpm\"><name></name><arch>x86_64</arch></package>")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := []string{testIndexPrimary[:100], testIndexPrimary[100:400], "", testIndexPrimary[400:]}
+			file := newTestZchunk(t, tt.dict, chunks...)
+
+			helper := &CacheHelper{}
+			reader, err := helper.getCompressFileReader("primary.xml.zck", bytes.NewReader(file))
+			if err != nil {
+				t.Fatalf("opening zchunk file: %v", err)
+			}
+			defer reader.Close()
+			content, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("reading zchunk file: %v", err)
+			}
+			if string(content) != testIndexPrimary {
+				t.Fatalf("unexpected content: %q", string(content))
+			}
+		})
+	}
+
+	helper := &CacheHelper{}
+	if _, err := helper.getCompressFileReader("primary.xml.zck", strings.NewReader("not a zchunk file")); err == nil {
+		t.Fatalf("expected an error for an invalid zchunk file")
+	}
+}
+
+func TestBzip2Reader(t *testing.T) {
+	f, err := os.Open("testdata/primary.xml.bz2")
+	if err != nil {
+		t.Fatalf("opening testdata: %v", err)
+	}
+	defer f.Close()
+	helper := &CacheHelper{}
+	reader, err := helper.getCompressFileReader("primary.xml.bz2", f)
+	if err != nil {
+		t.Fatalf("opening bzip2 file: %v", err)
+	}
+	defer reader.Close()
+	names := []string{}
+	err = WalkPrimaryXML(reader, func(pkg *api.Package) error {
+		names = append(names, pkg.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("reading bzip2 compressed primary.xml: %v", err)
+	}
+	if strings.Join(names, ",") != "foo,bar" {
+		t.Fatalf("expected packages foo and bar, got %v", names)
+	}
+}
+
+func TestMetadataFile(t *testing.T) {
+	repomd := &api.Repomd{Data: []api.Data{{Type: "primary"}, {Type: "primary_zck"}, {Type: "filelists"}}}
+	repomd.Data[0].Location.Href = "repodata/primary.xml.lz4"
+	repomd.Data[1].Location.Href = "repodata/primary.xml.zck"
+	repomd.Data[2].Location.Href = "repodata/filelists.xml.bz2"
+
+	if file := metadataFile(repomd, api.PrimaryFileType); file.Location.Href != "repodata/primary.xml.zck" {
+		t.Fatalf("expected the zchunk primary for an unsupported compression, got %s", file.Location.Href)
+	}
+	if file := metadataFile(repomd, api.FilelistsFileType); file.Location.Href != "repodata/filelists.xml.bz2" {
+		t.Fatalf("expected the bzip2 filelists, got %s", file.Location.Href)
+	}
+
+	repomd.Data[0].Location.Href = "repodata/primary.xml.gz"
+	if file := metadataFile(repomd, api.PrimaryFileType); file.Location.Href != "repodata/primary.xml.gz" {
+		t.Fatalf("expected the gzip primary to be preferred, got %s", file.Location.Href)
+	}
+}
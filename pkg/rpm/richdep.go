@@ -0,0 +1,279 @@
+package rpm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rmohr/bazeldnf/pkg/api"
+)
+
+// Operator is a boolean operator of a rich dependency
+type Operator string
+
+const (
+	OperatorAnd     Operator = "and"
+	OperatorOr      Operator = "or"
+	OperatorIf      Operator = "if"
+	OperatorUnless  Operator = "unless"
+	OperatorWith    Operator = "with"
+	OperatorWithout Operator = "without"
+)
+
+var versionFlags = map[string]string{
+	"=":  "EQ",
+	"<":  "LT",
+	">":  "GT",
+	"<=": "LE",
+	">=": "GE",
+}
+
+// Dependency is a parsed rpm dependency. Simple dependencies only have an
+// Entry, rich dependencies like "(foo if bar)" combine their operands with an
+// Operator. For if and unless the second operand is the condition and the
+// optional third operand the else branch.
+type Dependency struct {
+	Operator Operator
+	Entry    api.Entry
+	Operands []*Dependency
+}
+
+// IsRichDependency checks if the name of a dependency is a boolean expression
+func IsRichDependency(name string) bool {
+	return strings.HasPrefix(name, "(")
+}
+
+// ParseDependency parses the rich dependency expression in the name of the
+// entry. Entries which are no rich dependency are returned as simple
+// dependency.
+func ParseDependency(entry api.Entry) (*Dependency, error) {
+	if !IsRichDependency(entry.Name) {
+		return &Dependency{Entry: entry}, nil
+	}
+	p := &richDependencyParser{tokens: tokenizeRichDependency(entry.Name)}
+	dep, err := p.parseOperand()
+	if err != nil {
+		return nil, fmt.Errorf("invalid rich dependency %s: %v", entry.Name, err)
+	}
+	if token := p.next(); token != "" {
+		return nil, fmt.Errorf("invalid rich dependency %s: unexpected %q after the expression", entry.Name, token)
+	}
+	return dep, nil
+}
+
+// IsSimple checks if the dependency is a single capability
+func (d *Dependency) IsSimple() bool {
+	return d.Operator == ""
+}
+
+// Entries returns all simple dependencies referenced by the dependency
+func (d *Dependency) Entries() []api.Entry {
+	if d.IsSimple() {
+		return []api.Entry{d.Entry}
+	}
+	entries := []api.Entry{}
+	for _, operand := range d.Operands {
+		entries = append(entries, operand.Entries()...)
+	}
+	return entries
+}
+
+// Installable returns the simple dependencies of which providers may have to
+// be installed to satisfy the dependency. The conditions of if and unless and
+// the excluded capabilities of without are only checked against what gets
+// installed anyway.
+func (d *Dependency) Installable() []api.Entry {
+	if d.IsSimple() {
+		return []api.Entry{d.Entry}
+	}
+	operands := d.Operands
+	switch d.Operator {
+	case OperatorIf, OperatorUnless:
+		operands = append([]*Dependency{operands[0]}, operands[2:]...)
+	case OperatorWithout:
+		operands = operands[:1]
+	}
+	entries := []api.Entry{}
+	for _, operand := range operands {
+		entries = append(entries, operand.Installable()...)
+	}
+	return entries
+}
+
+func (d *Dependency) String() string {
+	if d.IsSimple() {
+		return FormatEntry(d.Entry)
+	}
+	operands := []string{}
+	for i, operand := range d.Operands {
+		if i == 2 && (d.Operator == OperatorIf || d.Operator == OperatorUnless) {
+			operands = append(operands, "else")
+		} else if i > 0 {
+			operands = append(operands, string(d.Operator))
+		}
+		operands = append(operands, operand.String())
+	}
+	return "(" + strings.Join(operands, " ") + ")"
+}
+
+// FormatEntry prints a simple dependency in the syntax of rpm, like
+// "foo >= 1.0-1". The default epoch 0 is omitted.
+func FormatEntry(entry api.Entry) string {
+	for operator, flags := range versionFlags {
+		if flags != entry.Flags {
+			continue
+		}
+		version := entry.Ver
+		if entry.Epoch != "" && entry.Epoch != "0" {
+			version = entry.Epoch + ":" + version
+		}
+		if entry.Rel != "" {
+			version += "-" + entry.Rel
+		}
+		return fmt.Sprintf("%s %s %s", entry.Name, operator, version)
+	}
+	return entry.Name
+}
+
+// tokenizeRichDependency splits a rich dependency into parentheses and words.
+// Parentheses which are part of a capability like perl(Foo) stay in the word.
+func tokenizeRichDependency(expression string) []string {
+	tokens := []string{}
+	for i := 0; i < len(expression); {
+		switch c := expression[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			start, depth := i, 0
+			for ; i < len(expression); i++ {
+				c := expression[i]
+				if c == ' ' || c == '\t' || (c == ')' && depth == 0) {
+					break
+				}
+				if c == '(' {
+					depth++
+				} else if c == ')' {
+					depth--
+				}
+			}
+			tokens = append(tokens, expression[start:i])
+		}
+	}
+	return tokens
+}
+
+type richDependencyParser struct {
+	tokens []string
+}
+
+func (p *richDependencyParser) peek() string {
+	if len(p.tokens) == 0 {
+		return ""
+	}
+	return p.tokens[0]
+}
+
+func (p *richDependencyParser) next() string {
+	token := p.peek()
+	if len(p.tokens) > 0 {
+		p.tokens = p.tokens[1:]
+	}
+	return token
+}
+
+// parseOperand parses a parenthesized expression or a simple dependency with
+// an optional version comparison
+func (p *richDependencyParser) parseOperand() (*Dependency, error) {
+	token := p.next()
+	switch token {
+	case "":
+		return nil, fmt.Errorf("unexpected end of the expression")
+	case "(":
+		return p.parseExpression()
+	case ")":
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+	entry := api.Entry{Name: token}
+	if flags, exists := versionFlags[p.peek()]; exists {
+		p.next()
+		version := p.next()
+		if version == "" || version == "(" || version == ")" {
+			return nil, fmt.Errorf("missing version after %s", entry.Name)
+		}
+		entry.Flags = flags
+		entry.Epoch, entry.Ver, entry.Rel = parseEVR(version)
+	}
+	return &Dependency{Entry: entry}, nil
+}
+
+// parseExpression parses the content of parentheses up to the closing one.
+// Like in rpm, different operators can't be mixed without parentheses, only
+// if and unless can have an else branch.
+func (p *richDependencyParser) parseExpression() (*Dependency, error) {
+	first, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	token := p.next()
+	if token == ")" {
+		return first, nil
+	}
+	dep := &Dependency{Operator: Operator(token), Operands: []*Dependency{first}}
+	switch dep.Operator {
+	case OperatorIf, OperatorUnless:
+		condition, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		dep.Operands = append(dep.Operands, condition)
+		if p.peek() == "else" {
+			p.next()
+			otherwise, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			dep.Operands = append(dep.Operands, otherwise)
+		}
+		if token := p.next(); token != ")" {
+			return nil, fmt.Errorf("expected \")\" after the %s expression, got %q", dep.Operator, token)
+		}
+		return dep, nil
+	case OperatorAnd, OperatorOr, OperatorWith, OperatorWithout:
+		for {
+			operand, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			dep.Operands = append(dep.Operands, operand)
+			token := p.next()
+			if token == ")" {
+				break
+			}
+			if Operator(token) != dep.Operator {
+				return nil, fmt.Errorf("expected %s or \")\", got %q", dep.Operator, token)
+			}
+			if dep.Operator == OperatorWithout {
+				return nil, fmt.Errorf("without can't be chained")
+			}
+		}
+		return dep, nil
+	default:
+		return nil, fmt.Errorf("unknown operator %q", token)
+	}
+}
+
+// parseEVR splits a version of the form [epoch:]version[-release]. Like in
+// the repository metadata, the epoch defaults to 0.
+func parseEVR(evr string) (epoch string, ver string, rel string) {
+	epoch = "0"
+	if i := strings.Index(evr, ":"); i >= 0 {
+		epoch, evr = evr[:i], evr[i+1:]
+	}
+	ver = evr
+	if i := strings.LastIndex(evr, "-"); i >= 0 {
+		ver, rel = evr[:i], evr[i+1:]
+	}
+	return epoch, ver, rel
+}
@@ -0,0 +1,106 @@
+package rpm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rmohr/bazeldnf/pkg/api"
+)
+
+func simple(name string) *Dependency {
+	return &Dependency{Entry: api.Entry{Name: name}}
+}
+
+func TestParseDependency(t *testing.T) {
+	tests := []struct {
+		name string
+		dep  string
+		want *Dependency
+	}{
+		{name: "simple dependency", dep: "foo", want: simple("foo")},
+		{name: "parenthesized dependency", dep: "(foo)", want: simple("foo")},
+		{name: "or", dep: "(foo or bar or baz)", want: &Dependency{Operator: OperatorOr, Operands: []*Dependency{simple("foo"), simple("bar"), simple("baz")}}},
+		{name: "if with capabilities containing parentheses", dep: "(perl(Foo::Bar) if pkgconfig(glib-2.0))", want: &Dependency{Operator: OperatorIf, Operands: []*Dependency{simple("perl(Foo::Bar)"), simple("pkgconfig(glib-2.0)")}}},
+		{name: "if with else", dep: "(foo if bar else baz)", want: &Dependency{Operator: OperatorIf, Operands: []*Dependency{simple("foo"), simple("bar"), simple("baz")}}},
+		{name: "unless", dep: "(foo unless bar)", want: &Dependency{Operator: OperatorUnless, Operands: []*Dependency{simple("foo"), simple("bar")}}},
+		{name: "with versions", dep: "(foo >= 1:2.3-4.fc40 with foo < 3)", want: &Dependency{Operator: OperatorWith, Operands: []*Dependency{
+			{Entry: api.Entry{Name: "foo", Flags: "GE", Epoch: "1", Ver: "2.3", Rel: "4.fc40"}},
+			{Entry: api.Entry{Name: "foo", Flags: "LT", Epoch: "0", Ver: "3"}},
+		}}},
+		{name: "nested", dep: "((foo and bar) or (baz without qux))", want: &Dependency{Operator: OperatorOr, Operands: []*Dependency{
+			{Operator: OperatorAnd, Operands: []*Dependency{simple("foo"), simple("bar")}},
+			{Operator: OperatorWithout, Operands: []*Dependency{simple("baz"), simple("qux")}},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDependency(api.Entry{Name: tt.dep})
+			if err != nil {
+				t.Fatalf("ParseDependency() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseDependency() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalidDependency(t *testing.T) {
+	for _, dep := range []string{
+		"(foo",
+		"(foo or)",
+		"(foo or bar and baz)",
+		"(foo if bar if baz)",
+		"(foo without bar without baz)",
+		"(foo xor bar)",
+		"(foo >= )",
+		"(foo) bar",
+	} {
+		t.Run(dep, func(t *testing.T) {
+			if _, err := ParseDependency(api.Entry{Name: dep}); err == nil {
+				t.Errorf("expected an error for %s", dep)
+			}
+		})
+	}
+}
+
+func TestDependencyEntries(t *testing.T) {
+	dep, err := ParseDependency(api.Entry{Name: "((a if b else c) and (d unless e) and (f without g))"})
+	if err != nil {
+		t.Fatalf("ParseDependency() error = %v", err)
+	}
+	names := func(entries []api.Entry) (names []string) {
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+		}
+		return names
+	}
+	if got := names(dep.Entries()); !reflect.DeepEqual(got, []string{"a", "b", "c", "d", "e", "f", "g"}) {
+		t.Errorf("Entries() = %v", got)
+	}
+	if got := names(dep.Installable()); !reflect.DeepEqual(got, []string{"a", "c", "d", "f"}) {
+		t.Errorf("Installable() = %v", got)
+	}
+	if got := dep.String(); got != "((a if b else c) and (d unless e) and (f without g))" {
+		t.Errorf("String() = %v", got)
+	}
+}
+
+func TestFormatEntry(t *testing.T) {
+	tests := []struct {
+		entry api.Entry
+		want  string
+	}{
+		{entry: api.Entry{Name: "foo"}, want: "foo"},
+		{entry: api.Entry{Name: "foo", Flags: "GE", Epoch: "0", Ver: "1.0", Rel: "1"}, want: "foo >= 1.0-1"},
+		{entry: api.Entry{Name: "libfoo.so.1()(64bit)", Flags: "LT", Epoch: "2", Ver: "3"}, want: "libfoo.so.1()(64bit) < 2:3"},
+		{entry: api.Entry{Name: "foo", Flags: "EQ", Ver: "1"}, want: "foo = 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := FormatEntry(tt.entry); got != tt.want {
+				t.Errorf("FormatEntry() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package sat
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/crillab/gophersat/bf"
+	"github.com/crillab/gophersat/solver"
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+// explain computes a minimal set of the hard clauses of an unsolvable model
+// which can't be satisfied together and describes them as a chain, starting
+// at the requested packages.
+func explain(model *Model) (string, error) {
+	logrus.Info("Looking for the reason why there is no solution.")
+	core, err := unsatisfiableCore(model)
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, reason := range chain(core) {
+		lines = append(lines, "  "+reason.String())
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// unsatisfiableCore returns the reasons of a minimal unsatisfiable subset of
+// the clauses which have a reason. Every such clause is guarded by a selector
+// variable, which allows switching it on and off via the assumptions of a
+// single solver instance. Clauses without a reason always stay active, they
+// can always be satisfied on their own.
+func unsatisfiableCore(model *Model) ([]*Reason, error) {
+	var formulas []bf.Formula
+	selectors := map[string]int{}
+	for i, and := range model.ands {
+		if model.reasons[i] == nil {
+			formulas = append(formulas, and)
+			continue
+		}
+		selector := fmt.Sprintf("s%d", i)
+		selectors[selector] = i
+		formulas = append(formulas, bf.Implies(bf.Var(selector), and))
+	}
+	clauses, nbVars, ids, err := toCNF(bf.And(formulas...))
+	if err != nil {
+		return nil, err
+	}
+
+	// clauses which got simplified away can't be part of the core
+	var candidates []int
+	lits := map[int]solver.Lit{}
+	for selector, i := range selectors {
+		if id, exists := ids[selector]; exists {
+			candidates = append(candidates, i)
+			lits[i] = solver.IntToLit(int32(id))
+		}
+	}
+	sort.Ints(candidates)
+
+	s := solver.New(solver.ParseSliceNb(clauses, nbVars))
+	unsat := func(enabled []int) bool {
+		active := map[int]struct{}{}
+		for _, i := range enabled {
+			active[i] = struct{}{}
+		}
+		assumptions := make([]solver.Lit, 0, len(candidates))
+		for _, i := range candidates {
+			if _, exists := active[i]; exists {
+				assumptions = append(assumptions, lits[i])
+			} else {
+				assumptions = append(assumptions, lits[i].Negation())
+			}
+		}
+		if s.Assume(assumptions) == solver.Unsat {
+			return true
+		}
+		return s.Solve() == solver.Unsat
+	}
+	if !unsat(candidates) {
+		return nil, fmt.Errorf("the hard clauses can be satisfied")
+	}
+
+	// Remove chunks of clauses as long as the remaining ones can't be
+	// satisfied, with shrinking chunks until single clauses are left.
+	core := candidates
+	for chunk := len(core) / 2; ; chunk /= 2 {
+		if chunk < 1 {
+			chunk = 1
+		}
+		for start := 0; start < len(core); {
+			end := min(start+chunk, len(core))
+			reduced := append(append([]int{}, core[:start]...), core[end:]...)
+			if unsat(reduced) {
+				core = reduced
+			} else {
+				start = end
+			}
+		}
+		if chunk == 1 {
+			break
+		}
+	}
+
+	var reasons []*Reason
+	for _, i := range core {
+		reasons = append(reasons, model.reasons[i])
+	}
+	logrus.Infof("Found %d of %d clauses which can't be satisfied together.", len(reasons), len(candidates))
+	return reasons, nil
+}
+
+// toCNF converts the formula to clauses for the solver and returns the
+// DIMACS ids of its named variables
+func toCNF(f bf.Formula) (clauses [][]int, nbVars int, ids map[string]int, err error) {
+	buf := &bytes.Buffer{}
+	if err := bf.Dimacs(f, buf); err != nil {
+		return nil, 0, nil, err
+	}
+	ids = map[string]int{}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case fields[0] == "p":
+			if len(fields) != 4 {
+				return nil, 0, nil, fmt.Errorf("invalid DIMACS header %q", line)
+			}
+			if nbVars, err = strconv.Atoi(fields[2]); err != nil {
+				return nil, 0, nil, fmt.Errorf("invalid DIMACS header %q: %v", line, err)
+			}
+		case fields[0] == "c":
+			if len(fields) != 2 {
+				continue
+			}
+			name, id, found := strings.Cut(fields[1], "=")
+			if !found {
+				continue
+			}
+			if ids[name], err = strconv.Atoi(id); err != nil {
+				return nil, 0, nil, fmt.Errorf("invalid DIMACS variable %q: %v", line, err)
+			}
+		default:
+			var clause []int
+			for _, field := range fields {
+				lit, err := strconv.Atoi(field)
+				if err != nil {
+					return nil, 0, nil, fmt.Errorf("invalid DIMACS clause %q: %v", line, err)
+				}
+				if lit == 0 {
+					break
+				}
+				clause = append(clause, lit)
+			}
+			clauses = append(clauses, clause)
+		}
+	}
+	return clauses, nbVars, ids, nil
+}
+
+// chain orders the reasons so that they can be read like a chain: first the
+// requested packages, then the reasons about the packages mentioned so far,
+// and only then the reasons which mention such packages otherwise.
+func chain(reasons []*Reason) (ordered []*Reason) {
+	remaining := append([]*Reason{}, reasons...)
+	sort.SliceStable(remaining, func(i, j int) bool {
+		return remaining[i].String() < remaining[j].String()
+	})
+	mentioned := map[*api.Package]struct{}{}
+	mention := func(reason *Reason) {
+		ordered = append(ordered, reason)
+		mentioned[reason.Var.Package] = struct{}{}
+		for _, other := range reason.Others {
+			mentioned[other.Package] = struct{}{}
+		}
+	}
+	isAbout := func(reason *Reason) bool {
+		_, exists := mentioned[reason.Var.Package]
+		return exists
+	}
+	isMentioning := func(reason *Reason) bool {
+		for _, other := range reason.Others {
+			if _, exists := mentioned[other.Package]; exists {
+				return true
+			}
+		}
+		return false
+	}
+
+	var rest []*Reason
+	for _, reason := range remaining {
+		if reason.Type == ReasonRequested {
+			mention(reason)
+		} else {
+			rest = append(rest, reason)
+		}
+	}
+	remaining = rest
+	for len(remaining) > 0 {
+		next := slices.IndexFunc(remaining, isAbout)
+		if next < 0 {
+			next = slices.IndexFunc(remaining, isMentioning)
+		}
+		if next < 0 {
+			next = 0
+		}
+		mention(remaining[next])
+		remaining = append(remaining[:next], remaining[next+1:]...)
+	}
+	return ordered
+}
@@ -0,0 +1,129 @@
+package sat
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/rmohr/bazeldnf/pkg/api"
+	"github.com/rmohr/bazeldnf/pkg/api/bazeldnf"
+)
+
+func TestExplain(t *testing.T) {
+	tests := []struct {
+		name        string
+		packages    []*api.Package
+		requires    []string
+		nobest      bool
+		explanation []string
+	}{
+		{name: "requirement which conflicts with a requested package", packages: []*api.Package{
+			explainPkg("foo", "1.2", nil, []string{"libbar.so.3", "libqux"}, nil),
+			explainPkg("bar", "3.0", []string{"libbar.so.3"}, nil, []string{"baz"}),
+			explainPkg("baz", "2.1", nil, nil, nil),
+			explainPkg("qux", "1", []string{"libqux"}, nil, nil),
+		}, requires: []string{
+			"foo", "baz",
+		}, explanation: []string{
+			"baz-0:2.1 is requested",
+			"foo-0:1.2 is requested",
+			"foo-0:1.2 requires libbar.so.3, provided only by bar-0:3.0",
+			"bar-0:3.0 conflicts with baz, provided by baz-0:2.1",
+		}},
+		{name: "transitive requirement without providers", packages: []*api.Package{
+			explainPkg("foo", "1", nil, []string{"bar"}, nil),
+			explainPkg("bar", "1", nil, []string{"missing"}, nil),
+			explainPkg("unrelated", "1", nil, []string{"missing"}, nil),
+		}, requires: []string{
+			"foo",
+		}, explanation: []string{
+			"foo-0:1 is requested",
+			"foo-0:1 requires bar, provided only by bar-0:1",
+			"bar-0:1 requires missing, which nothing provides",
+		}},
+		{name: "requirement of an obsoleted package", packages: []*api.Package{
+			explainPkg("foo", "1", nil, []string{"old", "new"}, nil),
+			explainPkg("old", "1", nil, nil, nil),
+			explainPkg("new", "2", nil, nil, nil, "old"),
+		}, requires: []string{
+			"foo",
+		}, explanation: []string{
+			"foo-0:1 is requested",
+			"foo-0:1 requires new, provided only by new-0:2",
+			"foo-0:1 requires old, provided only by old-0:1",
+			"new-0:2 obsoletes old-0:1",
+		}},
+		{name: "requirements on different versions of a package", packages: []*api.Package{
+			explainPkg("foo", "1", nil, []string{"b", "c"}, nil),
+			explainPkg("bar", "1.1", []string{"b"}, nil, nil),
+			explainPkg("bar", "1.2", []string{"c"}, nil, nil),
+		}, requires: []string{
+			"foo",
+		}, nobest: true, explanation: []string{
+			"foo-0:1 is requested",
+			"foo-0:1 requires b, provided only by bar-0:1.1",
+			"foo-0:1 requires c, provided only by bar-0:1.2",
+			"bar-0:1.2 can't be installed together with bar-0:1.1",
+		}},
+		{name: "rich requirement", packages: []*api.Package{
+			explainPkg("foo", "1", nil, []string{"(bar if baz)", "baz"}, nil),
+			explainPkg("bar", "1", nil, nil, []string{"baz"}),
+			explainPkg("baz", "1", nil, nil, nil),
+		}, requires: []string{
+			"foo",
+		}, explanation: []string{
+			"foo-0:1 is requested",
+			"foo-0:1 requires (bar if baz), provided by bar-0:1, baz-0:1",
+			"bar-0:1 conflicts with baz, provided by baz-0:1",
+			"foo-0:1 requires baz, provided only by baz-0:1",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			model, err := NewLoader().Load(tt.packages, tt.requires, nil, nil, tt.nobest, []string{"x86_64", "noarch"})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			core, err := unsatisfiableCore(model)
+			g.Expect(err).ToNot(HaveOccurred())
+			var explanation []string
+			for _, reason := range chain(core) {
+				explanation = append(explanation, reason.String())
+			}
+			g.Expect(explanation).To(Equal(tt.explanation))
+
+			_, _, _, err = Resolve(model)
+			g.Expect(err).To(MatchError(ContainSubstring(tt.explanation[len(tt.explanation)-1])))
+		})
+	}
+}
+
+func TestExplainSolvable(t *testing.T) {
+	g := NewGomegaWithT(t)
+	model, err := NewLoader().Load([]*api.Package{
+		explainPkg("foo", "1", nil, []string{"bar"}, nil),
+		explainPkg("bar", "1", nil, nil, nil),
+	}, []string{"foo"}, nil, nil, false, []string{"x86_64", "noarch"})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = explain(model)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func explainPkg(name string, version string, provides []string, requires []string, conflicts []string, obsoletes ...string) *api.Package {
+	pkg := &api.Package{Name: name, Version: api.Version{Ver: version}}
+	pkg.Repository = &bazeldnf.Repository{Priority: 99}
+	pkg.Format.Provides.Entries = append(pkg.Format.Provides.Entries, api.Entry{Name: name, Flags: "EQ", Ver: version})
+	for _, p := range provides {
+		pkg.Format.Provides.Entries = append(pkg.Format.Provides.Entries, api.Entry{Name: p})
+	}
+	for _, r := range requires {
+		pkg.Format.Requires.Entries = append(pkg.Format.Requires.Entries, api.Entry{Name: r})
+	}
+	for _, c := range conflicts {
+		pkg.Format.Conflicts.Entries = append(pkg.Format.Conflicts.Entries, api.Entry{Name: c})
+	}
+	for _, o := range obsoletes {
+		pkg.Format.Obsoletes.Entries = append(pkg.Format.Obsoletes.Entries, api.Entry{Name: o})
+	}
+	return pkg
+}
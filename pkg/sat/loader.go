@@ -21,6 +21,12 @@ type Loader struct {
 	m         *Model
 	provides  map[string][]*Var
 	varsCount int
+	// obsoletedBy maps packages to the package variables of the packages
+	// obsoleting them
+	obsoletedBy map[api.PackageKey][]*Var
+	// weakDeps enables satisfying Recommends and reverse Supplements where
+	// possible
+	weakDeps bool
 }
 
 // BestKey groups packages for the purpose of `--nobest` option disabled,
@@ -43,6 +49,13 @@ func MakeBestKey(pkg *api.Package) BestKey {
 	return BestKey{name: pkg.Name, arch: pkg.Arch}
 }
 
+// EnableWeakDeps makes the solver install the packages recommended by the
+// selected packages and the packages supplementing them where possible, like
+// dnf with install_weak_deps=True
+func (loader *Loader) EnableWeakDeps() {
+	loader.weakDeps = true
+}
+
 func NewLoader() *Loader {
 	return &Loader{
 		m: &Model{
@@ -51,8 +64,9 @@ func NewLoader() *Loader {
 			bestPackages:                map[BestKey]*api.Package{},
 			forceIgnoreWithDependencies: map[api.PackageKey]*api.Package{},
 		},
-		provides:  map[string][]*Var{},
-		varsCount: 0,
+		provides:    map[string][]*Var{},
+		varsCount:   0,
+		obsoletedBy: map[api.PackageKey][]*Var{},
 	}
 }
 
@@ -108,6 +122,8 @@ func (loader *Loader) Load(packages []*api.Package, matched, ignoreRegex, allowR
 
 			if !allowed || ignored {
 				packages[i].Format.Requires.Entries = nil
+				packages[i].Format.Recommends.Entries = nil
+				packages[i].Format.Supplements.Entries = nil
 				loader.m.forceIgnoreWithDependencies[pkg.Key()] = packages[i]
 			}
 
@@ -177,16 +193,28 @@ func (loader *Loader) Load(packages []*api.Package, matched, ignoreRegex, allowR
 		for _, res := range resourceVars {
 			ands = append(ands, bf.Eq(bf.Var(pkgVar.satVarName), bf.Var(res.satVarName)))
 		}
+		loader.m.add(ands...)
+
+		loader.m.addConstraints(loader.explodePackageRequires(pkgVar)...)
+		loader.m.addConstraints(loader.explodePackageConflicts(pkgVar)...)
 
-		ands = append(ands, bf.Implies(bf.Var(pkgVar.satVarName), loader.explodePackageRequires(pkgVar)))
-		if conflicts := loader.explodePackageConflicts(pkgVar); conflicts != nil {
-			ands = append(ands, bf.Implies(bf.Var(pkgVar.satVarName), bf.Not(conflicts)))
+		// Obsoleted packages can't be installed together with the package
+		// obsoleting them
+		if obsoleted := loader.explodePackageObsoletes(pkgVar); len(obsoleted) > 0 {
+			for _, o := range obsoleted {
+				loader.obsoletedBy[o.Package.Key()] = append(loader.obsoletedBy[o.Package.Key()], pkgVar)
+			}
+			loader.m.addConstraints(excludes(pkgVar, ReasonObsoletes, "", obsoleted))
 		}
 
 		// Implicit conflicts (with the same package):
-		ands = append(ands, bf.Implies(bf.Var(pkgVar.satVarName), bf.Not(loader.explodeSamePackageConflicts(pkgVar))))
+		if others := loader.explodeSamePackageConflicts(pkgVar); len(others) > 0 {
+			loader.m.addConstraints(excludes(pkgVar, ReasonSamePackage, pkgVar.Package.Name, others))
+		}
 
-		loader.m.ands = append(loader.m.ands, ands...)
+		if loader.weakDeps {
+			loader.m.add(loader.explodeWeakDependencies(pkgVar)...)
+		}
 	}
 	logrus.Infof("Generated %v variables.", len(loader.m.vars))
 
@@ -200,7 +228,7 @@ func (loader *Loader) explodeProvidedResources(pkg *api.Package) (provided []*Re
 	for _, p := range pkg.Format.Provides.Entries {
 		provided = append(provided, &Resource{
 			Name:    p.Name,
-			Version: api.Version{p.Text, p.Epoch, p.Ver, p.Rel},
+			Version: api.Version{Text: p.Text, Epoch: p.Epoch, Ver: p.Ver, Rel: p.Rel},
 		})
 	}
 
@@ -236,53 +264,216 @@ func (loader *Loader) explodePackageToVars(pkg *api.Package, resources []*Resour
 	return pkgVar, resourceVars
 }
 
-// explodePackageRequires builds a formula that could be a right hand side operand to implication.
-// It consists of all direct requirements of a given package, exploded to resources that can satisfy these requirements.
-// Special cases include:
-// - no requirements: returning `bf.True`
-// - can't satisfy requirements (because of lack of providers): returning `bf.False`
-// Both are safe to use in an implication.
-func (loader *Loader) explodePackageRequires(pkgVar *Var) bf.Formula {
-	var requirements [][]*Var
-	ok := true
+// explodePackageRequires builds one implication per direct requirement of a given package, with the requirement
+// exploded to resources that can satisfy it. Rich dependencies are translated to the equivalent boolean formulas,
+// see explodeRichRequires. A requirement which can't be satisfied (because of lack of providers) implies `bf.False`.
+func (loader *Loader) explodePackageRequires(pkgVar *Var) (constraints []constraint) {
 	for _, req := range pkgVar.Package.Format.Requires.Entries {
+		if rpm.IsRichDependency(req.Name) {
+			dep, err := rpm.ParseDependency(req)
+			if err != nil {
+				logrus.Warnf("Package %s has a requirement which can't be parsed, ignoring it: %v", pkgVar.Package, err)
+				continue
+			}
+			var providers []*Var
+			for _, entry := range dep.Entries() {
+				satisfies, _ := loader.explodeSingleRequires(entry)
+				providers = append(providers, satisfies...)
+			}
+			constraints = append(constraints, requires(pkgVar, dep.String(), loader.explodeRichRequires(pkgVar, dep), providers))
+			continue
+		}
 		satisfies, err := loader.explodeSingleRequires(req)
 		if err != nil {
 			logrus.Warnf("Package %s requires %s, but only got %+v", pkgVar.Package, req, loader.provides[req.Name])
-			ok = false
+			constraints = append(constraints, requires(pkgVar, rpm.FormatEntry(req), bf.False, nil))
 			continue
 		}
-		requirements = append(requirements, satisfies)
+		constraints = append(constraints, requires(pkgVar, rpm.FormatEntry(req), varsFormula(satisfies), satisfies))
+	}
+	return constraints
+}
+
+// requires creates the constraint that the package implies the formula of
+// one of its requirements
+func requires(pkgVar *Var, dependency string, formula bf.Formula, providers []*Var) constraint {
+	return constraint{
+		formula: bf.Implies(bf.Var(pkgVar.satVarName), formula),
+		reason:  &Reason{Type: ReasonRequires, Var: pkgVar, Dependency: dependency, Others: providers},
+	}
+}
+
+// excludes creates the constraint that the package can't be installed
+// together with any of the others
+func excludes(pkgVar *Var, reasonType ReasonType, dependency string, others []*Var) constraint {
+	return constraint{
+		formula: bf.Implies(bf.Var(pkgVar.satVarName), bf.Not(varsFormula(others))),
+		reason:  &Reason{Type: reasonType, Var: pkgVar, Dependency: dependency, Others: others},
+	}
+}
+
+// explodeRichRequires translates a rich dependency to a formula. Simple
+// dependencies are satisfied by any of their providers, `(A if B)` becomes
+// `B => A` and `(A unless B)` becomes `!B => A`, with the optional else branch
+// applying if the condition does not hold. `with` and `without` are satisfied
+// by the packages which provide both or only the first capability.
+func (loader *Loader) explodeRichRequires(pkgVar *Var, dep *rpm.Dependency) bf.Formula {
+	var operands []bf.Formula
+	switch dep.Operator {
+	case "":
+		satisfies, err := loader.explodeSingleRequires(dep.Entry)
+		if err != nil {
+			logrus.Debugf("Nothing provides %s, which is part of a rich requirement of %s", dep.Entry, pkgVar.Package)
+			return bf.False
+		}
+		return varsFormula(satisfies)
+	case rpm.OperatorWith, rpm.OperatorWithout:
+		satisfies, err := loader.packagesSatisfying(dep)
+		if err != nil {
+			logrus.Warnf("Package %s requires %s, which can't be satisfied: %v", pkgVar.Package, dep, err)
+			return bf.False
+		}
+		if len(satisfies) == 0 {
+			return bf.False
+		}
+		return varsFormula(satisfies)
 	}
 
-	if !ok {
-		return bf.False
+	for _, operand := range dep.Operands {
+		operands = append(operands, loader.explodeRichRequires(pkgVar, operand))
 	}
+	switch dep.Operator {
+	case rpm.OperatorAnd:
+		return bf.And(operands...)
+	case rpm.OperatorOr:
+		return bf.Or(operands...)
+	case rpm.OperatorIf, rpm.OperatorUnless:
+		condition := operands[1]
+		if dep.Operator == rpm.OperatorUnless {
+			condition = bf.Not(condition)
+		}
+		formula := bf.Implies(condition, operands[0])
+		if len(operands) > 2 {
+			formula = bf.And(formula, bf.Implies(bf.Not(condition), operands[2]))
+		}
+		return formula
+	}
+	logrus.Warnf("Package %s requires %s with the unsupported operator %s", pkgVar.Package, dep, dep.Operator)
+	return bf.False
+}
 
-	var orRequirements []bf.Formula
-	for _, satisfies := range requirements {
-		vars := []bf.Formula{}
+// packagesSatisfying returns the resources of the packages which satisfy all
+// operands of a `with` dependency, or only the first operand of a `without`
+// dependency
+func (loader *Loader) packagesSatisfying(dep *rpm.Dependency) ([]*Var, error) {
+	if dep.IsSimple() {
+		// a capability without providers is satisfied by no package
+		satisfies, _ := loader.explodeSingleRequires(dep.Entry)
+		return satisfies, nil
+	}
+	if dep.Operator != rpm.OperatorWith && dep.Operator != rpm.OperatorWithout {
+		return nil, fmt.Errorf("%s can't be nested in with or without", dep)
+	}
+	candidates, err := loader.packagesSatisfying(dep.Operands[0])
+	if err != nil {
+		return nil, err
+	}
+	var others []map[api.PackageKey]struct{}
+	for _, operand := range dep.Operands[1:] {
+		satisfies, err := loader.packagesSatisfying(operand)
+		if err != nil {
+			return nil, err
+		}
+		packages := map[api.PackageKey]struct{}{}
 		for _, s := range satisfies {
-			vars = append(vars, bf.Var(s.satVarName))
+			packages[s.Context.PackageKey] = struct{}{}
+		}
+		others = append(others, packages)
+	}
+
+	var accepts []*Var
+	for _, candidate := range candidates {
+		matches := true
+		for _, packages := range others {
+			if _, exists := packages[candidate.Context.PackageKey]; exists != (dep.Operator == rpm.OperatorWith) {
+				matches = false
+			}
+		}
+		if matches {
+			accepts = append(accepts, candidate)
+		}
+	}
+	return accepts, nil
+}
+
+func varsFormula(vars []*Var) bf.Formula {
+	formulas := []bf.Formula{}
+	for _, v := range vars {
+		formulas = append(formulas, bf.Var(v.satVarName))
+	}
+	return bf.Or(formulas...)
+}
+
+// explodeWeakDependencies creates a weak variable for every recommendation and
+// supplement of a package which implies the dependency. Resolve adds soft
+// clauses for the weak variables, so that weak dependencies are satisfied
+// where possible, but never at the expense of a hard requirement.
+func (loader *Loader) explodeWeakDependencies(pkgVar *Var) (ands []bf.Formula) {
+	for _, rec := range pkgVar.Package.Format.Recommends.Entries {
+		dep, err := rpm.ParseDependency(rec)
+		if err != nil {
+			logrus.Warnf("Package %s has a recommendation which can't be parsed, ignoring it: %v", pkgVar.Package, err)
+			continue
+		}
+		recommended := loader.explodeRichRequires(pkgVar, dep)
+		if recommended == bf.False {
+			continue
+		}
+		weak := loader.weakVar(pkgVar, "recommends "+dep.String())
+		ands = append(ands, bf.Implies(bf.Var(weak.satVarName), bf.Implies(bf.Var(pkgVar.satVarName), recommended)))
+	}
+	// a package supplementing installed capabilities should be installed too
+	for _, sup := range pkgVar.Package.Format.Supplements.Entries {
+		dep, err := rpm.ParseDependency(sup)
+		if err != nil {
+			logrus.Warnf("Package %s has a supplement which can't be parsed, ignoring it: %v", pkgVar.Package, err)
+			continue
+		}
+		supplemented := loader.explodeRichRequires(pkgVar, dep)
+		if supplemented == bf.False {
+			continue
 		}
-		orRequirements = append(orRequirements, bf.Or(vars...))
+		weak := loader.weakVar(pkgVar, "supplements "+dep.String())
+		ands = append(ands, bf.Implies(bf.Var(weak.satVarName), bf.Implies(supplemented, bf.Var(pkgVar.satVarName))))
 	}
+	return ands
+}
 
-	if orRequirements == nil {
-		// empty `bf.And` doesn't work as expected, hence this special case:
-		return bf.True
+func (loader *Loader) weakVar(pkgVar *Var, dependency string) *Var {
+	weak := &Var{
+		satVarName: loader.ticket(),
+		varType:    VarTypeWeak,
+		Context: VarContext{
+			PackageKey: pkgVar.Package.Key(),
+			Provides:   dependency,
+		},
+		Package: pkgVar.Package,
 	}
-	return bf.And(orRequirements...)
+	loader.m.vars[weak.satVarName] = weak
+	loader.m.weak = append(loader.m.weak, weak)
+	return weak
 }
 
-func (loader *Loader) explodePackageConflicts(pkgVar *Var) bf.Formula {
-	conflictingVars := []bf.Formula{}
+// explodePackageConflicts builds one constraint per conflict of a given
+// package with the packages providing the conflicting resource
+func (loader *Loader) explodePackageConflicts(pkgVar *Var) (constraints []constraint) {
 	for _, req := range pkgVar.Package.Format.Conflicts.Entries {
 		conflicts, err := loader.explodeSingleRequires(req)
 		if err != nil {
 			// if a conflicting resource does not exist, we don't care
 			continue
 		}
+		var conflictingVars []*Var
 		for _, s := range conflicts {
 			if s.Package == pkgVar.Package {
 				// don't conflict with yourself
@@ -292,29 +483,47 @@ func (loader *Loader) explodePackageConflicts(pkgVar *Var) bf.Formula {
 			if !strings.HasPrefix(s.Package.Name, "fedora-release") && !strings.HasPrefix(pkgVar.Package.Name, "fedora-release") {
 				logrus.Infof("%s conflicts with %s", s.Package.String(), pkgVar.Package.String())
 			}
-			conflictingVars = append(conflictingVars, bf.Var(s.satVarName))
+			conflictingVars = append(conflictingVars, s)
+		}
+		if len(conflictingVars) > 0 {
+			constraints = append(constraints, excludes(pkgVar, ReasonConflicts, rpm.FormatEntry(req), conflictingVars))
 		}
 	}
-	if len(conflictingVars) == 0 {
-		return nil
+	return constraints
+}
+
+// explodePackageObsoletes returns the package variables of the packages
+// obsoleted by `pkgVar`. Like in dnf, obsoletes match package names and
+// versions, not provides. Obsoleting older versions of the package itself is
+// already covered by the implicit same package conflicts.
+func (loader *Loader) explodePackageObsoletes(pkgVar *Var) (obsoleted []*Var) {
+	for _, obs := range pkgVar.Package.Format.Obsoletes.Entries {
+		if obs.Name == pkgVar.Package.Name {
+			continue
+		}
+		candidates, err := compareRequires(obs, loader.m.packages[obs.Name])
+		if err != nil {
+			logrus.Warnf("Package %s has an obsoletes which can't be evaluated, ignoring it: %v", pkgVar.Package, err)
+			continue
+		}
+		for _, candidate := range candidates {
+			logrus.Debugf("%s obsoletes %s", pkgVar.Package, candidate.Package)
+			obsoleted = append(obsoleted, candidate)
+		}
 	}
-	return bf.Or(conflictingVars...)
+	return obsoleted
 }
 
-// explodeSamePackageConflicts returns a formula indicating whether there was installed
-// a package of the same name, conflicting with one represented by `pkgVar`.
-func (loader *Loader) explodeSamePackageConflicts(pkgVar *Var) bf.Formula {
-	var conflictingVars []bf.Formula
+// explodeSamePackageConflicts returns the package variables of the packages
+// of the same name, conflicting with one represented by `pkgVar`.
+func (loader *Loader) explodeSamePackageConflicts(pkgVar *Var) (conflictingVars []*Var) {
 	for _, otherVar := range loader.m.packages[pkgVar.Package.Name] {
 		if otherVar.Package == pkgVar.Package { // itself
 			continue
 		}
-		conflictingVars = append(conflictingVars, bf.Var(otherVar.satVarName))
-	}
-	if len(conflictingVars) == 0 {
-		return bf.False
+		conflictingVars = append(conflictingVars, otherVar)
 	}
-	return bf.Or(conflictingVars...)
+	return conflictingVars
 }
 
 func (loader *Loader) explodeSingleRequires(entry api.Entry) (accepts []*Var, err error) {
@@ -343,8 +552,13 @@ func (loader *Loader) constructRequirements(packages []string, archOrder []strin
 		if err != nil {
 			return nil, err
 		}
+		req = loader.resolveObsoleting(req, archOrder)
 		logrus.Infof("Selecting %s: %v", pkgName, req.Package)
-		loader.m.ands = append(loader.m.ands, bf.Var(req.satVarName))
+		loader.m.addConstraints(constraint{
+			formula: bf.Var(req.satVarName),
+			reason:  &Reason{Type: ReasonRequested, Var: req, Dependency: pkgName},
+		})
+		loader.m.requested = append(loader.m.requested, req.Package)
 	}
 	return loader.m, nil
 }
@@ -354,13 +568,37 @@ func (loader *Loader) resolveNewest(pkgName string, archOrder []string) (*Var, e
 	if len(pkgs) == 0 {
 		return nil, fmt.Errorf("package %s does not exist", pkgName)
 	}
-	newest := pkgs[0]
-	for _, p := range pkgs {
-		if rpm.ComparePackage(p.Package, newest.Package, archOrder) > 0 {
-			newest = p
+	return newestVar(pkgs, archOrder), nil
+}
+
+// resolveObsoleting follows the obsoletes of a requested package and returns
+// the newest package obsoleting it, like dnf when a package was renamed.
+// Without obsoleting packages the requested package itself is returned.
+func (loader *Loader) resolveObsoleting(req *Var, archOrder []string) *Var {
+	seen := map[api.PackageKey]struct{}{}
+	for {
+		seen[req.Package.Key()] = struct{}{}
+		obsoleting := loader.obsoletedBy[req.Package.Key()]
+		if len(obsoleting) == 0 {
+			return req
+		}
+		newest := newestVar(obsoleting, archOrder)
+		if _, exists := seen[newest.Package.Key()]; exists {
+			return req
+		}
+		logrus.Infof("%v is obsoleted by %v, selecting it instead", req.Package, newest.Package)
+		req = newest
+	}
+}
+
+func newestVar(vars []*Var, archOrder []string) *Var {
+	newest := vars[0]
+	for _, v := range vars {
+		if rpm.ComparePackage(v.Package, newest.Package, archOrder) > 0 {
+			newest = v
 		}
 	}
-	return newest, nil
+	return newest
 }
 
 func compareRequires(entry api.Entry, provides []*Var) (accepts []*Var, err error) {
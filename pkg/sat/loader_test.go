@@ -563,6 +563,8 @@ func TestLoader_Load(t *testing.T) {
 			{"lower version, lower repo priority", "1.0", 2, "2.0", 1, "B"},
 			{"lower version, higher repo priority", "1.0", 1, "2.0", 2, "A"},
 			{"lower version, higher repo priority 2", "1.0", 1, "2.0", 3, "A"},
+			{"higher version, default repo priority", "2.0", 0, "1.0", 50, "B"},
+			{"lower version, explicit default repo priority", "1.0", 99, "2.0", 0, "B"},
 		}
 
 		for _, tc := range testCases {
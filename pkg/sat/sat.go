@@ -12,6 +12,8 @@ import (
 	"github.com/crillab/gophersat/maxsat"
 	"github.com/rmohr/bazeldnf/pkg/api"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 )
 
 type VarType string
@@ -19,8 +21,15 @@ type VarType string
 const (
 	VarTypePackage  = "Package"
 	VarTypeResource = "Resource" // includes files
+	VarTypeWeak     = "Weak"     // a weak dependency which should be satisfied
 )
 
+// weakDependencyWeight is the weight of the soft clauses of weak
+// dependencies. The weights which prefer newer packages are scaled above the
+// sum of all weak dependencies, so that weak dependencies never cause older
+// packages to be picked.
+const weakDependencyWeight = 1
+
 // VarContext contains all information to create a unique identifyable hash key which can be traced back to a package
 // for every resource in a yum repo
 type VarContext struct {
@@ -40,6 +49,73 @@ func (v Var) String() string {
 	return fmt.Sprintf("%s(%s)", v.Package.String(), v.Context.Provides)
 }
 
+type ReasonType string
+
+const (
+	ReasonRequested   = "Requested"
+	ReasonRequires    = "Requires"
+	ReasonConflicts   = "Conflicts"
+	ReasonObsoletes   = "Obsoletes"
+	ReasonSamePackage = "SamePackage" // only one version of a package can be installed
+)
+
+// Reason describes why a hard clause is part of the model, so that
+// unsolvable problems can be explained in terms of packages
+type Reason struct {
+	Type ReasonType
+	// Var is the package the clause is about
+	Var *Var
+	// Dependency is the requested name, requirement or conflict
+	Dependency string
+	// Others are the providers of a requirement or the packages which
+	// can't be installed together with the package
+	Others []*Var
+}
+
+func (r *Reason) String() string {
+	pkg := r.Var.Package.String()
+	others := packageNames(r.Others)
+	switch r.Type {
+	case ReasonRequested:
+		if r.Dependency != r.Var.Package.Name {
+			return fmt.Sprintf("%s is requested as %s", pkg, r.Dependency)
+		}
+		return fmt.Sprintf("%s is requested", pkg)
+	case ReasonRequires:
+		switch len(others) {
+		case 0:
+			return fmt.Sprintf("%s requires %s, which nothing provides", pkg, r.Dependency)
+		case 1:
+			return fmt.Sprintf("%s requires %s, provided only by %s", pkg, r.Dependency, others[0])
+		}
+		return fmt.Sprintf("%s requires %s, provided by %s", pkg, r.Dependency, strings.Join(others, ", "))
+	case ReasonConflicts:
+		return fmt.Sprintf("%s conflicts with %s, provided by %s", pkg, r.Dependency, strings.Join(others, ", "))
+	case ReasonObsoletes:
+		return fmt.Sprintf("%s obsoletes %s", pkg, strings.Join(others, ", "))
+	case ReasonSamePackage:
+		return fmt.Sprintf("%s can't be installed together with %s", pkg, strings.Join(others, ", "))
+	}
+	return fmt.Sprintf("%s: %s %s", pkg, r.Type, r.Dependency)
+}
+
+// packageNames returns the sorted names of the packages of the variables
+func packageNames(vars []*Var) []string {
+	names := map[string]struct{}{}
+	for _, v := range vars {
+		names[v.Package.String()] = struct{}{}
+	}
+	sorted := maps.Keys(names)
+	slices.Sort(sorted)
+	return sorted
+}
+
+// constraint is a hard clause of the model together with its reason
+type constraint struct {
+	formula bf.Formula
+	reason  *Reason
+}
+
 type Model struct {
 	// packages contains a map which contains all pkg vars which can be looked up by package name
 	// useful for creating soft clauses
@@ -50,6 +126,16 @@ type Model struct {
 
 	bestPackages map[BestKey]*api.Package
 
+	// weak contains the variables of weak dependencies, which become soft clauses
+	weak []*Var
+
+	// requested contains the packages selected for the requested package names
+	requested []*api.Package
+
+	// reasons explain the clauses in ands. Clauses which only tie the
+	// variables of a package together have no reason.
+	reasons []*Reason
+
 	ands                        []bf.Formula
 	forceIgnoreWithDependencies map[api.PackageKey]*api.Package
 }
@@ -66,6 +152,27 @@ func (m *Model) BestPackage(k BestKey) *api.Package {
 	return m.bestPackages[k]
 }
 
+// Requested returns the packages which were selected for the requested
+// package names
+func (m *Model) Requested() []*api.Package {
+	return m.requested
+}
+
+// add appends clauses without a reason
+func (m *Model) add(formulas ...bf.Formula) {
+	for _, f := range formulas {
+		m.ands = append(m.ands, f)
+		m.reasons = append(m.reasons, nil)
+	}
+}
+
+func (m *Model) addConstraints(constraints ...constraint) {
+	for _, c := range constraints {
+		m.ands = append(m.ands, c.formula)
+		m.reasons = append(m.reasons, c.reason)
+	}
+}
+
 func (m *Model) Ands() bf.Formula {
 	return bf.And(m.ands...)
 }
@@ -91,6 +198,11 @@ func Resolve(model *Model) (install []*api.Package, excluded []*api.Package, for
 		satErrChan <- bf.Dimacs(model.Ands(), satWriter)
 	}()
 
+	// scale the version preferences and the hard clauses above the sum of
+	// the weights of all weak dependencies
+	scale := len(model.weak)*weakDependencyWeight + 1
+	hardWeight := 2000 * scale
+
 	go func() {
 		defer close(pwMaxSatErrChan)
 		defer pwMaxSatWriter.Close()
@@ -115,9 +227,9 @@ func Resolve(model *Model) (install []*api.Package, excluded []*api.Package, for
 					}
 				}
 			} else if strings.HasPrefix(line, "p") {
-				line = strings.Replace(line, "p cnf", "p wcnf", 1) + " 2000"
+				line = fmt.Sprintf("%s %d", strings.Replace(line, "p cnf", "p wcnf", 1), hardWeight)
 			} else {
-				line = "2000 " + line
+				line = fmt.Sprintf("%d %s", hardWeight, line)
 			}
 			if _, err := fmt.Fprintln(pwMaxSatWriter, line); err != nil {
 				pwMaxSatErrChan <- err
@@ -133,7 +245,7 @@ func Resolve(model *Model) (install []*api.Package, excluded []*api.Package, for
 					pkgVar := pkg.satVarName
 					satVar := vars.pkgToSat[pkgVar]
 					fmt.Fprintf(pwMaxSatWriter, "c not %s,%s,%s\n", pkg.Package.String(), pkgVar, satVar)
-					fmt.Fprintf(pwMaxSatWriter, "%d -%s 0\n", weight, satVar)
+					fmt.Fprintf(pwMaxSatWriter, "%d -%s 0\n", weight*scale, satVar)
 
 					if weight > 0 {
 						weight -= 100
@@ -141,6 +253,15 @@ func Resolve(model *Model) (install []*api.Package, excluded []*api.Package, for
 				}
 			}
 		}
+		// satisfy weak dependencies where possible
+		for _, weak := range model.weak {
+			satVar, exists := vars.pkgToSat[weak.satVarName]
+			if !exists {
+				continue
+			}
+			fmt.Fprintf(pwMaxSatWriter, "c weak %s\n", weak)
+			fmt.Fprintf(pwMaxSatWriter, "%d %s 0\n", weakDependencyWeight, satVar)
+		}
 	}()
 
 	logrus.Info("Loading the Partial weighted MAXSAT problem.")
@@ -178,7 +299,7 @@ func Resolve(model *Model) (install []*api.Package, excluded []*api.Package, for
 			}
 			modelVarId, err := strconv.Atoi(satVarName)
 			if err != nil {
-				logrus.Errorf("Invalid satVarName", satVarName)
+				logrus.Errorf("Invalid satVarName %s", satVarName)
 				continue
 			}
 			// Offset of `1`. The model index starts with 0, but the variable sequence starts with 1, since 0 is not allowed
@@ -210,7 +331,12 @@ func Resolve(model *Model) (install []*api.Package, excluded []*api.Package, for
 		return install, excluded, forceIgnoredWithDependencies, nil
 	}
 	logrus.Info("No solution found.")
-	return nil, nil, nil, fmt.Errorf("no solution found")
+	explanation, err := explain(model)
+	if err != nil {
+		logrus.Warnf("Failed to explain why there is no solution: %v", err)
+		return nil, nil, nil, fmt.Errorf("no solution found")
+	}
+	return nil, nil, nil, fmt.Errorf("no solution found, the following can't be satisfied together:\n%s", explanation)
 }
 
 type ConversionVars struct {
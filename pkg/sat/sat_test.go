@@ -4,6 +4,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"os"
+	"strconv"
 	"testing"
 
 	. "github.com/onsi/gomega"
@@ -1258,6 +1259,7 @@ func TestNewResolver(t *testing.T) {
 		solvable      bool
 		focus         bool
 		nobest        bool
+		weakDeps      bool
 	}{
 		{name: "with indirect dependency", packages: []*api.Package{
 			newPkg("testa", "1", []string{"testa", "a", "b"}, []string{"d", "g"}, []string{}),
@@ -1494,6 +1496,179 @@ func TestNewResolver(t *testing.T) {
 			solvable:      true,
 		},
 
+		// Rich dependencies:
+		{name: "rich dependency with satisfied condition", packages: []*api.Package{
+			newPkg("testa", "1", []string{}, []string{"(b if c)", "testc"}, []string{}),
+			newPkg("testb", "1", []string{"b"}, []string{}, []string{}),
+			newPkg("testc", "1", []string{"c"}, []string{}, []string{}),
+		}, requires: []string{
+			"testa",
+		},
+			install:  []string{"testa-0:1", "testb-0:1", "testc-0:1"},
+			exclude:  []string{},
+			solvable: true,
+		},
+		{name: "rich dependency with unsatisfied condition", packages: []*api.Package{
+			newPkg("testa", "1", []string{}, []string{"(b if c else d)"}, []string{}),
+			newPkg("testb", "1", []string{"b"}, []string{}, []string{}),
+			newPkg("testc", "1", []string{"c"}, []string{"missing"}, []string{}),
+			newPkg("testd", "1", []string{"d"}, []string{}, []string{}),
+		}, requires: []string{
+			"testa",
+		},
+			install:  []string{"testa-0:1", "testd-0:1"},
+			exclude:  []string{"testb-0:1", "testc-0:1"},
+			solvable: true,
+		},
+		{name: "rich dependency with unsatisfiable alternatives", packages: []*api.Package{
+			newPkg("testa", "1", []string{}, []string{"(b or c)"}, []string{}),
+			newPkg("testb", "1", []string{"b"}, []string{"missing"}, []string{}),
+		}, requires: []string{
+			"testa",
+		},
+			solvable: false,
+		},
+		{name: "rich dependency with and without", packages: []*api.Package{
+			newPkg("testa", "1", []string{}, []string{"(b with c)", "(d without e)"}, []string{}),
+			newPkg("testb", "1", []string{"b"}, []string{}, []string{}),
+			newPkg("testc", "1", []string{"c"}, []string{}, []string{"testb"}),
+			newPkg("testbc", "1", []string{"b", "c"}, []string{}, []string{}),
+			newPkg("testde", "1", []string{"d", "e"}, []string{}, []string{}),
+			newPkg("testd", "1", []string{"d"}, []string{}, []string{"testde"}),
+		}, requires: []string{
+			"testa",
+		},
+			install:  []string{"testa-0:1", "testbc-0:1", "testd-0:1"},
+			exclude:  []string{"testb-0:1", "testc-0:1", "testde-0:1"},
+			solvable: true,
+		},
+		{name: "rich dependency with an unparsable expression is ignored", packages: []*api.Package{
+			newPkg("testa", "1", []string{}, []string{"(b or"}, []string{}),
+		}, requires: []string{
+			"testa",
+		},
+			install:  []string{"testa-0:1"},
+			exclude:  []string{},
+			solvable: true,
+		},
+
+		// Weak dependencies:
+		{name: "recommended package is installed with weak dependencies", packages: []*api.Package{
+			withRecommends(newPkg("testa", "1", []string{}, []string{}, []string{}), "b", "(c if testa)"),
+			newPkg("testb", "1", []string{"b"}, []string{}, []string{}),
+			newPkg("testc", "1", []string{"c"}, []string{}, []string{}),
+		}, requires: []string{
+			"testa",
+		},
+			install:  []string{"testa-0:1", "testb-0:1", "testc-0:1"},
+			exclude:  []string{},
+			solvable: true,
+			weakDeps: true,
+		},
+		{name: "recommended package is not installed without weak dependencies", packages: []*api.Package{
+			withRecommends(newPkg("testa", "1", []string{}, []string{}, []string{}), "b"),
+			newPkg("testb", "1", []string{"b"}, []string{}, []string{}),
+		}, requires: []string{
+			"testa",
+		},
+			install:  []string{"testa-0:1"},
+			exclude:  []string{"testb-0:1"},
+			solvable: true,
+		},
+		{name: "unsatisfiable recommendation is skipped", packages: []*api.Package{
+			withRecommends(newPkg("testa", "1", []string{}, []string{}, []string{}), "b", "missing"),
+			newPkg("testb", "1", []string{"b"}, []string{"missing"}, []string{}),
+		}, requires: []string{
+			"testa",
+		},
+			install:  []string{"testa-0:1"},
+			exclude:  []string{"testb-0:1"},
+			solvable: true,
+			weakDeps: true,
+		},
+		{name: "supplementing package is installed with weak dependencies", packages: []*api.Package{
+			newPkg("testa", "1", []string{"a"}, []string{}, []string{}),
+			withSupplements(newPkg("testb", "1", []string{}, []string{}, []string{}), "(a and langpacks-en)"),
+			newPkg("langpacks-en", "1", []string{"langpacks-en"}, []string{}, []string{}),
+		}, requires: []string{
+			"testa", "langpacks-en",
+		},
+			install:  []string{"testa-0:1", "testb-0:1", "langpacks-en-0:1"},
+			exclude:  []string{},
+			solvable: true,
+			weakDeps: true,
+		},
+		{name: "weak dependencies don't cause older packages to be picked", packages: append(
+			func() (pkgs []*api.Package) {
+				for i := 1; i <= 20; i++ {
+					pkg := newPkg("testa", strconv.Itoa(i), []string{}, []string{}, []string{})
+					if i == 19 {
+						pkg.Format.Provides.Entries = append(pkg.Format.Provides.Entries, api.Entry{Name: "feature"})
+					}
+					pkgs = append(pkgs, pkg)
+				}
+				return append(pkgs, newPkg("testb", "1", []string{}, []string{"testa"}, []string{}))
+			}(),
+			func() (pkgs []*api.Package) {
+				for i := 0; i < 150; i++ {
+					pkgs = append(pkgs, withRecommends(newPkg(fmt.Sprintf("testrec%d", i), "1", []string{}, []string{}, []string{}), "feature"))
+				}
+				return pkgs
+			}()...,
+		), requires: append([]string{"testb"}, func() (names []string) {
+			for i := 0; i < 150; i++ {
+				names = append(names, fmt.Sprintf("testrec%d", i))
+			}
+			return names
+		}()...),
+			install: append([]string{"testa-0:20", "testb-0:1"}, func() (names []string) {
+				for i := 0; i < 150; i++ {
+					names = append(names, fmt.Sprintf("testrec%d-0:1", i))
+				}
+				return names
+			}()...),
+			exclude: func() (names []string) {
+				for i := 1; i < 20; i++ {
+					names = append(names, fmt.Sprintf("testa-0:%d", i))
+				}
+				return names
+			}(),
+			solvable: true,
+			nobest:   true,
+			weakDeps: true,
+		},
+
+		// Obsoletes:
+		{name: "requesting an obsoleted package selects the obsoleting one", packages: []*api.Package{
+			newPkg("testold", "1", []string{}, []string{}, []string{}),
+			withObsoletes(newPkg("testnew", "2", []string{}, []string{}, []string{}), api.Entry{Name: "testold"}),
+		}, requires: []string{
+			"testold",
+		},
+			install:  []string{"testnew-0:2"},
+			exclude:  []string{"testold-0:1"},
+			solvable: true,
+		},
+		{name: "obsoleted package can't be installed with the obsoleting one", packages: []*api.Package{
+			newPkg("testa", "1", []string{}, []string{"testold", "testnew"}, []string{}),
+			newPkg("testold", "1", []string{}, []string{}, []string{}),
+			withObsoletes(newPkg("testnew", "2", []string{}, []string{}, []string{}), api.Entry{Name: "testold"}),
+		}, requires: []string{
+			"testa",
+		},
+			solvable: false,
+		},
+		{name: "versioned obsoletes only apply to matching versions", packages: []*api.Package{
+			newPkg("testold", "1", []string{}, []string{}, []string{}),
+			withObsoletes(newPkg("testnew", "2", []string{}, []string{}, []string{}), api.Entry{Name: "testold", Flags: "LT", Ver: "1"}),
+		}, requires: []string{
+			"testold", "testnew",
+		},
+			install:  []string{"testold-0:1", "testnew-0:2"},
+			exclude:  []string{},
+			solvable: true,
+		},
+
 		// TODO: Add test cases.
 	}
 	focus := false
@@ -1509,6 +1684,9 @@ func TestNewResolver(t *testing.T) {
 		}
 		t.Run(tt.name, func(t *testing.T) {
 			loader := NewLoader()
+			if tt.weakDeps {
+				loader.EnableWeakDeps()
+			}
 			architectures := tt.architectures
 			if len(architectures) == 0 {
 				architectures = []string{"x86_64", "noarch"}
@@ -1564,6 +1742,25 @@ func newPkg(name string, version string, provides []string, requires []string, c
 	return newPkgAP(name, version, "", 99, provides, requires, conflicts)
 }
 
+func withRecommends(pkg *api.Package, recommends ...string) *api.Package {
+	for _, rec := range recommends {
+		pkg.Format.Recommends.Entries = append(pkg.Format.Recommends.Entries, api.Entry{Name: rec})
+	}
+	return pkg
+}
+
+func withSupplements(pkg *api.Package, supplements ...string) *api.Package {
+	for _, sup := range supplements {
+		pkg.Format.Supplements.Entries = append(pkg.Format.Supplements.Entries, api.Entry{Name: sup})
+	}
+	return pkg
+}
+
+func withObsoletes(pkg *api.Package, obsoletes ...api.Entry) *api.Package {
+	pkg.Format.Obsoletes.Entries = append(pkg.Format.Obsoletes.Entries, obsoletes...)
+	return pkg
+}
+
 func strToPkg(wanted []string, given []*api.Package) (resolved []*api.Package) {
 	m := map[string]*api.Package{}
 	for _, p := range given {